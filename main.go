@@ -1,11 +1,16 @@
 package main
 
 import (
+	"context"
 	"log"
 	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
 
 	"github.com/joho/godotenv"
-	openai "github.com/sashabaranov/go-openai"
+	"github.com/redis/go-redis/v9"
 
 	"hackathon/service"
 )
@@ -20,19 +25,128 @@ func main() {
 		log.Fatalf("config error: %v", err)
 	}
 
-	openaiClient := openai.NewClient(cfg.OpenAIAPIKey)
+	openaiClient := service.NewOpenAIClient(cfg)
 	evoClient := service.NewEvolutionClient(cfg)
-	conversationStore, err := service.NewConversationStore(cfg)
-	if err != nil {
-		log.Fatalf("redis error: %v", err)
+
+	var redisClient *redis.Client
+	if cfg.RedisAddr != "" {
+		redisClient, err = service.NewRedisClient(cfg)
+		if err != nil {
+			log.Fatalf("redis error: %v", err)
+		}
+		defer redisClient.Close()
+	}
+
+	var conversationStore service.ConversationStore
+	switch cfg.StoreBackend {
+	case "postgres":
+		pgStore, err := service.NewPostgresConversationStore(cfg)
+		if err != nil {
+			log.Fatalf("postgres error: %v", err)
+		}
+		conversationStore = pgStore
+	default:
+		conversationStore = service.NewRedisConversationStore(redisClient, cfg)
 	}
 	defer conversationStore.Close()
 
-	http.HandleFunc("/webhook", service.WebhookHandler(openaiClient, evoClient, conversationStore, cfg))
+	configs := service.NewConfigHolder(cfg)
+	go reloadOnSIGHUP(configs)
+
+	bot := &service.Bot{
+		OpenAI:    openaiClient,
+		Evolution: evoClient,
+		Store:     conversationStore,
+		Configs:   configs,
+	}
+	if cfg.HandoffWebhookURL != "" {
+		bot.Handoff = service.NewHandoffNotifier(cfg, redisClient)
+	}
+	if redisClient != nil && cfg.OutboundQueueMax > 0 {
+		bot.Outbound = service.NewOutboundQueue(redisClient, cfg)
+	}
+	if redisClient != nil && cfg.GenerationRetryMax > 0 {
+		bot.Retries = service.NewGenerationRetryQueue(redisClient, cfg)
+	}
+	if redisClient != nil && cfg.ReplyCacheTTL > 0 {
+		bot.Replies = service.NewReplyCache(redisClient, cfg.ReplyCacheTTL)
+	}
+	if redisClient != nil {
+		bot.Quota = service.NewDailyQuota(redisClient)
+		bot.Languages = service.NewLanguageStore(redisClient)
+		bot.Overrides = service.NewOverrideStore(redisClient)
+	}
+	bot.KillSwitch = service.NewKillSwitch(redisClient)
+	bot.Handlers = service.NewHandlerRegistry()
+	service.RegisterDefaultHandlers(bot.Handlers)
+	if cfg.TranscribeMaxConcurrency > 0 {
+		bot.Transcriber = service.NewTranscriber(openaiClient, evoClient, cfg.TranscribeMaxConcurrency)
+	}
+	bot.Confidence = service.NewConfidenceScorer(openaiClient, cfg.ConfidenceGate)
+	if cfg.MirrorWebhookURL != "" {
+		bot.Mirror = service.NewMirrorSink(cfg.MirrorWebhookURL)
+	}
+	if cfg.WebhookHistory > 0 {
+		bot.History = service.NewWebhookHistory(cfg.WebhookHistory)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	if cfg.KeepAliveInterval > 0 {
+		go service.NewKeepAlive(redisClient, bot, cfg.KeepAliveInterval).Run(ctx)
+	}
+	if bot.Retries != nil {
+		go bot.Retries.Run(ctx, bot)
+	}
+
+	http.HandleFunc("POST /webhook", service.WebhookHandler(bot))
+	http.HandleFunc("POST /admin/pause", service.PauseHandler(bot, true))
+	http.HandleFunc("POST /admin/resume", service.PauseHandler(bot, false))
+	http.HandleFunc("GET /admin/export", service.ExportHandler(bot))
+	http.HandleFunc("POST /admin/import", service.ImportHandler(bot))
+	http.HandleFunc("GET /config/model", service.ModelHandler(bot))
+	http.HandleFunc("PUT /config/model", service.SetModelHandler(bot))
+	http.HandleFunc("GET /debug/webhooks", service.WebhookHistoryHandler(bot))
+	http.HandleFunc("POST /debug/replay/{id}", service.WebhookReplayHandler(bot))
 
 	addr := ":8080"
+	server := &http.Server{Addr: addr}
+
+	shutdownDone := make(chan struct{})
+	go func() {
+		defer close(shutdownDone)
+		<-ctx.Done()
+		log.Print("server shutting down")
+
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		if err := server.Shutdown(shutdownCtx); err != nil {
+			log.Printf("server shutdown error: %v", err)
+		}
+	}()
+
 	log.Printf("server listening on %s", addr)
-	if err := http.ListenAndServe(addr, nil); err != nil {
+	if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
 		log.Fatalf("server error: %v", err)
 	}
+	<-shutdownDone
+}
+
+func reloadOnSIGHUP(configs *service.ConfigHolder) {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGHUP)
+
+	for range signals {
+		log.Print("config: SIGHUP received, reloading")
+		// Overload so edits to .env take effect over the values loaded at startup.
+		if err := godotenv.Overload(); err != nil {
+			log.Printf("config: .env not reloaded (%v)", err)
+		}
+		if err := configs.Reload(); err != nil {
+			log.Printf("config: reload rejected, keeping current config: %v", err)
+			continue
+		}
+		log.Print("config: reload applied")
+	}
 }
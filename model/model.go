@@ -1,17 +1,134 @@
 package model
 
-import "encoding/json"
+import (
+	"crypto/tls"
+	"encoding/json"
+	"regexp"
+	"time"
+)
 
 type Config struct {
 	EvolutionAPIURL   string
 	EvolutionAPIKey   string
 	EvolutionInstance string
+	AllowedInstances  []string
 	OpenAIAPIKey      string
+	AdminAPIKey       string
 	OpenAIVoice       string
+	ReplyMode         string
+	AudioChunkChars   int
 	OpenAIModel       string
+	OpenAIStream      bool
+	StreamRecovery    string
+	SanitizeMarkdown  bool
+	MarkReadTiming    string
 	RedisAddr         string
 	RedisPassword     string
 	RedisDB           int
+	StoreBackend      string
+	PostgresDSN       string
+	MaxConversations  int
+	KeepAliveInterval time.Duration
+	WebhookHistory    int
+
+	OutboundQueueMax    int
+	OutboundQueueMaxAge time.Duration
+	ReplyCacheTTL       time.Duration
+
+	EvolutionTLS               *tls.Config
+	EvolutionTLSCertFile       string
+	EvolutionTLSKeyFile        string
+	EvolutionTLSCAFile         string
+	EvolutionRateLimitCooldown time.Duration
+	StreamContinuationNote     string
+
+	RetryJitter         string
+	RetryBaseDelay      time.Duration
+	RetryMaxDelay       time.Duration
+	OpenAIMaxRetries    int
+	EvolutionMaxRetries int
+
+	OpenAIStop             []string
+	OpenAIPresencePenalty  float32
+	OpenAIFrequencyPenalty float32
+	OpenAIEmptyRetries     int
+	OpenAISeed             *int
+	LengthContinuations    int
+	ContentFilterMessage   string
+
+	InboundFilter   *regexp.Regexp
+	ReactionActions map[string]string
+	RecordFromMe    bool
+
+	GroupSpeakerLabels bool
+
+	MessageTimeout time.Duration
+	TimeoutMessage string
+
+	HumanizeProfile           string
+	HumanizeReadDelay         time.Duration
+	HumanizeTypingDelay       time.Duration
+	HumanizeReplyDelayPerChar time.Duration
+	HumanizeMaxReplyDelay     time.Duration
+
+	ThinkingPlaceholder      string
+	ThinkingPlaceholderDelay time.Duration
+
+	DailyMessageQuota    int
+	QuotaLocation        *time.Location
+	QuotaExceededMessage string
+
+	HandoffWebhookURL    string
+	HandoffKeywords      []string
+	HandoffMarker        string
+	HandoffMessage       string
+	HandoffPauseDuration time.Duration
+
+	MirrorWebhookURL string
+
+	OpenAIAllowedModels []string
+
+	GenerationRetryMax      int
+	GenerationRetryMaxAge   time.Duration
+	GenerationRetryInterval time.Duration
+
+	ConfidenceGate       string
+	ConfidenceThreshold  float64
+	LowConfidenceAction  string
+	LowConfidenceMessage string
+
+	OpenAIOrgID     string
+	OpenAIProjectID string
+
+	AllowMissingInstance bool
+
+	DebugLogging bool
+
+	AdminNumbers        []string
+	UnauthorizedMessage string
+
+	EphemeralPersistence string
+
+	LanguageDetection string
+	DefaultLanguage   string
+
+	PromptDateTime bool
+	PromptLocation *time.Location
+
+	TranscribeMaxConcurrency int
+	TranscribeWhenBusy       string
+	MediaMaxBytes            int64
+	MediaAllowedHosts        []string
+
+	OfficeHours         []OfficeHoursWindow
+	OfficeHoursLocation *time.Location
+	AfterHoursMessage   string
+}
+
+type OfficeHoursWindow struct {
+	Weekday time.Weekday
+	Start   time.Duration
+	End     time.Duration
 }
 
 type WebhookPayload struct {
@@ -25,6 +142,20 @@ type WebhookPayload struct {
 	APIKey      string          `json:"apikey"`
 }
 
+type ConnectionUpdateData struct {
+	Instance     string `json:"instance"`
+	State        string `json:"state"`
+	StatusReason int    `json:"statusReason"`
+}
+
+// SendMessageResponse is the part of Evolution's send response we use. Key is
+// zero-valued when the response does not include one.
+type SendMessageResponse struct {
+	Key         WebhookKey `json:"key"`
+	Status      string     `json:"status"`
+	MessageType string     `json:"messageType"`
+}
+
 type WebhookData struct {
 	Sender      string         `json:"sender"`
 	RemoteJID   string         `json:"remoteJid"`
@@ -35,9 +166,10 @@ type WebhookData struct {
 }
 
 type WebhookKey struct {
-	RemoteJID string `json:"remoteJid"`
-	FromMe    bool   `json:"fromMe"`
-	ID        string `json:"id"`
+	RemoteJID   string `json:"remoteJid"`
+	FromMe      bool   `json:"fromMe"`
+	ID          string `json:"id"`
+	Participant string `json:"participant,omitempty"`
 }
 
 type WebhookMessage struct {
@@ -50,18 +182,41 @@ type WebhookMessage struct {
 	ExtendedText               string                      `json:"extendedText"`
 	Text                       string                      `json:"text"`
 	Audio                      *WebhookAudio               `json:"audio,omitempty"`
+	AudioMessage               *AudioMessage               `json:"audioMessage,omitempty"`
 	MessageSender              string                      `json:"sender,omitempty"`
 	ExtendedTextMessage        *ExtendedTextMessage        `json:"extendedTextMessage,omitempty"`
 	ButtonsResponseMessage     *ButtonsResponseMessage     `json:"buttonsResponseMessage,omitempty"`
+	TemplateButtonReplyMessage *TemplateButtonReplyMessage `json:"templateButtonReplyMessage,omitempty"`
 	InteractiveResponseMessage *InteractiveResponseMessage `json:"interactiveResponseMessage,omitempty"`
+	VideoMessage               *VideoMessage               `json:"videoMessage,omitempty"`
+	DocumentMessage            *DocumentMessage            `json:"documentMessage,omitempty"`
+	ReactionMessage            *ReactionMessage            `json:"reactionMessage,omitempty"`
+	EphemeralMessage           *EphemeralMessage           `json:"ephemeralMessage,omitempty"`
+	ProtocolMessage            *ProtocolMessage            `json:"protocolMessage,omitempty"`
+	ContextInfo                *ContextInfo                `json:"contextInfo,omitempty"`
+	MediaURL                   string                      `json:"mediaUrl,omitempty"`
 }
 
 type WebhookAudio struct {
 	URL string `json:"url"`
 }
 
+// AudioMessage is a received audio file; PTT is set for recorded voice notes.
+type AudioMessage struct {
+	Mimetype string `json:"mimetype"`
+	Seconds  int    `json:"seconds"`
+	PTT      bool   `json:"ptt"`
+}
+
 type ExtendedTextMessage struct {
-	Text string `json:"text"`
+	Text        string       `json:"text"`
+	ContextInfo *ContextInfo `json:"contextInfo,omitempty"`
+}
+
+// ContextInfo carries per-message chat metadata. Expiration is the chat's
+// disappearing-messages timer in seconds, zero when disabled.
+type ContextInfo struct {
+	Expiration int64 `json:"expiration"`
 }
 
 type ButtonsResponseMessage struct {
@@ -69,6 +224,14 @@ type ButtonsResponseMessage struct {
 	SelectedDisplayText string `json:"selectedDisplayText"`
 }
 
+// TemplateButtonReplyMessage is sent when a user taps a quick-reply button of
+// an approved template (HSM) message.
+type TemplateButtonReplyMessage struct {
+	SelectedID          string `json:"selectedId"`
+	SelectedDisplayText string `json:"selectedDisplayText"`
+	SelectedIndex       int    `json:"selectedIndex"`
+}
+
 type InteractiveResponseMessage struct {
 	Body *InteractiveBody `json:"body"`
 }
@@ -77,6 +240,37 @@ type InteractiveBody struct {
 	Text string `json:"text"`
 }
 
+type VideoMessage struct {
+	Caption  string `json:"caption"`
+	Mimetype string `json:"mimetype"`
+}
+
+type DocumentMessage struct {
+	Caption  string `json:"caption"`
+	FileName string `json:"fileName"`
+	Mimetype string `json:"mimetype"`
+}
+
+type ReactionMessage struct {
+	Key               WebhookKey `json:"key"`
+	Text              string     `json:"text"`
+	SenderTimestampMs int64      `json:"senderTimestampMs"`
+}
+
+// EphemeralMessage wraps a message sent in a chat with disappearing messages
+// turned on.
+type EphemeralMessage struct {
+	Message *WebhookMessage `json:"message"`
+}
+
+// ProtocolMessage is a control message. Type is the enum name or number,
+// depending on the Evolution version; an EPHEMERAL_SETTING message sets the
+// chat's disappearing-messages timer to EphemeralExpiration seconds.
+type ProtocolMessage struct {
+	Type                json.RawMessage `json:"type"`
+	EphemeralExpiration int64           `json:"ephemeralExpiration"`
+}
+
 type MessagesUpsertData struct {
 	Messages   []MessagesUpsertEntry `json:"messages"`
 	Type       string                `json:"type"`
@@ -89,4 +283,5 @@ type MessagesUpsertEntry struct {
 	MessageType      string         `json:"messageType"`
 	MessageTimestamp int64          `json:"messageTimestamp"`
 	PushName         string         `json:"pushName"`
+	ContextInfo      *ContextInfo   `json:"contextInfo,omitempty"`
 }
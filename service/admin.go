@@ -0,0 +1,94 @@
+package service
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+)
+
+// requireAdmin guards next with the ADMIN_API_KEY, sent as a bearer token.
+// Admin endpoints are disabled entirely while no key is configured.
+func requireAdmin(configs *ConfigHolder, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		adminKey := configs.Load().AdminAPIKey
+		if adminKey == "" {
+			http.NotFound(w, r)
+			return
+		}
+
+		token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || subtle.ConstantTimeCompare([]byte(token), []byte(adminKey)) != 1 {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		next(w, r)
+	}
+}
+
+func writeJSON(w http.ResponseWriter, status int, body any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(body); err != nil {
+		log.Printf("admin response encode error: %v", err)
+	}
+}
+
+// PauseHandler serves POST /admin/pause and POST /admin/resume, toggling the
+// global kill switch.
+func PauseHandler(bot *Bot, paused bool) http.HandlerFunc {
+	return requireAdmin(bot.Configs, func(w http.ResponseWriter, r *http.Request) {
+		if err := bot.KillSwitch.SetPaused(r.Context(), paused); err != nil {
+			log.Printf("kill switch update failed: %v", err)
+			http.Error(w, "failed to update kill switch", http.StatusInternalServerError)
+			return
+		}
+
+		log.Printf("kill switch: paused=%t (remote=%s)", paused, r.RemoteAddr)
+		writeJSON(w, http.StatusOK, map[string]bool{"paused": paused})
+	})
+}
+
+// ModelHandler serves GET /config/model, reporting the OpenAI model used for
+// every reply.
+func ModelHandler(bot *Bot) http.HandlerFunc {
+	return requireAdmin(bot.Configs, func(w http.ResponseWriter, r *http.Request) {
+		writeModel(w, bot.Configs)
+	})
+}
+
+// SetModelHandler serves PUT /config/model, switching the OpenAI model used
+// for every reply.
+func SetModelHandler(bot *Bot) http.HandlerFunc {
+	return requireAdmin(bot.Configs, func(w http.ResponseWriter, r *http.Request) {
+		var body struct {
+			Model string `json:"model"`
+		}
+		if err := json.NewDecoder(io.LimitReader(r.Body, 1<<10)).Decode(&body); err != nil {
+			http.Error(w, "invalid body", http.StatusBadRequest)
+			return
+		}
+
+		if err := bot.Configs.SetModel(strings.TrimSpace(body.Model)); err != nil {
+			writeJSON(w, http.StatusBadRequest, map[string]any{
+				"error":   err.Error(),
+				"allowed": bot.Configs.Load().OpenAIAllowedModels,
+			})
+			return
+		}
+		log.Printf("openai model switched to %s (remote=%s)", body.Model, r.RemoteAddr)
+
+		writeModel(w, bot.Configs)
+	})
+}
+
+func writeModel(w http.ResponseWriter, configs *ConfigHolder) {
+	cfg := configs.Load()
+	writeJSON(w, http.StatusOK, map[string]any{
+		"model":   cfg.OpenAIModel,
+		"allowed": cfg.OpenAIAllowedModels,
+	})
+}
@@ -0,0 +1,131 @@
+package service
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// adminRequest calls h as an admin client with the given bearer token.
+func adminRequest(h http.HandlerFunc, method, target, token, body string) *httptest.ResponseRecorder {
+	var reader io.Reader
+	if body != "" {
+		reader = strings.NewReader(body)
+	}
+	req := httptest.NewRequest(method, target, reader)
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	rec := httptest.NewRecorder()
+	h(rec, req)
+	return rec
+}
+
+func TestRequireAdmin(t *testing.T) {
+	cfg := testConfig("")
+	configs := NewConfigHolder(cfg)
+	ok := requireAdmin(configs, func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	})
+
+	for _, tc := range []struct {
+		token string
+		want  int
+	}{
+		{"admin-key", http.StatusNoContent},
+		{"wrong", http.StatusUnauthorized},
+		{"", http.StatusUnauthorized},
+	} {
+		if rec := adminRequest(ok, http.MethodPost, "/admin/pause", tc.token, ""); rec.Code != tc.want {
+			t.Errorf("token %q: status %d, want %d", tc.token, rec.Code, tc.want)
+		}
+	}
+
+	cfg.AdminAPIKey = ""
+	if rec := adminRequest(ok, http.MethodPost, "/admin/pause", "", ""); rec.Code != http.StatusNotFound {
+		t.Errorf("without ADMIN_API_KEY: status %d, want 404", rec.Code)
+	}
+}
+
+func TestPauseAndResumeReplies(t *testing.T) {
+	evo, oa := newFakeEvolution(t), newFakeOpenAI(t)
+	cfg := testConfig(evo.URL)
+	_, client := newTestRedis(t)
+	bot := newTestBot(cfg, evo, oa)
+	bot.KillSwitch = NewKillSwitch(client)
+
+	deliver := func(id string) {
+		t.Helper()
+		msg, key := textMessage(id, "hi")
+		if err := bot.handlePayload(context.Background(), upsertPayload(t, "bot", messageEntry(msg, key))); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if rec := adminRequest(PauseHandler(bot, true), http.MethodPost, "/admin/pause", "wrong", ""); rec.Code != http.StatusUnauthorized {
+		t.Fatalf("pause with a wrong key: status %d", rec.Code)
+	}
+	deliver("in-1")
+
+	if rec := adminRequest(PauseHandler(bot, true), http.MethodPost, "/admin/pause", "admin-key", ""); rec.Code != http.StatusOK {
+		t.Fatalf("pause: status %d", rec.Code)
+	}
+	deliver("in-2")
+
+	// The pause is stored in Redis, so other replicas see it too.
+	if !NewKillSwitch(client).Paused(context.Background()) {
+		t.Error("pause not visible through Redis")
+	}
+
+	if rec := adminRequest(PauseHandler(bot, false), http.MethodPost, "/admin/resume", "admin-key", ""); rec.Code != http.StatusOK {
+		t.Fatalf("resume: status %d", rec.Code)
+	}
+	deliver("in-3")
+
+	if got := len(evo.Texts()); got != 2 {
+		t.Errorf("sent %d replies, want 2: before the pause and after the resume", got)
+	}
+	if got := len(oa.Requests()); got != 2 {
+		t.Errorf("OpenAI called %d times, want 2", got)
+	}
+}
+
+func TestSetModelHandler(t *testing.T) {
+	evo, oa := newFakeEvolution(t), newFakeOpenAI(t)
+	cfg := testConfig(evo.URL)
+	cfg.OpenAIAllowedModels = []string{"gpt-4o-mini", "gpt-4o"}
+	bot := newTestBot(cfg, evo, oa)
+
+	for _, tc := range []struct {
+		token, body string
+		want        int
+	}{
+		{"", `{"model":"gpt-4o"}`, http.StatusUnauthorized},
+		{"wrong", `{"model":"gpt-4o"}`, http.StatusUnauthorized},
+		{"admin-key", `{"model":"gpt-5-preview"}`, http.StatusBadRequest},
+		{"admin-key", `not json`, http.StatusBadRequest},
+	} {
+		if rec := adminRequest(SetModelHandler(bot), http.MethodPut, "/config/model", tc.token, tc.body); rec.Code != tc.want {
+			t.Errorf("token %q, body %s: status %d, want %d", tc.token, tc.body, rec.Code, tc.want)
+		}
+	}
+	if got := bot.Configs.Load().OpenAIModel; got != "gpt-4o-mini" {
+		t.Fatalf("model %q after rejected requests, want it unchanged", got)
+	}
+
+	rec := adminRequest(SetModelHandler(bot), http.MethodPut, "/config/model", "admin-key", `{"model":" gpt-4o "}`)
+	if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), `"model":"gpt-4o"`) {
+		t.Fatalf("switch: status %d, body %s", rec.Code, rec.Body)
+	}
+	if rec := adminRequest(ModelHandler(bot), http.MethodGet, "/config/model", "admin-key", ""); !strings.Contains(rec.Body.String(), `"model":"gpt-4o"`) {
+		t.Errorf("GET after the switch: %s", rec.Body)
+	}
+
+	deliverText(t, bot, "in-1", "hi")
+	if requests := oa.Requests(); len(requests) != 1 || requests[0].Model != "gpt-4o" {
+		t.Errorf("next reply used %+v, want gpt-4o", requests)
+	}
+}
@@ -0,0 +1,145 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+	"unicode"
+
+	openai "github.com/sashabaranov/go-openai"
+
+	"hackathon/model"
+)
+
+const maxSpeechBytes = 25 << 20
+
+// sendAudioReply speaks text as one or more voice notes. Long replies are
+// split on sentence boundaries into chunks of at most cfg.AudioChunkChars
+// characters, synthesised and sent in order.
+func (b *Bot) sendAudioReply(ctx context.Context, cfg *model.Config, to, text string) error {
+	chunks := speechChunks(text, cfg.AudioChunkChars)
+	for i, chunk := range chunks {
+		audio, err := synthesizeSpeech(ctx, b.OpenAI, cfg, chunk)
+		if err != nil {
+			return fmt.Errorf("synthesize audio chunk %d of %d: %w", i+1, len(chunks), err)
+		}
+		sent, err := b.Evolution.SendAudioMessage(ctx, to, audio)
+		if err != nil {
+			return fmt.Errorf("send audio chunk %d of %d: %w", i+1, len(chunks), err)
+		}
+		if sent.Key.ID != "" {
+			b.sent.addID(sent.Key.ID, time.Now())
+		}
+	}
+	return nil
+}
+
+func synthesizeSpeech(ctx context.Context, oa *openai.Client, cfg *model.Config, text string) ([]byte, error) {
+	resp, err := oa.CreateSpeech(ctx, openai.CreateSpeechRequest{
+		Model:          openai.TTSModel1,
+		Input:          text,
+		Voice:          openai.SpeechVoice(cfg.OpenAIVoice),
+		ResponseFormat: openai.SpeechResponseFormatOpus,
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Close()
+
+	return io.ReadAll(io.LimitReader(resp, maxSpeechBytes))
+}
+
+// speechChunks groups whole sentences into chunks of at most budget runes.
+// A sentence longer than the budget is split between words. A budget of zero
+// or less keeps the text in a single chunk.
+func speechChunks(text string, budget int) []string {
+	text = strings.TrimSpace(text)
+	if text == "" {
+		return nil
+	}
+	if budget <= 0 || len([]rune(text)) <= budget {
+		return []string{text}
+	}
+
+	var (
+		chunks  []string
+		current strings.Builder
+	)
+	flush := func() {
+		if current.Len() > 0 {
+			chunks = append(chunks, strings.TrimSpace(current.String()))
+			current.Reset()
+		}
+	}
+
+	for _, sentence := range splitSentences(text) {
+		for _, piece := range splitLongSentence(sentence, budget) {
+			if current.Len() > 0 && len([]rune(current.String()))+1+len([]rune(piece)) > budget {
+				flush()
+			}
+			if current.Len() > 0 {
+				current.WriteByte(' ')
+			}
+			current.WriteString(piece)
+		}
+	}
+	flush()
+
+	return chunks
+}
+
+// splitSentences breaks text after sentence-ending punctuation followed by
+// whitespace.
+func splitSentences(text string) []string {
+	var sentences []string
+	runes := []rune(text)
+	start := 0
+
+	for i, r := range runes {
+		if !strings.ContainsRune(".!?…\n", r) {
+			continue
+		}
+		if i+1 < len(runes) && !unicode.IsSpace(runes[i+1]) {
+			continue
+		}
+		if sentence := strings.TrimSpace(string(runes[start : i+1])); sentence != "" {
+			sentences = append(sentences, sentence)
+		}
+		start = i + 1
+	}
+
+	if rest := strings.TrimSpace(string(runes[start:])); rest != "" {
+		sentences = append(sentences, rest)
+	}
+	return sentences
+}
+
+func splitLongSentence(sentence string, budget int) []string {
+	if len([]rune(sentence)) <= budget {
+		return []string{sentence}
+	}
+
+	var (
+		pieces  []string
+		current []string
+		size    int
+	)
+	for _, word := range strings.Fields(sentence) {
+		length := len([]rune(word))
+		if size > 0 && size+1+length > budget {
+			pieces = append(pieces, strings.Join(current, " "))
+			current, size = nil, 0
+		}
+		if size > 0 {
+			size++
+		}
+		current = append(current, word)
+		size += length
+	}
+	if len(current) > 0 {
+		pieces = append(pieces, strings.Join(current, " "))
+	}
+	return pieces
+}
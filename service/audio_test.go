@@ -0,0 +1,144 @@
+package service
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// speakInputs makes the fake OpenAI server synthesise each speech input as its
+// own text, so sent audio can be matched back to the chunk it came from.
+func speakInputs(oa *fakeOpenAI) func() []string {
+	var (
+		mu     sync.Mutex
+		inputs []string
+	)
+	oa.Handle("/v1/audio/speech", func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			Input string `json:"input"`
+		}
+		_ = json.NewDecoder(r.Body).Decode(&req)
+		mu.Lock()
+		inputs = append(inputs, req.Input)
+		mu.Unlock()
+		w.Header().Set("Content-Type", "audio/ogg")
+		w.Write([]byte(req.Input))
+	})
+	return func() []string {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]string(nil), inputs...)
+	}
+}
+
+// sentAudio decodes the audio of every sendWhatsAppAudio request in order.
+func sentAudio(t *testing.T, evo *fakeEvolution) []string {
+	t.Helper()
+
+	var audio []string
+	for _, req := range evo.Requests("/message/sendWhatsAppAudio") {
+		encoded, _ := req.Body["audio"].(string)
+		decoded, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil {
+			t.Fatal(err)
+		}
+		audio = append(audio, string(decoded))
+	}
+	return audio
+}
+
+func TestSendAudioReplyChunksInOrder(t *testing.T) {
+	evo, oa := newFakeEvolution(t), newFakeOpenAI(t)
+	inputs := speakInputs(oa)
+	cfg := testConfig(evo.URL)
+	cfg.AudioChunkChars = 30
+	bot := newTestBot(cfg, evo, oa)
+
+	text := "First sentence here. Second sentence is here! A third one follows? And the last."
+	if err := bot.sendAudioReply(context.Background(), cfg, "5511999999999", text); err != nil {
+		t.Fatal(err)
+	}
+
+	want := speechChunks(text, cfg.AudioChunkChars)
+	if len(want) < 3 {
+		t.Fatalf("text split into %d chunks, want several", len(want))
+	}
+	for _, chunk := range want {
+		if len([]rune(chunk)) > cfg.AudioChunkChars {
+			t.Errorf("chunk %q exceeds %d characters", chunk, cfg.AudioChunkChars)
+		}
+	}
+	if got := inputs(); !slices.Equal(got, want) {
+		t.Errorf("synthesised %q, want %q", got, want)
+	}
+	if got := sentAudio(t, evo); !slices.Equal(got, want) {
+		t.Errorf("sent audio %q, want %q", got, want)
+	}
+}
+
+func TestSpeechChunks(t *testing.T) {
+	if got := speechChunks("Short.", 600); !slices.Equal(got, []string{"Short."}) {
+		t.Errorf("short text chunked as %q", got)
+	}
+	if got := speechChunks("One. Two.", 0); !slices.Equal(got, []string{"One. Two."}) {
+		t.Errorf("zero budget chunked as %q", got)
+	}
+
+	long := strings.Repeat("word ", 20)
+	for _, chunk := range speechChunks(long, 12) {
+		if len(chunk) > 12 {
+			t.Errorf("sentence without punctuation gave chunk %q over budget", chunk)
+		}
+	}
+}
+
+func TestSendReplyModes(t *testing.T) {
+	failing := func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, `{"error":{"message":"boom","type":"server_error"}}`, http.StatusInternalServerError)
+	}
+
+	for _, tc := range []struct {
+		name      string
+		mode      string
+		failText  bool
+		failAudio bool
+		wantText  bool
+		wantAudio bool
+		wantErr   bool
+	}{
+		{"text", ReplyModeText, false, false, true, false, false},
+		{"audio", ReplyModeAudio, false, false, false, true, false},
+		{"both", ReplyModeBoth, false, false, true, true, false},
+		{"both, text fails", ReplyModeBoth, true, false, false, true, false},
+		{"both, audio fails", ReplyModeBoth, false, true, true, false, false},
+		{"both, both fail", ReplyModeBoth, true, true, false, false, true},
+	} {
+		evo, oa := newFakeEvolution(t), newFakeOpenAI(t)
+		speakInputs(oa)
+		if tc.failText {
+			evo.Handle("/message/sendText", failing)
+		}
+		if tc.failAudio {
+			oa.Handle("/v1/audio/speech", failing)
+		}
+		cfg := testConfig(evo.URL)
+		cfg.ReplyMode = tc.mode
+		bot := newTestBot(cfg, evo, oa)
+
+		err := bot.sendReply(context.Background(), cfg, "5511999999999", "hi", "Hello there.")
+		if (err != nil) != tc.wantErr {
+			t.Errorf("%s: error %v, want error %v", tc.name, err, tc.wantErr)
+		}
+		if got := slices.Equal(evo.Texts(), []string{"Hello there."}) && !tc.failText; got != tc.wantText {
+			t.Errorf("%s: text delivered = %v, want %v", tc.name, got, tc.wantText)
+		}
+		if got := slices.Equal(sentAudio(t, evo), []string{"Hello there."}); got != tc.wantAudio {
+			t.Errorf("%s: audio delivered = %v, want %v", tc.name, got, tc.wantAudio)
+		}
+	}
+}
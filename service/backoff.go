@@ -0,0 +1,92 @@
+package service
+
+import (
+	"context"
+	"math/rand/v2"
+	"time"
+
+	"hackathon/model"
+)
+
+const (
+	JitterNone         = "none"
+	JitterFull         = "full"
+	JitterEqual        = "equal"
+	JitterDecorrelated = "decorrelated"
+)
+
+// backoff yields the delays between successive retries of one operation.
+// The strategies follow the AWS "Exponential Backoff And Jitter" article:
+// none is plain capped exponential backoff, full picks uniformly in
+// [0, exp], equal in [exp/2, exp], and decorrelated in [base, 3*previous].
+type backoff struct {
+	strategy string
+	base     time.Duration
+	max      time.Duration
+	attempt  int
+	prev     time.Duration
+	random   func(n int64) int64
+}
+
+func newBackoff(cfg *model.Config) *backoff {
+	return &backoff{
+		strategy: cfg.RetryJitter,
+		base:     cfg.RetryBaseDelay,
+		max:      cfg.RetryMaxDelay,
+		random:   rand.Int64N,
+	}
+}
+
+// next returns the delay to wait before the next retry.
+func (b *backoff) next() time.Duration {
+	defer func() { b.attempt++ }()
+
+	if b.strategy == JitterDecorrelated {
+		if b.prev < b.base {
+			b.prev = b.base
+		}
+		b.prev = min(b.max, b.base+b.jitter(b.prev*3-b.base))
+		return b.prev
+	}
+
+	// Comparing against max shifted right keeps base<<attempt from
+	// overflowing for large attempt counts.
+	exp := b.max
+	if b.attempt < 63 && b.base <= b.max>>b.attempt {
+		exp = b.base << b.attempt
+	}
+
+	switch b.strategy {
+	case JitterNone:
+		return exp
+	case JitterEqual:
+		return exp/2 + b.jitter(exp/2)
+	default:
+		return b.jitter(exp)
+	}
+}
+
+// jitter returns a random duration in [0, d].
+func (b *backoff) jitter(d time.Duration) time.Duration {
+	if d <= 0 {
+		return 0
+	}
+	return time.Duration(b.random(int64(d) + 1))
+}
+
+// sleepContext waits for d or until ctx is done, whichever comes first.
+func sleepContext(ctx context.Context, d time.Duration) error {
+	if d <= 0 {
+		return ctx.Err()
+	}
+
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
@@ -0,0 +1,75 @@
+package service
+
+import (
+	"testing"
+	"time"
+
+	"hackathon/model"
+)
+
+// testBackoff returns a backoff from 100ms to 1s whose random source always
+// picks the lowest or the highest value.
+func testBackoff(strategy string, highest bool) *backoff {
+	b := newBackoff(&model.Config{RetryJitter: strategy, RetryBaseDelay: 100 * time.Millisecond, RetryMaxDelay: time.Second})
+	b.random = func(n int64) int64 {
+		if highest {
+			return n - 1
+		}
+		return 0
+	}
+	return b
+}
+
+func TestBackoffBounds(t *testing.T) {
+	const ms = time.Millisecond
+	for _, tc := range []struct {
+		strategy string
+		low      []time.Duration
+		high     []time.Duration
+	}{
+		{JitterNone, []time.Duration{100 * ms, 200 * ms, 400 * ms, 800 * ms, 1000 * ms}, []time.Duration{100 * ms, 200 * ms, 400 * ms, 800 * ms, 1000 * ms}},
+		{JitterFull, []time.Duration{0, 0, 0, 0, 0}, []time.Duration{100 * ms, 200 * ms, 400 * ms, 800 * ms, 1000 * ms}},
+		{JitterEqual, []time.Duration{50 * ms, 100 * ms, 200 * ms, 400 * ms, 500 * ms}, []time.Duration{100 * ms, 200 * ms, 400 * ms, 800 * ms, 1000 * ms}},
+		{JitterDecorrelated, []time.Duration{100 * ms, 100 * ms, 100 * ms, 100 * ms, 100 * ms}, []time.Duration{300 * ms, 900 * ms, 1000 * ms, 1000 * ms, 1000 * ms}},
+	} {
+		for _, highest := range []bool{false, true} {
+			want := tc.low
+			if highest {
+				want = tc.high
+			}
+
+			b := testBackoff(tc.strategy, highest)
+			for i, w := range want {
+				if got := b.next(); got != w {
+					t.Errorf("%s (highest=%v): delay %d = %s, want %s", tc.strategy, highest, i+1, got, w)
+				}
+			}
+		}
+	}
+}
+
+func TestBackoffRandomStaysInRange(t *testing.T) {
+	for _, strategy := range []string{JitterFull, JitterEqual, JitterDecorrelated} {
+		b := newBackoff(&model.Config{RetryJitter: strategy, RetryBaseDelay: 100 * time.Millisecond, RetryMaxDelay: time.Second})
+		for i := 0; i < 1000; i++ {
+			if d := b.next(); d < 0 || d > time.Second {
+				t.Fatalf("%s: delay %s outside [0, 1s]", strategy, d)
+			}
+		}
+	}
+}
+
+func TestBackoffLargeAttemptCounts(t *testing.T) {
+	for _, strategy := range []string{JitterNone, JitterFull, JitterEqual} {
+		b := newBackoff(&model.Config{RetryJitter: strategy, RetryBaseDelay: time.Hour, RetryMaxDelay: 2 * time.Hour})
+		b.random = func(n int64) int64 { return n - 1 }
+		for i := 1; i <= 100; i++ {
+			if got := b.next(); got < 0 || got > 2*time.Hour {
+				t.Fatalf("%s: delay %d = %s, want within [0, 2h]", strategy, i, got)
+			}
+		}
+		if got := b.next(); got != 2*time.Hour {
+			t.Errorf("%s: delay after 100 attempts = %s, want the 2h cap", strategy, got)
+		}
+	}
+}
@@ -0,0 +1,143 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"log"
+	"time"
+
+	openai "github.com/sashabaranov/go-openai"
+
+	"hackathon/model"
+)
+
+// Bot bundles the clients and shared state used to handle webhook messages.
+// Optional components may be left nil to disable the related feature.
+type Bot struct {
+	OpenAI     *openai.Client
+	Evolution  *EvolutionClient
+	Store      ConversationStore
+	Configs    *ConfigHolder
+	Handoff    *HandoffNotifier
+	Outbound   *OutboundQueue
+	Replies    *ReplyCache
+	Quota      *DailyQuota
+	KillSwitch *KillSwitch
+	Handlers   *HandlerRegistry
+	History    *WebhookHistory
+
+	Transcriber *Transcriber
+	Mirror      *MirrorSink
+	Languages   *LanguageStore
+	Overrides   *OverrideStore
+	Confidence  ConfidenceScorer
+	Retries     *GenerationRetryQueue
+
+	sent sentTracker
+
+	// now is the clock used for schedule and quota decisions; nil means
+	// time.Now.
+	now func() time.Time
+	// after is the clock humanized pacing waits on; nil means time.After.
+	after func(time.Duration) <-chan time.Time
+}
+
+func (b *Bot) clock() time.Time {
+	if b.now != nil {
+		return b.now()
+	}
+	return time.Now()
+}
+
+// sendText delivers a text message, queueing it for later delivery when the
+// instance is disconnected and an outbound queue is configured.
+func (b *Bot) sendText(ctx context.Context, to, text string) error {
+	sent, err := b.Evolution.SendTextMessage(ctx, to, text)
+	if err == nil {
+		b.sent.add(to, text, time.Now())
+		if sent.Key.ID != "" {
+			b.sent.addID(sent.Key.ID, time.Now())
+			log.Printf("sent message to %s: id=%s status=%s", to, sent.Key.ID, sent.Status)
+		}
+		return nil
+	}
+	if b.Outbound == nil || !isDisconnectedError(err) {
+		return err
+	}
+
+	if qerr := b.Outbound.Enqueue(ctx, to, text); qerr != nil {
+		log.Printf("outbound queue: could not queue message for %s: %v", to, qerr)
+		return err
+	}
+	log.Printf("outbound queue: instance disconnected, queued message for %s", to)
+	return nil
+}
+
+// SendText sends a text message on behalf of the bot, for use by custom
+// handlers.
+func (b *Bot) SendText(ctx context.Context, to, text string) error {
+	return b.sendText(ctx, to, text)
+}
+
+// sendReply delivers a generated reply to inbound in the configured reply
+// mode and mirrors the exchange once it went out.
+func (b *Bot) sendReply(ctx context.Context, cfg *model.Config, to, inbound, reply string) error {
+	var err error
+	switch cfg.ReplyMode {
+	case ReplyModeAudio:
+		err = b.sendAudioReply(ctx, cfg, to, reply)
+	case ReplyModeBoth:
+		err = b.sendBoth(to, b.sendText(ctx, to, reply), b.sendAudioReply(ctx, cfg, to, reply))
+	default:
+		err = b.sendText(ctx, to, reply)
+	}
+	if err == nil {
+		b.Mirror.Mirror(to, inbound, reply)
+	}
+	return err
+}
+
+// sendNotice sends a fixed reply to inbound, such as the after-hours message,
+// as text whatever the reply mode, and mirrors the exchange once it went out.
+func (b *Bot) sendNotice(ctx context.Context, to, inbound, notice string) error {
+	if err := b.sendText(ctx, to, notice); err != nil {
+		return err
+	}
+	b.Mirror.Mirror(to, inbound, notice)
+	return nil
+}
+
+// sendBoth combines the outcomes of delivering a reply as text and as audio.
+// Each channel is attempted regardless of the other, and the reply counts as
+// delivered when either one got through; the failed channel is only logged.
+func (b *Bot) sendBoth(to string, textErr, audioErr error) error {
+	switch {
+	case textErr != nil && audioErr != nil:
+		return errors.Join(textErr, audioErr)
+	case textErr != nil:
+		log.Printf("text reply to %s failed, audio was delivered: %v", to, textErr)
+	case audioErr != nil:
+		log.Printf("audio reply to %s failed, text was delivered: %v", to, audioErr)
+	}
+	return nil
+}
+
+// drainOutbound flushes queued messages in the background once the instance
+// is connected again.
+func (b *Bot) drainOutbound() {
+	if b.Outbound == nil {
+		return
+	}
+	go func() {
+		if err := b.Outbound.Drain(context.Background(), b.Evolution); err != nil {
+			log.Printf("outbound queue: drain stopped: %v", err)
+		}
+	}()
+}
+
+// debugf logs only when LOG_LEVEL=debug.
+func debugf(cfg *model.Config, format string, args ...any) {
+	if cfg.DebugLogging {
+		log.Printf("debug: "+format, args...)
+	}
+}
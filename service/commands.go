@@ -0,0 +1,85 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"slices"
+	"strings"
+
+	"hackathon/model"
+)
+
+// chatCommand is a slash command sent in place of a message for the model.
+// Its result is sent back to the user as the reply.
+type chatCommand struct {
+	// privileged commands are only honoured from ADMIN_NUMBERS.
+	privileged bool
+	run        func(ctx context.Context, b *Bot, cfg *model.Config, user, args string) (string, error)
+}
+
+var chatCommands = map[string]chatCommand{
+	"/model": {privileged: true, run: modelCommand},
+	"/temp":  {run: temperatureCommand},
+}
+
+// runCommand executes text as a chat command for user and reports whether it
+// was one. Messages that do not start with a known command are left for the
+// model.
+func (b *Bot) runCommand(ctx context.Context, cfg *model.Config, user string, key model.WebhookKey, text string) (bool, error) {
+	name, args, _ := strings.Cut(strings.TrimSpace(text), " ")
+	cmd, ok := chatCommands[strings.ToLower(name)]
+	if !ok {
+		return false, nil
+	}
+
+	if cmd.privileged && !isAdmin(cfg, messageAuthor(key)) {
+		log.Printf("privileged command %s rejected from %s", name, messageAuthor(key))
+		return true, b.sendNotice(ctx, user, text, cfg.UnauthorizedMessage)
+	}
+
+	reply, err := cmd.run(ctx, b, cfg, user, strings.TrimSpace(args))
+	if err != nil {
+		return true, fmt.Errorf("command %s: %w", name, err)
+	}
+	return true, b.sendNotice(ctx, user, text, reply)
+}
+
+// isAdmin reports whether number is listed in ADMIN_NUMBERS.
+func isAdmin(cfg *model.Config, number string) bool {
+	number = normalizePhoneNumber(number)
+	return number != "" && slices.Contains(cfg.AdminNumbers, number)
+}
+
+// messageAuthor returns who wrote the message identified by key: the
+// participant in a group, the chat itself otherwise.
+func messageAuthor(key model.WebhookKey) string {
+	if isGroupJID(key.RemoteJID) {
+		return key.Participant
+	}
+	return key.RemoteJID
+}
+
+// normalizePhoneNumber reduces a JID or a formatted phone number such as
+// "+55 (11) 91234-5678" to its digits.
+func normalizePhoneNumber(number string) string {
+	return strings.Map(func(r rune) rune {
+		if r >= '0' && r <= '9' {
+			return r
+		}
+		return -1
+	}, normalizeWhatsAppID(number))
+}
+
+// modelCommand shows the active OpenAI model, or switches to the one named in
+// args.
+func modelCommand(ctx context.Context, b *Bot, cfg *model.Config, user, args string) (string, error) {
+	if args == "" {
+		return fmt.Sprintf("Current model: %s\nAllowed: %s", cfg.OpenAIModel, strings.Join(cfg.OpenAIAllowedModels, ", ")), nil
+	}
+
+	if err := b.Configs.SetModel(args); err != nil {
+		return fmt.Sprintf("Cannot switch model: %v. Allowed: %s", err, strings.Join(cfg.OpenAIAllowedModels, ", ")), nil
+	}
+	return fmt.Sprintf("Model switched to %s.", args), nil
+}
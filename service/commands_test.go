@@ -0,0 +1,67 @@
+package service
+
+import (
+	"context"
+	"slices"
+	"testing"
+
+	"hackathon/model"
+)
+
+func TestModelCommandRequiresAdmin(t *testing.T) {
+	for _, tc := range []struct {
+		name  string
+		key   model.WebhookKey
+		admin bool
+	}{
+		{"admin", model.WebhookKey{RemoteJID: "5511888888888@s.whatsapp.net", ID: "in-1"}, true},
+		{"admin in a group", model.WebhookKey{RemoteJID: "120363025@g.us", Participant: "5511888888888@s.whatsapp.net", ID: "in-1"}, true},
+		{"other user", model.WebhookKey{RemoteJID: "5511999999999@s.whatsapp.net", ID: "in-1"}, false},
+		{"other user in a group", model.WebhookKey{RemoteJID: "120363025@g.us", Participant: "5511999999999@s.whatsapp.net", ID: "in-1"}, false},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			evo, oa := newFakeEvolution(t), newFakeOpenAI(t)
+			cfg := testConfig(evo.URL)
+			cfg.AdminNumbers = []string{"5511888888888"}
+			cfg.OpenAIAllowedModels = []string{"gpt-4o-mini", "gpt-4o"}
+			bot := newTestBot(cfg, evo, oa)
+
+			msg := model.WebhookMessage{Conversation: "/model gpt-4o"}
+			if err := bot.handleMessage(context.Background(), cfg, "", "", msg, tc.key); err != nil {
+				t.Fatal(err)
+			}
+
+			wantModel, wantReply := "gpt-4o-mini", cfg.UnauthorizedMessage
+			if tc.admin {
+				wantModel, wantReply = "gpt-4o", "Model switched to gpt-4o."
+			}
+			if got := bot.Configs.Load().OpenAIModel; got != wantModel {
+				t.Errorf("model %q, want %q", got, wantModel)
+			}
+			if texts := evo.Texts(); !slices.Equal(texts, []string{wantReply}) {
+				t.Errorf("sent %q, want [%q]", texts, wantReply)
+			}
+			if n := len(oa.Requests()); n != 0 {
+				t.Errorf("a command reached the model %d times", n)
+			}
+		})
+	}
+}
+
+func TestModelCommandRejectsDisallowedModel(t *testing.T) {
+	evo := newFakeEvolution(t)
+	cfg := testConfig(evo.URL)
+	cfg.AdminNumbers = []string{"5511999999999"}
+	bot := newTestBot(cfg, evo, nil)
+
+	msg, key := textMessage("in-1", "/model gpt-5-preview")
+	if err := bot.handleMessage(context.Background(), cfg, "", "", msg, key); err != nil {
+		t.Fatal(err)
+	}
+	if got := bot.Configs.Load().OpenAIModel; got != "gpt-4o-mini" {
+		t.Errorf("model %q, want it unchanged", got)
+	}
+	if texts := evo.Texts(); len(texts) != 1 || texts[0] == "Model switched to gpt-5-preview." {
+		t.Errorf("sent %q, want the rejection", texts)
+	}
+}
@@ -0,0 +1,205 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"strings"
+
+	openai "github.com/sashabaranov/go-openai"
+
+	"hackathon/model"
+)
+
+const (
+	StreamRecoveryRetry   = "retry"
+	StreamRecoveryPartial = "partial"
+)
+
+const (
+	emptyReplyNudge    = "Your previous answer was empty. Please reply to the user's last message."
+	continuationPrompt = "Your previous answer was cut off. Continue exactly where you left off, without repeating anything."
+)
+
+var errStreamTruncated = errors.New("completion stream ended before a finish reason was received")
+
+// completeChat runs a chat completion, streamed or not depending on config,
+// and returns the content of the first choice.
+func completeChat(ctx context.Context, oa *openai.Client, cfg *model.Config, req openai.ChatCompletionRequest) (string, openai.FinishReason, error) {
+	if !cfg.OpenAIStream {
+		var resp openai.ChatCompletionResponse
+		err := withOpenAIRetries(ctx, cfg, func() error {
+			var err error
+			resp, err = oa.CreateChatCompletion(ctx, req)
+			return err
+		})
+		if err != nil {
+			return "", "", err
+		}
+		if len(resp.Choices) == 0 {
+			return "", "", nil
+		}
+		return resp.Choices[0].Message.Content, resp.Choices[0].FinishReason, nil
+	}
+
+	content, finishReason, err := streamChat(ctx, oa, cfg, req)
+	if err == nil {
+		return content, finishReason, nil
+	}
+	if ctx.Err() != nil {
+		return "", "", err
+	}
+
+	log.Printf("completion stream interrupted after %d bytes: %v", len(content), err)
+
+	switch cfg.StreamRecovery {
+	case StreamRecoveryPartial:
+		if strings.TrimSpace(content) == "" {
+			return "", "", err
+		}
+		return content + "\n\n" + cfg.StreamContinuationNote, openai.FinishReasonStop, nil
+	default:
+		if err := sleepContext(ctx, newBackoff(cfg).next()); err != nil {
+			return "", "", err
+		}
+		return streamChat(ctx, oa, cfg, req)
+	}
+}
+
+// withOpenAIRetries calls fn until it succeeds, fails with an error that is
+// not worth retrying, or cfg.OpenAIMaxRetries retries have been made.
+func withOpenAIRetries(ctx context.Context, cfg *model.Config, fn func() error) error {
+	delays := newBackoff(cfg)
+
+	for attempt := 1; ; attempt++ {
+		err := fn()
+		if err == nil || attempt > cfg.OpenAIMaxRetries || !isRetryableOpenAIError(err) {
+			return err
+		}
+
+		delay := delays.next()
+		log.Printf("openai request failed, retrying in %s (retry %d of %d): %v", delay, attempt, cfg.OpenAIMaxRetries, err)
+		if err := sleepContext(ctx, delay); err != nil {
+			return err
+		}
+	}
+}
+
+// isRetryableOpenAIError reports whether err is an OpenAI rate limit, server
+// error or network failure.
+func isRetryableOpenAIError(err error) bool {
+	var apiErr *openai.APIError
+	if errors.As(err, &apiErr) {
+		return apiErr.HTTPStatusCode == http.StatusTooManyRequests || apiErr.HTTPStatusCode >= 500
+	}
+
+	var reqErr *openai.RequestError
+	if errors.As(err, &reqErr) {
+		return reqErr.HTTPStatusCode == http.StatusTooManyRequests || reqErr.HTTPStatusCode >= 500
+	}
+
+	var netErr net.Error
+	return errors.As(err, &netErr)
+}
+
+// completeReply produces the reply text for req, acting on the finish reason:
+// a content_filter stop is replaced by cfg.ContentFilterMessage, and a length
+// stop is continued up to cfg.LengthContinuations times.
+func completeReply(ctx context.Context, oa *openai.Client, cfg *model.Config, req openai.ChatCompletionRequest) (string, error) {
+	content, finishReason, err := completeNonEmpty(ctx, oa, cfg, req)
+	if err != nil {
+		return "", err
+	}
+
+	for attempt := 1; finishReason == openai.FinishReasonLength && attempt <= cfg.LengthContinuations; attempt++ {
+		log.Printf("completion stopped at the length limit, continuing (attempt %d of %d)", attempt, cfg.LengthContinuations)
+
+		continued := req
+		continued.Messages = append(append([]openai.ChatCompletionMessage(nil), req.Messages...),
+			openai.ChatCompletionMessage{Role: openai.ChatMessageRoleAssistant, Content: content},
+			openai.ChatCompletionMessage{Role: openai.ChatMessageRoleSystem, Content: continuationPrompt},
+		)
+
+		var more string
+		more, finishReason, err = completeChat(ctx, oa, cfg, continued)
+		if err != nil {
+			log.Printf("completion continuation failed, keeping partial reply: %v", err)
+			break
+		}
+		content += more
+	}
+
+	if finishReason == openai.FinishReasonContentFilter {
+		log.Print("completion stopped by the content filter, sending the safe fallback message")
+		return cfg.ContentFilterMessage, nil
+	}
+
+	return content, nil
+}
+
+// completeNonEmpty is completeChat with up to cfg.OpenAIEmptyRetries extra
+// attempts when the model returns no content. Each retry appends a nudge to
+// the request only; it is never stored in the conversation.
+func completeNonEmpty(ctx context.Context, oa *openai.Client, cfg *model.Config, req openai.ChatCompletionRequest) (string, openai.FinishReason, error) {
+	content, finishReason, err := completeChat(ctx, oa, cfg, req)
+
+	for attempt := 1; err == nil && strings.TrimSpace(content) == "" && attempt <= cfg.OpenAIEmptyRetries; attempt++ {
+		log.Printf("completion returned empty content, retrying (attempt %d of %d)", attempt, cfg.OpenAIEmptyRetries)
+
+		nudged := req
+		nudged.Messages = append(append([]openai.ChatCompletionMessage(nil), req.Messages...), openai.ChatCompletionMessage{
+			Role:    openai.ChatMessageRoleSystem,
+			Content: emptyReplyNudge,
+		})
+		content, finishReason, err = completeChat(ctx, oa, cfg, nudged)
+	}
+
+	return content, finishReason, err
+}
+
+// streamChat accumulates a streamed completion. Whatever was received is
+// returned alongside errStreamTruncated (or the transport error) when the
+// stream stops before the model reports a finish reason.
+func streamChat(ctx context.Context, oa *openai.Client, cfg *model.Config, req openai.ChatCompletionRequest) (string, openai.FinishReason, error) {
+	req.Stream = true
+
+	var stream *openai.ChatCompletionStream
+	err := withOpenAIRetries(ctx, cfg, func() error {
+		var err error
+		stream, err = oa.CreateChatCompletionStream(ctx, req)
+		return err
+	})
+	if err != nil {
+		return "", "", err
+	}
+	defer stream.Close()
+
+	var (
+		content      strings.Builder
+		finishReason openai.FinishReason
+	)
+
+	for {
+		chunk, err := stream.Recv()
+		if errors.Is(err, io.EOF) {
+			if finishReason == "" {
+				return content.String(), "", errStreamTruncated
+			}
+			return content.String(), finishReason, nil
+		}
+		if err != nil {
+			return content.String(), "", err
+		}
+
+		if len(chunk.Choices) == 0 {
+			continue
+		}
+		content.WriteString(chunk.Choices[0].Delta.Content)
+		if chunk.Choices[0].FinishReason != "" {
+			finishReason = chunk.Choices[0].FinishReason
+		}
+	}
+}
@@ -0,0 +1,181 @@
+package service
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	openai "github.com/sashabaranov/go-openai"
+)
+
+// truncatedStream answers the first streamed completion with partial content
+// and closes the connection before a finish reason, then streams full answers.
+func truncatedStream(calls *atomic.Int32) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		if calls.Add(1) == 1 {
+			writeStreamChunk(w, openai.ChatCompletionStreamResponse{
+				Choices: []openai.ChatCompletionStreamChoice{{Delta: openai.ChatCompletionStreamChoiceDelta{Content: "Half an ans"}}},
+			})
+			return
+		}
+		writeStreamChunk(w, openai.ChatCompletionStreamResponse{
+			Choices: []openai.ChatCompletionStreamChoice{{Delta: openai.ChatCompletionStreamChoiceDelta{Content: "A full answer."}}},
+		})
+		writeStreamChunk(w, openai.ChatCompletionStreamResponse{
+			Choices: []openai.ChatCompletionStreamChoice{{FinishReason: openai.FinishReasonStop}},
+		})
+		w.Write([]byte("data: [DONE]\n\n"))
+	}
+}
+
+func TestCompleteChatStreamRecovery(t *testing.T) {
+	for _, tc := range []struct {
+		recovery string
+		want     string
+		calls    int32
+	}{
+		{StreamRecoveryRetry, "A full answer.", 2},
+		{StreamRecoveryPartial, "Half an ans\n\n(cut off)", 1},
+	} {
+		oa := newFakeOpenAI(t)
+		var calls atomic.Int32
+		oa.Handle("/v1/chat/completions", truncatedStream(&calls))
+
+		cfg := testConfig("")
+		cfg.OpenAIStream = true
+		cfg.StreamRecovery = tc.recovery
+		cfg.StreamContinuationNote = "(cut off)"
+
+		content, finishReason, err := completeChat(context.Background(), oa.Client(), cfg, openai.ChatCompletionRequest{
+			Model:    cfg.OpenAIModel,
+			Messages: []openai.ChatCompletionMessage{{Role: openai.ChatMessageRoleUser, Content: "hi"}},
+		})
+		if err != nil {
+			t.Fatalf("%s: %v", tc.recovery, err)
+		}
+		if content != tc.want || finishReason != openai.FinishReasonStop {
+			t.Errorf("%s: got %q (%s), want %q", tc.recovery, content, finishReason, tc.want)
+		}
+		if got := calls.Load(); got != tc.calls {
+			t.Errorf("%s: %d stream requests, want %d", tc.recovery, got, tc.calls)
+		}
+	}
+}
+
+func TestCompleteChatPartialRecoveryWithoutContent(t *testing.T) {
+	oa := newFakeOpenAI(t)
+	oa.Handle("/v1/chat/completions", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+	})
+
+	cfg := testConfig("")
+	cfg.OpenAIStream = true
+	cfg.StreamRecovery = StreamRecoveryPartial
+
+	_, _, err := completeChat(context.Background(), oa.Client(), cfg, openai.ChatCompletionRequest{Model: cfg.OpenAIModel})
+	if err == nil {
+		t.Fatal("an empty truncated stream was accepted as a partial reply")
+	}
+}
+
+func TestCompleteNonEmptyRetriesEmptyCompletion(t *testing.T) {
+	for _, tc := range []struct {
+		retries  int
+		want     string
+		requests int
+	}{
+		{0, "", 1},
+		{1, "Second try.", 2},
+		{3, "Second try.", 2},
+	} {
+		oa := newFakeOpenAI(t)
+		oa.Reply(fakeCompletion{Content: "  "}, fakeCompletion{Content: "Second try."})
+		cfg := testConfig("")
+		cfg.OpenAIEmptyRetries = tc.retries
+
+		content, _, err := completeNonEmpty(context.Background(), oa.Client(), cfg, openai.ChatCompletionRequest{
+			Model:    cfg.OpenAIModel,
+			Messages: []openai.ChatCompletionMessage{{Role: openai.ChatMessageRoleUser, Content: "hi"}},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if strings.TrimSpace(content) != tc.want {
+			t.Errorf("retries=%d: content %q, want %q", tc.retries, content, tc.want)
+		}
+
+		requests := oa.Requests()
+		if len(requests) != tc.requests {
+			t.Fatalf("retries=%d: %d requests, want %d", tc.retries, len(requests), tc.requests)
+		}
+		if tc.requests > 1 {
+			nudge := requests[1].Messages[len(requests[1].Messages)-1]
+			if nudge.Role != openai.ChatMessageRoleSystem || nudge.Content != emptyReplyNudge {
+				t.Errorf("retry did not end with the nudge: %+v", nudge)
+			}
+			if len(requests[0].Messages) != 1 {
+				t.Error("the nudge leaked into the original request")
+			}
+		}
+	}
+}
+
+func TestCompleteReplyContentFilter(t *testing.T) {
+	oa := newFakeOpenAI(t)
+	oa.Reply(fakeCompletion{Content: "Here is how to", FinishReason: openai.FinishReasonContentFilter})
+	cfg := testConfig("")
+
+	reply, err := completeReply(context.Background(), oa.Client(), cfg, openai.ChatCompletionRequest{Model: cfg.OpenAIModel})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reply != cfg.ContentFilterMessage {
+		t.Errorf("reply %q, want the content filter message", reply)
+	}
+}
+
+func TestCompleteReplyLengthContinuations(t *testing.T) {
+	for _, tc := range []struct {
+		continuations int
+		want          string
+		requests      int
+	}{
+		{0, "Part one, ", 1},
+		{1, "Part one, part two, ", 2},
+		{3, "Part one, part two, part three.", 3},
+	} {
+		oa := newFakeOpenAI(t)
+		oa.Reply(
+			fakeCompletion{Content: "Part one, ", FinishReason: openai.FinishReasonLength},
+			fakeCompletion{Content: "part two, ", FinishReason: openai.FinishReasonLength},
+			fakeCompletion{Content: "part three.", FinishReason: openai.FinishReasonStop},
+		)
+		cfg := testConfig("")
+		cfg.LengthContinuations = tc.continuations
+
+		reply, err := completeReply(context.Background(), oa.Client(), cfg, openai.ChatCompletionRequest{
+			Model:    cfg.OpenAIModel,
+			Messages: []openai.ChatCompletionMessage{{Role: openai.ChatMessageRoleUser, Content: "tell me everything"}},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if reply != tc.want {
+			t.Errorf("continuations=%d: reply %q, want %q", tc.continuations, reply, tc.want)
+		}
+
+		requests := oa.Requests()
+		if len(requests) != tc.requests {
+			t.Fatalf("continuations=%d: %d requests, want %d", tc.continuations, len(requests), tc.requests)
+		}
+		if tc.requests > 1 {
+			messages := requests[1].Messages
+			if len(messages) != 3 || messages[1].Content != "Part one, " || messages[2].Content != continuationPrompt {
+				t.Errorf("continuation request %+v, want the partial reply and the continuation prompt", messages)
+			}
+		}
+	}
+}
@@ -0,0 +1,130 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"strings"
+
+	openai "github.com/sashabaranov/go-openai"
+
+	"hackathon/model"
+)
+
+const (
+	ConfidenceGateOff        = "off"
+	ConfidenceGateSelfRating = "self_rating"
+	ConfidenceGateLogprobs   = "logprobs"
+)
+
+const (
+	LowConfidenceFallback = "fallback"
+	LowConfidenceHandoff  = "handoff"
+)
+
+const confidenceJudgePrompt = "You review replies written by a customer support assistant. Judge whether the reply correctly and relevantly answers the user's message without guessing."
+
+// ConfidenceScorer estimates, between 0 and 1, how confident the bot can be
+// that reply is a correct and relevant answer to userInput.
+type ConfidenceScorer interface {
+	Score(ctx context.Context, cfg *model.Config, userInput, reply string) (float64, error)
+}
+
+// NewConfidenceScorer returns the scorer for the CONFIDENCE_GATE strategy, or
+// nil when the gate is off.
+func NewConfidenceScorer(oa *openai.Client, strategy string) ConfidenceScorer {
+	switch strategy {
+	case ConfidenceGateSelfRating:
+		return &selfRatingScorer{openai: oa}
+	case ConfidenceGateLogprobs:
+		return &logprobScorer{openai: oa}
+	default:
+		return nil
+	}
+}
+
+// lowConfidenceError is returned by generateAssistantReply when the reply
+// scored below CONFIDENCE_THRESHOLD and was discarded.
+type lowConfidenceError struct {
+	score float64
+}
+
+func (e *lowConfidenceError) Error() string {
+	return fmt.Sprintf("reply confidence %.2f is below the threshold", e.score)
+}
+
+// selfRatingScorer asks the model to rate the reply on a 0 to 1 scale.
+type selfRatingScorer struct {
+	openai *openai.Client
+}
+
+func (s *selfRatingScorer) Score(ctx context.Context, cfg *model.Config, userInput, reply string) (float64, error) {
+	var resp openai.ChatCompletionResponse
+	err := withOpenAIRetries(ctx, cfg, func() error {
+		var err error
+		resp, err = s.openai.CreateChatCompletion(ctx, openai.ChatCompletionRequest{
+			Model:          cfg.OpenAIModel,
+			Messages:       judgeMessages(userInput, reply, `Answer with JSON: {"confidence": <number between 0 and 1>}.`),
+			ResponseFormat: &openai.ChatCompletionResponseFormat{Type: openai.ChatCompletionResponseFormatTypeJSONObject},
+		})
+		return err
+	})
+	if err != nil {
+		return 0, err
+	}
+	if len(resp.Choices) == 0 {
+		return 0, fmt.Errorf("confidence rating returned no choices")
+	}
+
+	var rating struct {
+		Confidence float64 `json:"confidence"`
+	}
+	if err := json.Unmarshal([]byte(resp.Choices[0].Message.Content), &rating); err != nil {
+		return 0, fmt.Errorf("decode confidence rating: %w", err)
+	}
+	return math.Max(0, math.Min(1, rating.Confidence)), nil
+}
+
+// logprobScorer asks the model for a one-token yes/no verdict and uses the
+// probability it assigns to "yes", which is better calibrated than a number
+// the model writes out.
+type logprobScorer struct {
+	openai *openai.Client
+}
+
+func (s *logprobScorer) Score(ctx context.Context, cfg *model.Config, userInput, reply string) (float64, error) {
+	var resp openai.ChatCompletionResponse
+	err := withOpenAIRetries(ctx, cfg, func() error {
+		var err error
+		resp, err = s.openai.CreateChatCompletion(ctx, openai.ChatCompletionRequest{
+			Model:       cfg.OpenAIModel,
+			Messages:    judgeMessages(userInput, reply, "Answer with a single word: yes or no."),
+			MaxTokens:   1,
+			LogProbs:    true,
+			TopLogProbs: 5,
+		})
+		return err
+	})
+	if err != nil {
+		return 0, err
+	}
+	if len(resp.Choices) == 0 || resp.Choices[0].LogProbs == nil || len(resp.Choices[0].LogProbs.Content) == 0 {
+		return 0, fmt.Errorf("confidence verdict returned no log probabilities")
+	}
+
+	var yes float64
+	for _, candidate := range resp.Choices[0].LogProbs.Content[0].TopLogProbs {
+		if strings.EqualFold(strings.TrimSpace(candidate.Token), "yes") {
+			yes += math.Exp(candidate.LogProb)
+		}
+	}
+	return math.Min(1, yes), nil
+}
+
+func judgeMessages(userInput, reply, instruction string) []openai.ChatCompletionMessage {
+	return []openai.ChatCompletionMessage{
+		{Role: openai.ChatMessageRoleSystem, Content: confidenceJudgePrompt + " " + instruction},
+		{Role: openai.ChatMessageRoleUser, Content: fmt.Sprintf("User message:\n%s\n\nAssistant reply:\n%s", userInput, reply)},
+	}
+}
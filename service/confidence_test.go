@@ -0,0 +1,44 @@
+package service
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestConfidenceGateThreshold(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		score  float64
+		action string
+		want   []string
+		handed int
+	}{
+		{"above", 0.9, LowConfidenceFallback, []string{"A confident answer."}, 0},
+		{"at the threshold", 0.6, LowConfidenceFallback, []string{"A confident answer."}, 0},
+		{"below, fallback", 0.2, LowConfidenceFallback, []string{"not sure"}, 0},
+		{"below, handoff", 0.2, LowConfidenceHandoff, []string{"a person will reply"}, 1},
+		{"above, handoff", 0.9, LowConfidenceHandoff, []string{"A confident answer."}, 0},
+	} {
+		evo, oa := newFakeEvolution(t), newFakeOpenAI(t)
+		oa.Reply(fakeCompletion{Content: "A confident answer."})
+		server, received := recordHandoffs(t)
+
+		cfg := testConfig(evo.URL)
+		cfg.ConfidenceThreshold = 0.6
+		cfg.LowConfidenceAction = tc.action
+		cfg.HandoffWebhookURL = server.URL
+		cfg.HandoffMessage = "a person will reply"
+		bot := newTestBot(cfg, evo, oa)
+		bot.Confidence = fixedScore(tc.score)
+		bot.Handoff = NewHandoffNotifier(cfg, nil)
+
+		deliverText(t, bot, "in-1", "hi")
+
+		if texts := evo.Texts(); !slices.Equal(texts, tc.want) {
+			t.Errorf("%s: sent %q, want %q", tc.name, texts, tc.want)
+		}
+		if len(received) != tc.handed {
+			t.Errorf("%s: %d handoff notifications, want %d", tc.name, len(received), tc.handed)
+		}
+	}
+}
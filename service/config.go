@@ -1,15 +1,32 @@
 package service
 
 import (
+	"crypto/tls"
+	"crypto/x509"
 	"errors"
 	"fmt"
 	"os"
+	"regexp"
+	"slices"
 	"strconv"
 	"strings"
+	"time"
 
 	"hackathon/model"
 )
 
+const (
+	ReplyModeText  = "text"
+	ReplyModeAudio = "audio"
+	ReplyModeBoth  = "both"
+)
+
+const (
+	MarkReadOnReceive  = "on_receive"
+	MarkReadAfterReply = "after_reply"
+	MarkReadOff        = "off"
+)
+
 func LoadConfig() (*model.Config, error) {
 	cfg := &model.Config{
 		EvolutionAPIURL:   strings.TrimSuffix(os.Getenv("EVOLUTION_API_URL"), "/"),
@@ -17,16 +34,289 @@ func LoadConfig() (*model.Config, error) {
 		EvolutionInstance: os.Getenv("EVOLUTION_INSTANCE"),
 		OpenAIAPIKey:      os.Getenv("OPENAI_API_KEY"),
 		OpenAIVoice:       os.Getenv("OPENAI_VOICE"),
+		AdminAPIKey:       os.Getenv("ADMIN_API_KEY"),
 	}
 
 	if cfg.EvolutionAPIURL == "" || cfg.EvolutionAPIKey == "" || cfg.EvolutionInstance == "" || cfg.OpenAIAPIKey == "" {
 		return nil, errors.New("missing required environment variables")
 	}
 
+	switch level := strings.ToLower(strings.TrimSpace(os.Getenv("LOG_LEVEL"))); level {
+	case "", "info":
+	case "debug":
+		cfg.DebugLogging = true
+	default:
+		return nil, fmt.Errorf("invalid LOG_LEVEL: %s", level)
+	}
+
+	for _, number := range strings.Split(os.Getenv("ADMIN_NUMBERS"), ",") {
+		if number = normalizePhoneNumber(number); number != "" {
+			cfg.AdminNumbers = append(cfg.AdminNumbers, number)
+		}
+	}
+	cfg.UnauthorizedMessage = strings.TrimSpace(os.Getenv("UNAUTHORIZED_MESSAGE"))
+	if cfg.UnauthorizedMessage == "" {
+		cfg.UnauthorizedMessage = "Sorry, you are not authorized to use this command."
+	}
+
+	cfg.AllowedInstances = []string{cfg.EvolutionInstance}
+	for _, instance := range strings.Split(os.Getenv("EVOLUTION_ALLOWED_INSTANCES"), ",") {
+		if instance = strings.TrimSpace(instance); instance != "" && instance != cfg.EvolutionInstance {
+			cfg.AllowedInstances = append(cfg.AllowedInstances, instance)
+		}
+	}
+	if allowMissing := os.Getenv("EVOLUTION_ALLOW_MISSING_INSTANCE"); allowMissing != "" {
+		parsed, err := strconv.ParseBool(allowMissing)
+		if err != nil {
+			return nil, fmt.Errorf("invalid EVOLUTION_ALLOW_MISSING_INSTANCE: %w", err)
+		}
+		cfg.AllowMissingInstance = parsed
+	}
+
+	cfg.EvolutionRateLimitCooldown = 5 * time.Second
+	if cooldown := os.Getenv("EVOLUTION_RATE_LIMIT_COOLDOWN"); cooldown != "" {
+		parsed, err := time.ParseDuration(cooldown)
+		if err != nil || parsed < 0 {
+			return nil, fmt.Errorf("invalid EVOLUTION_RATE_LIMIT_COOLDOWN: %q", cooldown)
+		}
+		cfg.EvolutionRateLimitCooldown = parsed
+	}
+
+	cfg.RetryJitter = strings.ToLower(strings.TrimSpace(os.Getenv("RETRY_JITTER")))
+	switch cfg.RetryJitter {
+	case "":
+		cfg.RetryJitter = JitterFull
+	case JitterNone, JitterFull, JitterEqual, JitterDecorrelated:
+	default:
+		return nil, fmt.Errorf("invalid RETRY_JITTER: %s", cfg.RetryJitter)
+	}
+
+	cfg.RetryBaseDelay = 500 * time.Millisecond
+	if base := os.Getenv("RETRY_BASE_DELAY"); base != "" {
+		parsed, err := time.ParseDuration(base)
+		if err != nil || parsed <= 0 {
+			return nil, fmt.Errorf("invalid RETRY_BASE_DELAY: %q", base)
+		}
+		cfg.RetryBaseDelay = parsed
+	}
+
+	cfg.RetryMaxDelay = 10 * time.Second
+	if maxDelay := os.Getenv("RETRY_MAX_DELAY"); maxDelay != "" {
+		parsed, err := time.ParseDuration(maxDelay)
+		if err != nil || parsed < cfg.RetryBaseDelay {
+			return nil, fmt.Errorf("invalid RETRY_MAX_DELAY: %q must be at least RETRY_BASE_DELAY", maxDelay)
+		}
+		cfg.RetryMaxDelay = parsed
+	}
+
+	if retries := os.Getenv("OPENAI_MAX_RETRIES"); retries != "" {
+		parsed, err := strconv.Atoi(retries)
+		if err != nil || parsed < 0 {
+			return nil, fmt.Errorf("invalid OPENAI_MAX_RETRIES: %q", retries)
+		}
+		cfg.OpenAIMaxRetries = parsed
+	}
+
+	if retries := os.Getenv("EVOLUTION_MAX_RETRIES"); retries != "" {
+		parsed, err := strconv.Atoi(retries)
+		if err != nil || parsed < 0 {
+			return nil, fmt.Errorf("invalid EVOLUTION_MAX_RETRIES: %q", retries)
+		}
+		cfg.EvolutionMaxRetries = parsed
+	}
+
 	if cfg.OpenAIVoice == "" {
 		cfg.OpenAIVoice = "alloy"
 	}
 
+	cfg.OpenAIModel = strings.TrimSpace(os.Getenv("OPENAI_MODEL"))
+	if cfg.OpenAIModel == "" {
+		cfg.OpenAIModel = "gpt-4o-mini"
+	}
+	cfg.GenerationRetryMax = 100
+	if retryMax := os.Getenv("GENERATION_RETRY_QUEUE_MAX"); retryMax != "" {
+		parsed, err := strconv.Atoi(retryMax)
+		if err != nil || parsed < 0 {
+			return nil, fmt.Errorf("invalid GENERATION_RETRY_QUEUE_MAX: %q", retryMax)
+		}
+		cfg.GenerationRetryMax = parsed
+	}
+
+	cfg.GenerationRetryMaxAge = 15 * time.Minute
+	if maxAge := os.Getenv("GENERATION_RETRY_MAX_AGE"); maxAge != "" {
+		parsed, err := time.ParseDuration(maxAge)
+		if err != nil || parsed <= 0 {
+			return nil, fmt.Errorf("invalid GENERATION_RETRY_MAX_AGE: %q", maxAge)
+		}
+		cfg.GenerationRetryMaxAge = parsed
+	}
+
+	cfg.GenerationRetryInterval = 30 * time.Second
+	if interval := os.Getenv("GENERATION_RETRY_INTERVAL"); interval != "" {
+		parsed, err := time.ParseDuration(interval)
+		if err != nil || parsed <= 0 {
+			return nil, fmt.Errorf("invalid GENERATION_RETRY_INTERVAL: %q", interval)
+		}
+		cfg.GenerationRetryInterval = parsed
+	}
+
+	cfg.ConfidenceGate = strings.ToLower(strings.TrimSpace(os.Getenv("CONFIDENCE_GATE")))
+	switch cfg.ConfidenceGate {
+	case "":
+		cfg.ConfidenceGate = ConfidenceGateOff
+	case ConfidenceGateOff, ConfidenceGateSelfRating, ConfidenceGateLogprobs:
+	default:
+		return nil, fmt.Errorf("invalid CONFIDENCE_GATE: %s", cfg.ConfidenceGate)
+	}
+
+	cfg.ConfidenceThreshold = 0.5
+	if threshold := os.Getenv("CONFIDENCE_THRESHOLD"); threshold != "" {
+		parsed, err := strconv.ParseFloat(threshold, 64)
+		if err != nil || parsed < 0 || parsed > 1 {
+			return nil, fmt.Errorf("invalid CONFIDENCE_THRESHOLD: %q must be a number between 0 and 1", threshold)
+		}
+		cfg.ConfidenceThreshold = parsed
+	}
+
+	cfg.LowConfidenceAction = strings.ToLower(strings.TrimSpace(os.Getenv("LOW_CONFIDENCE_ACTION")))
+	switch cfg.LowConfidenceAction {
+	case "":
+		cfg.LowConfidenceAction = LowConfidenceFallback
+	case LowConfidenceFallback, LowConfidenceHandoff:
+	default:
+		return nil, fmt.Errorf("invalid LOW_CONFIDENCE_ACTION: %s", cfg.LowConfidenceAction)
+	}
+
+	cfg.LowConfidenceMessage = strings.TrimSpace(os.Getenv("LOW_CONFIDENCE_MESSAGE"))
+	if cfg.LowConfidenceMessage == "" {
+		cfg.LowConfidenceMessage = "I'm not sure about that one. Could you rephrase or give me a bit more detail?"
+	}
+
+	cfg.OpenAIOrgID = strings.TrimSpace(os.Getenv("OPENAI_ORG_ID"))
+	cfg.OpenAIProjectID = strings.TrimSpace(os.Getenv("OPENAI_PROJECT_ID"))
+
+	cfg.OpenAIAllowedModels = []string{cfg.OpenAIModel}
+	for _, allowed := range strings.Split(os.Getenv("OPENAI_ALLOWED_MODELS"), ",") {
+		if allowed = strings.TrimSpace(allowed); allowed != "" && !slices.Contains(cfg.OpenAIAllowedModels, allowed) {
+			cfg.OpenAIAllowedModels = append(cfg.OpenAIAllowedModels, allowed)
+		}
+	}
+
+	cfg.ReplyMode = strings.ToLower(strings.TrimSpace(os.Getenv("REPLY_MODE")))
+	switch cfg.ReplyMode {
+	case "":
+		cfg.ReplyMode = ReplyModeText
+	case ReplyModeText, ReplyModeAudio, ReplyModeBoth:
+	default:
+		return nil, fmt.Errorf("invalid REPLY_MODE: %s", cfg.ReplyMode)
+	}
+
+	cfg.AudioChunkChars = 600
+	if chunkChars := os.Getenv("AUDIO_CHUNK_CHARS"); chunkChars != "" {
+		parsed, err := strconv.Atoi(chunkChars)
+		if err != nil || parsed < 0 || parsed > 4096 {
+			return nil, fmt.Errorf("invalid AUDIO_CHUNK_CHARS: %q must be between 0 and 4096", chunkChars)
+		}
+		cfg.AudioChunkChars = parsed
+	}
+
+	for _, stop := range strings.Split(os.Getenv("OPENAI_STOP"), ",") {
+		if stop = strings.TrimSpace(stop); stop != "" {
+			cfg.OpenAIStop = append(cfg.OpenAIStop, stop)
+		}
+	}
+	if len(cfg.OpenAIStop) > 4 {
+		return nil, fmt.Errorf("invalid OPENAI_STOP: at most 4 stop sequences are allowed, got %d", len(cfg.OpenAIStop))
+	}
+
+	var err error
+	if cfg.OpenAIPresencePenalty, err = parsePenalty("OPENAI_PRESENCE_PENALTY"); err != nil {
+		return nil, err
+	}
+	if cfg.OpenAIFrequencyPenalty, err = parsePenalty("OPENAI_FREQUENCY_PENALTY"); err != nil {
+		return nil, err
+	}
+
+	cfg.EvolutionTLSCertFile = strings.TrimSpace(os.Getenv("EVOLUTION_TLS_CERT_FILE"))
+	cfg.EvolutionTLSKeyFile = strings.TrimSpace(os.Getenv("EVOLUTION_TLS_KEY_FILE"))
+	cfg.EvolutionTLSCAFile = strings.TrimSpace(os.Getenv("EVOLUTION_TLS_CA_FILE"))
+	cfg.EvolutionTLS, err = loadEvolutionTLS(cfg.EvolutionTLSCertFile, cfg.EvolutionTLSKeyFile, cfg.EvolutionTLSCAFile)
+	if err != nil {
+		return nil, err
+	}
+
+	if seed := os.Getenv("OPENAI_SEED"); seed != "" {
+		parsed, err := strconv.Atoi(seed)
+		if err != nil {
+			return nil, fmt.Errorf("invalid OPENAI_SEED: %w", err)
+		}
+		cfg.OpenAISeed = &parsed
+	}
+
+	if retries := os.Getenv("OPENAI_EMPTY_RETRIES"); retries != "" {
+		parsed, err := strconv.Atoi(retries)
+		if err != nil || parsed < 0 {
+			return nil, fmt.Errorf("invalid OPENAI_EMPTY_RETRIES: %q", retries)
+		}
+		cfg.OpenAIEmptyRetries = parsed
+	}
+
+	if continuations := os.Getenv("LENGTH_CONTINUATIONS"); continuations != "" {
+		parsed, err := strconv.Atoi(continuations)
+		if err != nil || parsed < 0 {
+			return nil, fmt.Errorf("invalid LENGTH_CONTINUATIONS: %q", continuations)
+		}
+		cfg.LengthContinuations = parsed
+	}
+
+	cfg.ContentFilterMessage = strings.TrimSpace(os.Getenv("CONTENT_FILTER_MESSAGE"))
+	if cfg.ContentFilterMessage == "" {
+		cfg.ContentFilterMessage = "Sorry, I can't help with that request."
+	}
+
+	if stream := os.Getenv("OPENAI_STREAM"); stream != "" {
+		parsed, err := strconv.ParseBool(stream)
+		if err != nil {
+			return nil, fmt.Errorf("invalid OPENAI_STREAM: %w", err)
+		}
+		cfg.OpenAIStream = parsed
+	}
+
+	cfg.StreamRecovery = strings.ToLower(strings.TrimSpace(os.Getenv("STREAM_RECOVERY")))
+	switch cfg.StreamRecovery {
+	case "":
+		cfg.StreamRecovery = StreamRecoveryRetry
+	case StreamRecoveryRetry, StreamRecoveryPartial:
+	default:
+		return nil, fmt.Errorf("invalid STREAM_RECOVERY: %s", cfg.StreamRecovery)
+	}
+
+	cfg.StreamContinuationNote = strings.TrimSpace(os.Getenv("STREAM_CONTINUATION_NOTE"))
+	if cfg.StreamContinuationNote == "" {
+		cfg.StreamContinuationNote = "(My reply was cut off. Send \"continue\" and I'll pick up where I left off.)"
+	}
+
+	if sanitize := os.Getenv("SANITIZE_MARKDOWN"); sanitize != "" {
+		parsed, err := strconv.ParseBool(sanitize)
+		if err != nil {
+			return nil, fmt.Errorf("invalid SANITIZE_MARKDOWN: %w", err)
+		}
+		cfg.SanitizeMarkdown = parsed
+	}
+
+	cfg.MarkReadTiming = strings.ToLower(strings.TrimSpace(os.Getenv("MARK_READ_TIMING")))
+	switch cfg.MarkReadTiming {
+	case "":
+		cfg.MarkReadTiming = MarkReadOnReceive
+	case MarkReadOnReceive, MarkReadAfterReply, MarkReadOff:
+	default:
+		return nil, fmt.Errorf("invalid MARK_READ_TIMING: %s", cfg.MarkReadTiming)
+	}
+
+	if err := loadHumanizeProfile(cfg); err != nil {
+		return nil, err
+	}
+
 	cfg.RedisAddr = os.Getenv("REDIS_ADDR")
 	cfg.RedisPassword = os.Getenv("REDIS_PASSWORD")
 
@@ -38,9 +328,382 @@ func LoadConfig() (*model.Config, error) {
 		cfg.RedisDB = parsedDB
 	}
 
-	if cfg.RedisAddr == "" {
-		return nil, errors.New("missing redis configuration: REDIS_ADDR")
+	cfg.StoreBackend = strings.ToLower(strings.TrimSpace(os.Getenv("STORE_BACKEND")))
+	if cfg.StoreBackend == "" {
+		cfg.StoreBackend = "redis"
+	}
+
+	cfg.OutboundQueueMax = 100
+	if queueMax := os.Getenv("OUTBOUND_QUEUE_MAX"); queueMax != "" {
+		parsed, err := strconv.Atoi(queueMax)
+		if err != nil || parsed < 0 {
+			return nil, fmt.Errorf("invalid OUTBOUND_QUEUE_MAX: %q", queueMax)
+		}
+		cfg.OutboundQueueMax = parsed
+	}
+
+	cfg.OutboundQueueMaxAge = time.Hour
+	if maxAge := os.Getenv("OUTBOUND_QUEUE_MAX_AGE"); maxAge != "" {
+		parsed, err := time.ParseDuration(maxAge)
+		if err != nil || parsed <= 0 {
+			return nil, fmt.Errorf("invalid OUTBOUND_QUEUE_MAX_AGE: %q", maxAge)
+		}
+		cfg.OutboundQueueMaxAge = parsed
+	}
+
+	cfg.ReplyCacheTTL = 24 * time.Hour
+	if ttl := os.Getenv("REPLY_CACHE_TTL"); ttl != "" {
+		parsed, err := time.ParseDuration(ttl)
+		if err != nil || parsed < 0 {
+			return nil, fmt.Errorf("invalid REPLY_CACHE_TTL: %q", ttl)
+		}
+		cfg.ReplyCacheTTL = parsed
+	}
+
+	if size := os.Getenv("WEBHOOK_HISTORY_SIZE"); size != "" {
+		parsed, err := strconv.Atoi(size)
+		if err != nil || parsed < 0 {
+			return nil, fmt.Errorf("invalid WEBHOOK_HISTORY_SIZE: %q", size)
+		}
+		cfg.WebhookHistory = parsed
+	}
+
+	if interval := os.Getenv("KEEPALIVE_INTERVAL"); interval != "" {
+		parsed, err := time.ParseDuration(interval)
+		if err != nil || parsed < 0 {
+			return nil, fmt.Errorf("invalid KEEPALIVE_INTERVAL: %q", interval)
+		}
+		cfg.KeepAliveInterval = parsed
+	}
+
+	if maxConversations := os.Getenv("MAX_CONVERSATIONS"); maxConversations != "" {
+		parsed, err := strconv.Atoi(maxConversations)
+		if err != nil || parsed < 0 {
+			return nil, fmt.Errorf("invalid MAX_CONVERSATIONS: %q", maxConversations)
+		}
+		cfg.MaxConversations = parsed
+	}
+
+	switch cfg.StoreBackend {
+	case "redis":
+		if cfg.RedisAddr == "" {
+			return nil, errors.New("missing redis configuration: REDIS_ADDR")
+		}
+	case "postgres":
+		cfg.PostgresDSN = os.Getenv("POSTGRES_DSN")
+		if cfg.PostgresDSN == "" {
+			return nil, errors.New("missing postgres configuration: POSTGRES_DSN")
+		}
+	default:
+		return nil, fmt.Errorf("invalid STORE_BACKEND: %s", cfg.StoreBackend)
+	}
+
+	if filter := os.Getenv("INBOUND_FILTER_REGEX"); filter != "" {
+		compiled, err := regexp.Compile(filter)
+		if err != nil {
+			return nil, fmt.Errorf("invalid INBOUND_FILTER_REGEX: %w", err)
+		}
+		cfg.InboundFilter = compiled
+	}
+
+	if recordFromMe := os.Getenv("RECORD_FROM_ME"); recordFromMe != "" {
+		parsed, err := strconv.ParseBool(recordFromMe)
+		if err != nil {
+			return nil, fmt.Errorf("invalid RECORD_FROM_ME: %w", err)
+		}
+		cfg.RecordFromMe = parsed
+	}
+
+	if labels := os.Getenv("GROUP_SPEAKER_LABELS"); labels != "" {
+		parsed, err := strconv.ParseBool(labels)
+		if err != nil {
+			return nil, fmt.Errorf("invalid GROUP_SPEAKER_LABELS: %w", err)
+		}
+		cfg.GroupSpeakerLabels = parsed
+	}
+
+	if actions := strings.TrimSpace(os.Getenv("REACTION_ACTIONS")); actions != "" {
+		cfg.ReactionActions = make(map[string]string)
+		for _, pair := range strings.Split(actions, ",") {
+			emoji, action, ok := strings.Cut(pair, "=")
+			emoji, action = strings.TrimSpace(emoji), strings.TrimSpace(action)
+			if !ok || emoji == "" || action == "" {
+				return nil, fmt.Errorf("invalid REACTION_ACTIONS entry: %q", pair)
+			}
+			cfg.ReactionActions[emoji] = action
+		}
+	}
+
+	if timeout := os.Getenv("MESSAGE_TIMEOUT"); timeout != "" {
+		parsed, err := time.ParseDuration(timeout)
+		if err != nil || parsed < 0 {
+			return nil, fmt.Errorf("invalid MESSAGE_TIMEOUT: %q", timeout)
+		}
+		cfg.MessageTimeout = parsed
+	}
+
+	cfg.TimeoutMessage = strings.TrimSpace(os.Getenv("TIMEOUT_MESSAGE"))
+	if cfg.TimeoutMessage == "" {
+		cfg.TimeoutMessage = "Sorry, this is taking longer than expected. Please try again in a moment."
+	}
+
+	cfg.ThinkingPlaceholder = strings.TrimSpace(os.Getenv("THINKING_PLACEHOLDER"))
+	if delay := os.Getenv("THINKING_PLACEHOLDER_DELAY"); delay != "" {
+		parsed, err := time.ParseDuration(delay)
+		if err != nil || parsed < 0 {
+			return nil, fmt.Errorf("invalid THINKING_PLACEHOLDER_DELAY: %q", delay)
+		}
+		cfg.ThinkingPlaceholderDelay = parsed
+	}
+
+	if quota := os.Getenv("DAILY_MESSAGE_QUOTA"); quota != "" {
+		parsed, err := strconv.Atoi(quota)
+		if err != nil || parsed < 0 {
+			return nil, fmt.Errorf("invalid DAILY_MESSAGE_QUOTA: %q", quota)
+		}
+		cfg.DailyMessageQuota = parsed
+	}
+
+	cfg.QuotaLocation = time.UTC
+	if tz := strings.TrimSpace(os.Getenv("QUOTA_TIMEZONE")); tz != "" {
+		loc, err := time.LoadLocation(tz)
+		if err != nil {
+			return nil, fmt.Errorf("invalid QUOTA_TIMEZONE: %w", err)
+		}
+		cfg.QuotaLocation = loc
+	}
+
+	cfg.QuotaExceededMessage = strings.TrimSpace(os.Getenv("QUOTA_EXCEEDED_MESSAGE"))
+	if cfg.QuotaExceededMessage == "" {
+		cfg.QuotaExceededMessage = "You've reached today's message limit. Please come back tomorrow!"
+	}
+
+	cfg.HandoffWebhookURL = strings.TrimSpace(os.Getenv("HANDOFF_WEBHOOK_URL"))
+	if cfg.HandoffWebhookURL != "" {
+		keywords := os.Getenv("HANDOFF_KEYWORDS")
+		if keywords == "" {
+			keywords = "talk to a person,talk to a human,human agent,real person"
+		}
+		for _, keyword := range strings.Split(keywords, ",") {
+			if keyword = strings.ToLower(strings.TrimSpace(keyword)); keyword != "" {
+				cfg.HandoffKeywords = append(cfg.HandoffKeywords, keyword)
+			}
+		}
+
+		cfg.HandoffMarker = strings.TrimSpace(os.Getenv("HANDOFF_MODEL_MARKER"))
+
+		cfg.HandoffMessage = strings.TrimSpace(os.Getenv("HANDOFF_MESSAGE"))
+		if cfg.HandoffMessage == "" {
+			cfg.HandoffMessage = "I've asked a member of our team to take over. They'll reply here shortly."
+		}
+
+		cfg.HandoffPauseDuration = time.Hour
+		if pause := os.Getenv("HANDOFF_PAUSE_DURATION"); pause != "" {
+			parsed, err := time.ParseDuration(pause)
+			if err != nil || parsed <= 0 {
+				return nil, fmt.Errorf("invalid HANDOFF_PAUSE_DURATION: %q", pause)
+			}
+			cfg.HandoffPauseDuration = parsed
+		}
+	}
+	if cfg.LowConfidenceAction == LowConfidenceHandoff && cfg.HandoffWebhookURL == "" {
+		return nil, errors.New("LOW_CONFIDENCE_ACTION=handoff requires HANDOFF_WEBHOOK_URL")
+	}
+
+	cfg.EphemeralPersistence = strings.ToLower(strings.TrimSpace(os.Getenv("EPHEMERAL_PERSISTENCE")))
+	switch cfg.EphemeralPersistence {
+	case "":
+		cfg.EphemeralPersistence = EphemeralPersistTTL
+	case EphemeralPersistFull, EphemeralPersistTTL, EphemeralPersistOff:
+	default:
+		return nil, fmt.Errorf("invalid EPHEMERAL_PERSISTENCE: %s", cfg.EphemeralPersistence)
+	}
+
+	cfg.LanguageDetection = strings.ToLower(strings.TrimSpace(os.Getenv("LANGUAGE_DETECTION")))
+	switch cfg.LanguageDetection {
+	case "":
+		cfg.LanguageDetection = LanguageDetectOff
+	case LanguageDetectOff, LanguageDetectHeuristic, LanguageDetectOpenAI:
+	default:
+		return nil, fmt.Errorf("invalid LANGUAGE_DETECTION: %s", cfg.LanguageDetection)
+	}
+	cfg.DefaultLanguage = strings.TrimSpace(os.Getenv("DEFAULT_LANGUAGE"))
+
+	if include := os.Getenv("PROMPT_INCLUDE_DATETIME"); include != "" {
+		parsed, err := strconv.ParseBool(include)
+		if err != nil {
+			return nil, fmt.Errorf("invalid PROMPT_INCLUDE_DATETIME: %w", err)
+		}
+		cfg.PromptDateTime = parsed
+	}
+
+	cfg.PromptLocation = time.UTC
+	if tz := strings.TrimSpace(os.Getenv("PROMPT_TIMEZONE")); tz != "" {
+		loc, err := time.LoadLocation(tz)
+		if err != nil {
+			return nil, fmt.Errorf("invalid PROMPT_TIMEZONE: %w", err)
+		}
+		cfg.PromptLocation = loc
+	}
+
+	cfg.MirrorWebhookURL = strings.TrimSpace(os.Getenv("MIRROR_WEBHOOK_URL"))
+
+	// Transcription uploads users' voice notes to OpenAI at extra cost, so it
+	// stays off until TRANSCRIBE_MAX_CONCURRENCY is set above 0.
+	cfg.TranscribeMaxConcurrency = 0
+	if limit := os.Getenv("TRANSCRIBE_MAX_CONCURRENCY"); limit != "" {
+		parsed, err := strconv.Atoi(limit)
+		if err != nil || parsed < 0 {
+			return nil, fmt.Errorf("invalid TRANSCRIBE_MAX_CONCURRENCY: %q", limit)
+		}
+		cfg.TranscribeMaxConcurrency = parsed
+	}
+
+	cfg.MediaMaxBytes = 16 << 20
+	if limit := os.Getenv("MEDIA_MAX_BYTES"); limit != "" {
+		parsed, err := strconv.ParseInt(limit, 10, 64)
+		if err != nil || parsed <= 0 {
+			return nil, fmt.Errorf("invalid MEDIA_MAX_BYTES: %q", limit)
+		}
+		cfg.MediaMaxBytes = parsed
+	}
+
+	for _, host := range strings.Split(os.Getenv("MEDIA_ALLOWED_HOSTS"), ",") {
+		if host = strings.ToLower(strings.TrimSpace(host)); host != "" {
+			cfg.MediaAllowedHosts = append(cfg.MediaAllowedHosts, host)
+		}
+	}
+
+	cfg.TranscribeWhenBusy = strings.ToLower(strings.TrimSpace(os.Getenv("TRANSCRIBE_WHEN_BUSY")))
+	switch cfg.TranscribeWhenBusy {
+	case "":
+		cfg.TranscribeWhenBusy = TranscribeBusyQueue
+	case TranscribeBusyQueue, TranscribeBusySkip:
+	default:
+		return nil, fmt.Errorf("invalid TRANSCRIBE_WHEN_BUSY: %s", cfg.TranscribeWhenBusy)
+	}
+
+	if schedule := strings.TrimSpace(os.Getenv("OFFICE_HOURS")); schedule != "" {
+		windows, err := parseOfficeHours(schedule)
+		if err != nil {
+			return nil, fmt.Errorf("invalid OFFICE_HOURS: %w", err)
+		}
+		cfg.OfficeHours = windows
+
+		cfg.OfficeHoursLocation = time.UTC
+		if tz := strings.TrimSpace(os.Getenv("OFFICE_HOURS_TIMEZONE")); tz != "" {
+			loc, err := time.LoadLocation(tz)
+			if err != nil {
+				return nil, fmt.Errorf("invalid OFFICE_HOURS_TIMEZONE: %w", err)
+			}
+			cfg.OfficeHoursLocation = loc
+		}
+
+		cfg.AfterHoursMessage = strings.TrimSpace(os.Getenv("AFTER_HOURS_MESSAGE"))
+		if cfg.AfterHoursMessage == "" {
+			cfg.AfterHoursMessage = "Thanks for your message! We're currently outside business hours and will get back to you as soon as we're open."
+		}
 	}
 
 	return cfg, nil
 }
+
+// loadEvolutionTLS builds the TLS configuration for Evolution deployments that
+// require a client certificate or use a private CA. It returns nil when none
+// of the files are configured, keeping Go's default TLS settings.
+func loadEvolutionTLS(certFile, keyFile, caFile string) (*tls.Config, error) {
+	if certFile == "" && keyFile == "" && caFile == "" {
+		return nil, nil
+	}
+
+	tlsConfig := &tls.Config{MinVersion: tls.VersionTLS12}
+
+	if certFile != "" || keyFile != "" {
+		if certFile == "" || keyFile == "" {
+			return nil, errors.New("EVOLUTION_TLS_CERT_FILE and EVOLUTION_TLS_KEY_FILE must be set together")
+		}
+		cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			return nil, fmt.Errorf("invalid EVOLUTION_TLS_CERT_FILE/EVOLUTION_TLS_KEY_FILE: %w", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+
+	if caFile != "" {
+		pem, err := os.ReadFile(caFile)
+		if err != nil {
+			return nil, fmt.Errorf("invalid EVOLUTION_TLS_CA_FILE: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("invalid EVOLUTION_TLS_CA_FILE: no PEM certificates found in %s", caFile)
+		}
+		tlsConfig.RootCAs = pool
+	}
+
+	return tlsConfig, nil
+}
+
+// loadHumanizeProfile applies the HUMANIZE preset and any HUMANIZE_* timing
+// overrides. An active profile takes over read receipts, so it cannot be
+// combined with an explicit MARK_READ_TIMING.
+func loadHumanizeProfile(cfg *model.Config) error {
+	cfg.HumanizeProfile = strings.ToLower(strings.TrimSpace(os.Getenv("HUMANIZE")))
+	if cfg.HumanizeProfile == "" {
+		cfg.HumanizeProfile = HumanizeOff
+	}
+	if cfg.HumanizeProfile == HumanizeOff {
+		return nil
+	}
+
+	preset, ok := humanizePresets[cfg.HumanizeProfile]
+	if !ok {
+		return fmt.Errorf("invalid HUMANIZE: %s", cfg.HumanizeProfile)
+	}
+	if os.Getenv("MARK_READ_TIMING") != "" {
+		return errors.New("MARK_READ_TIMING cannot be combined with HUMANIZE, which controls read receipts itself")
+	}
+	cfg.MarkReadTiming = MarkReadOff
+
+	for _, timing := range []struct {
+		name  string
+		value *time.Duration
+		def   time.Duration
+	}{
+		{"HUMANIZE_READ_DELAY", &cfg.HumanizeReadDelay, preset.ReadDelay},
+		{"HUMANIZE_TYPING_DELAY", &cfg.HumanizeTypingDelay, preset.TypingDelay},
+		{"HUMANIZE_REPLY_DELAY_PER_CHAR", &cfg.HumanizeReplyDelayPerChar, preset.ReplyDelayPerChar},
+		{"HUMANIZE_MAX_REPLY_DELAY", &cfg.HumanizeMaxReplyDelay, preset.MaxReplyDelay},
+	} {
+		*timing.value = timing.def
+		if raw := os.Getenv(timing.name); raw != "" {
+			parsed, err := time.ParseDuration(raw)
+			if err != nil || parsed < 0 {
+				return fmt.Errorf("invalid %s: %q", timing.name, raw)
+			}
+			*timing.value = parsed
+		}
+	}
+
+	// The typing indicator is a single presence request held open for this
+	// long, so it has to finish within the Evolution client's timeout.
+	if typing := cfg.HumanizeMaxReplyDelay + cfg.HumanizeTypingDelay; typing > evolutionRequestTimeout {
+		return fmt.Errorf("HUMANIZE_MAX_REPLY_DELAY plus HUMANIZE_TYPING_DELAY must not exceed %s, got %s", evolutionRequestTimeout, typing)
+	}
+	return nil
+}
+
+// parsePenalty reads an OpenAI presence/frequency penalty, which the API
+// accepts between -2.0 and 2.0. Unset variables yield the API default of 0.
+func parsePenalty(name string) (float32, error) {
+	raw := os.Getenv(name)
+	if raw == "" {
+		return 0, nil
+	}
+
+	parsed, err := strconv.ParseFloat(raw, 32)
+	if err != nil || parsed < -2 || parsed > 2 {
+		return 0, fmt.Errorf("invalid %s: %q must be a number between -2.0 and 2.0", name, raw)
+	}
+	return float32(parsed), nil
+}
@@ -0,0 +1,145 @@
+package service
+
+import (
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestLoadConfigSamplingSettings(t *testing.T) {
+	setRequiredEnv(t)
+	t.Setenv("OPENAI_STOP", "END, ###,")
+	t.Setenv("OPENAI_PRESENCE_PENALTY", "0.5")
+	t.Setenv("OPENAI_FREQUENCY_PENALTY", "-2")
+
+	cfg, err := LoadConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(cfg.OpenAIStop, []string{"END", "###"}) {
+		t.Errorf("OpenAIStop = %q", cfg.OpenAIStop)
+	}
+	if cfg.OpenAIPresencePenalty != 0.5 || cfg.OpenAIFrequencyPenalty != -2 {
+		t.Errorf("penalties = %v, %v", cfg.OpenAIPresencePenalty, cfg.OpenAIFrequencyPenalty)
+	}
+}
+
+func TestLoadConfigRejectsInvalidValues(t *testing.T) {
+	for _, tc := range []struct{ name, value string }{
+		{"OPENAI_STOP", "a,b,c,d,e"},
+		{"OPENAI_PRESENCE_PENALTY", "2.5"},
+		{"OPENAI_PRESENCE_PENALTY", "high"},
+		{"OPENAI_FREQUENCY_PENALTY", "-2.01"},
+	} {
+		t.Run(tc.name+"="+tc.value, func(t *testing.T) {
+			setRequiredEnv(t)
+			t.Setenv(tc.name, tc.value)
+			if _, err := LoadConfig(); err == nil {
+				t.Errorf("LoadConfig accepted %s=%q", tc.name, tc.value)
+			}
+		})
+	}
+}
+
+func TestLoadConfigLowConfidenceHandoffRequiresWebhook(t *testing.T) {
+	setRequiredEnv(t)
+	t.Setenv("LOW_CONFIDENCE_ACTION", "handoff")
+	if _, err := LoadConfig(); err == nil {
+		t.Fatal("LoadConfig accepted LOW_CONFIDENCE_ACTION=handoff without HANDOFF_WEBHOOK_URL")
+	}
+
+	t.Setenv("HANDOFF_WEBHOOK_URL", "https://crm.example.com/handoff")
+	cfg, err := LoadConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.LowConfidenceAction != LowConfidenceHandoff {
+		t.Errorf("LowConfidenceAction = %q, want handoff", cfg.LowConfidenceAction)
+	}
+}
+
+func TestLoadConfigGroupSpeakerLabelsDefaultOff(t *testing.T) {
+	setRequiredEnv(t)
+	cfg, err := LoadConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.GroupSpeakerLabels {
+		t.Error("GROUP_SPEAKER_LABELS defaults to on")
+	}
+}
+
+func TestLoadConfigSeed(t *testing.T) {
+	setRequiredEnv(t)
+	t.Setenv("OPENAI_SEED", "7")
+	cfg, err := LoadConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.OpenAISeed == nil || *cfg.OpenAISeed != 7 {
+		t.Errorf("OpenAISeed = %v, want 7", cfg.OpenAISeed)
+	}
+
+	t.Setenv("OPENAI_SEED", "")
+	if cfg, err = LoadConfig(); err != nil {
+		t.Fatal(err)
+	}
+	if cfg.OpenAISeed != nil {
+		t.Errorf("OpenAISeed = %d without OPENAI_SEED, want unset", *cfg.OpenAISeed)
+	}
+
+	t.Setenv("OPENAI_SEED", "random")
+	if _, err := LoadConfig(); err == nil {
+		t.Error("LoadConfig accepted OPENAI_SEED=random")
+	}
+}
+
+func TestLoadConfigInboundFilter(t *testing.T) {
+	setRequiredEnv(t)
+	t.Setenv("INBOUND_FILTER_REGEX", "^bot")
+	cfg, err := LoadConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.InboundFilter == nil || !cfg.InboundFilter.MatchString("bot hi") {
+		t.Errorf("InboundFilter = %v, want ^bot", cfg.InboundFilter)
+	}
+
+	t.Setenv("INBOUND_FILTER_REGEX", "(unclosed")
+	if _, err := LoadConfig(); err == nil {
+		t.Error("LoadConfig accepted an invalid INBOUND_FILTER_REGEX")
+	}
+}
+
+func TestLoadConfigRetryDefaults(t *testing.T) {
+	setRequiredEnv(t)
+	cfg, err := LoadConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.OpenAIMaxRetries != 0 || cfg.EvolutionMaxRetries != 0 {
+		t.Errorf("OpenAIMaxRetries = %d, EvolutionMaxRetries = %d, want retries off by default", cfg.OpenAIMaxRetries, cfg.EvolutionMaxRetries)
+	}
+	if cfg.RetryJitter != JitterFull || cfg.RetryBaseDelay != 500*time.Millisecond || cfg.RetryMaxDelay != 10*time.Second {
+		t.Errorf("retry delays %s %s-%s, want full jitter from 500ms to 10s", cfg.RetryJitter, cfg.RetryBaseDelay, cfg.RetryMaxDelay)
+	}
+}
+
+func TestLoadConfigTranscriptionOptIn(t *testing.T) {
+	setRequiredEnv(t)
+	cfg, err := LoadConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.TranscribeMaxConcurrency != 0 {
+		t.Errorf("TranscribeMaxConcurrency = %d by default, want transcription off", cfg.TranscribeMaxConcurrency)
+	}
+
+	t.Setenv("TRANSCRIBE_MAX_CONCURRENCY", "2")
+	if cfg, err = LoadConfig(); err != nil {
+		t.Fatal(err)
+	}
+	if cfg.TranscribeMaxConcurrency != 2 {
+		t.Errorf("TranscribeMaxConcurrency = %d, want 2", cfg.TranscribeMaxConcurrency)
+	}
+}
@@ -1,9 +1,15 @@
 package service
 
 import (
+	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
+	"expvar"
 	"fmt"
+	"log"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/redis/go-redis/v9"
@@ -12,13 +18,85 @@ import (
 	"hackathon/model"
 )
 
-type ConversationStore struct {
-	client      *redis.Client
-	ttl         time.Duration
-	maxMessages int
+const (
+	defaultConversationTTL = 24 * time.Hour
+	defaultMaxMessages     = 20
+
+	maxConversationSaveAttempts = 3
+
+	conversationIndexKey = "conversations:lru"
+)
+
+// ErrConversationConflict is returned by versioned saves when the stored
+// conversation changed after it was loaded.
+var ErrConversationConflict = errors.New("conversation was modified concurrently")
+
+// conversationEvictions counts conversations dropped to honour MAX_CONVERSATIONS.
+var conversationEvictions = expvar.NewInt("conversation_evictions")
+
+// ConversationStore persists the chat history exchanged with each user.
+type ConversationStore interface {
+	GetConversation(ctx context.Context, user string) ([]openai.ChatCompletionMessage, error)
+	SaveConversation(ctx context.Context, user string, messages []openai.ChatCompletionMessage) error
+	ClearConversation(ctx context.Context, user string) error
+	// PeekConversation returns user's history like GetConversation, without
+	// counting as use for MAX_CONVERSATIONS eviction.
+	PeekConversation(ctx context.Context, user string) ([]openai.ChatCompletionMessage, error)
+	// ListConversations returns up to limit users with a stored conversation,
+	// starting after cursor. The returned cursor is "" once every user has
+	// been listed. Pages may hold fewer than limit users before the end.
+	ListConversations(ctx context.Context, cursor string, limit int) ([]string, string, error)
+	Close() error
+}
+
+// VersionedConversationStore is implemented by stores that can detect
+// concurrent modifications. SaveConversationVersion fails with
+// ErrConversationConflict unless the stored version still equals version.
+type VersionedConversationStore interface {
+	LoadConversation(ctx context.Context, user string) ([]openai.ChatCompletionMessage, int64, error)
+	SaveConversationVersion(ctx context.Context, user string, messages []openai.ChatCompletionMessage, version int64) error
+}
+
+type storedConversation struct {
+	Version  int64                          `json:"version"`
+	Messages []openai.ChatCompletionMessage `json:"messages"`
+}
+
+// loadConversation reads user's history from store, along with its version
+// when the store supports optimistic versioning.
+func loadConversation(ctx context.Context, store ConversationStore, user string) ([]openai.ChatCompletionMessage, int64, error) {
+	if store == nil {
+		return nil, 0, nil
+	}
+	if versioned, ok := store.(VersionedConversationStore); ok {
+		return versioned.LoadConversation(ctx, user)
+	}
+	messages, err := store.GetConversation(ctx, user)
+	return messages, 0, err
 }
 
-func NewConversationStore(cfg *model.Config) (*ConversationStore, error) {
+// saveConversation writes user's history, rejecting the write with
+// ErrConversationConflict if a versioned store has moved past version.
+func saveConversation(ctx context.Context, store ConversationStore, user string, messages []openai.ChatCompletionMessage, version int64) error {
+	if store == nil {
+		return nil
+	}
+	if versioned, ok := store.(VersionedConversationStore); ok {
+		return versioned.SaveConversationVersion(ctx, user, messages, version)
+	}
+	return store.SaveConversation(ctx, user, messages)
+}
+
+type RedisConversationStore struct {
+	client           *redis.Client
+	ttl              time.Duration
+	maxMessages      int
+	maxConversations int
+}
+
+// NewRedisClient connects to the configured Redis server. The client is shared
+// by every Redis-backed component and owned by the caller.
+func NewRedisClient(cfg *model.Config) (*redis.Client, error) {
 	options := &redis.Options{
 		Addr:     cfg.RedisAddr,
 		Password: cfg.RedisPassword,
@@ -35,63 +113,277 @@ func NewConversationStore(cfg *model.Config) (*ConversationStore, error) {
 		return nil, fmt.Errorf("connect redis: %w", err)
 	}
 
-	return &ConversationStore{
-		client:      client,
-		ttl:         24 * time.Hour,
-		maxMessages: 20,
-	}, nil
+	return client, nil
 }
 
-func (s *ConversationStore) Close() error {
-	if s == nil || s.client == nil {
-		return nil
+func NewRedisConversationStore(client *redis.Client, cfg *model.Config) *RedisConversationStore {
+	return &RedisConversationStore{
+		client:           client,
+		ttl:              defaultConversationTTL,
+		maxMessages:      defaultMaxMessages,
+		maxConversations: cfg.MaxConversations,
+	}
+}
+
+// Close is a no-op: the Redis client is shared and closed by its owner.
+func (s *RedisConversationStore) Close() error {
+	return nil
+}
+
+func (s *RedisConversationStore) GetConversation(ctx context.Context, user string) ([]openai.ChatCompletionMessage, error) {
+	messages, _, err := s.LoadConversation(ctx, user)
+	return messages, err
+}
+
+func (s *RedisConversationStore) LoadConversation(ctx context.Context, user string) ([]openai.ChatCompletionMessage, int64, error) {
+	stored, found, err := s.read(ctx, user)
+	if err != nil || !found {
+		return nil, 0, err
+	}
+
+	// Reading counts as use, so a conversation that is read often but whose
+	// save failed is not evicted ahead of idle ones.
+	if err := s.touch(ctx, user); err != nil {
+		log.Printf("conversation index update failed for %s: %v", user, err)
 	}
-	return s.client.Close()
+
+	return stored.Messages, stored.Version, nil
+}
+
+func (s *RedisConversationStore) PeekConversation(ctx context.Context, user string) ([]openai.ChatCompletionMessage, error) {
+	stored, _, err := s.read(ctx, user)
+	return stored.Messages, err
 }
 
-func (s *ConversationStore) GetConversation(ctx context.Context, user string) ([]openai.ChatCompletionMessage, error) {
+func (s *RedisConversationStore) read(ctx context.Context, user string) (storedConversation, bool, error) {
 	if s == nil {
-		return nil, nil
+		return storedConversation{}, false, nil
 	}
 
-	key := s.key(user)
-	data, err := s.client.Get(ctx, key).Bytes()
+	data, err := s.client.Get(ctx, s.key(user)).Bytes()
 	if err != nil {
 		if err == redis.Nil {
-			return nil, nil
+			return storedConversation{}, false, nil
 		}
-		return nil, err
+		return storedConversation{}, false, err
 	}
 
-	var messages []openai.ChatCompletionMessage
-	if len(data) == 0 {
-		return messages, nil
+	stored, err := decodeConversation(data)
+	if err != nil {
+		return storedConversation{}, false, err
 	}
+	return stored, true, nil
+}
 
-	if err := json.Unmarshal(data, &messages); err != nil {
-		return nil, fmt.Errorf("decode conversation: %w", err)
+func (s *RedisConversationStore) SaveConversation(ctx context.Context, user string, messages []openai.ChatCompletionMessage) error {
+	return s.save(ctx, user, messages, -1)
+}
+
+func (s *RedisConversationStore) SaveConversationVersion(ctx context.Context, user string, messages []openai.ChatCompletionMessage, version int64) error {
+	return s.save(ctx, user, messages, version)
+}
+
+// save writes messages under WATCH so a concurrent writer aborts the
+// transaction. A negative expected version skips the version check but
+// still bumps the stored version.
+func (s *RedisConversationStore) save(ctx context.Context, user string, messages []openai.ChatCompletionMessage, expected int64) error {
+	if s == nil {
+		return nil
 	}
 
-	return messages, nil
+	key := s.key(user)
+	messages = trimConversation(messages, s.maxMessages)
+
+	err := s.client.Watch(ctx, func(tx *redis.Tx) error {
+		var current int64
+		data, err := tx.Get(ctx, key).Bytes()
+		switch {
+		case err == redis.Nil:
+		case err != nil:
+			return err
+		default:
+			stored, err := decodeConversation(data)
+			if err != nil {
+				return err
+			}
+			current = stored.Version
+		}
+
+		if expected >= 0 && current != expected {
+			return ErrConversationConflict
+		}
+
+		payload, err := json.Marshal(storedConversation{Version: current + 1, Messages: messages})
+		if err != nil {
+			return fmt.Errorf("encode conversation: %w", err)
+		}
+
+		_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			pipe.Set(ctx, key, payload, s.ttl)
+			return nil
+		})
+		return err
+	}, key)
+	if err == redis.TxFailedErr {
+		if expected < 0 {
+			return fmt.Errorf("save conversation: %w", err)
+		}
+		return ErrConversationConflict
+	}
+	if err != nil {
+		return err
+	}
+
+	return s.touch(ctx, user)
 }
 
-func (s *ConversationStore) SaveConversation(ctx context.Context, user string, messages []openai.ChatCompletionMessage) error {
+func (s *RedisConversationStore) ListConversations(ctx context.Context, cursor string, limit int) ([]string, string, error) {
 	if s == nil {
+		return nil, "", nil
+	}
+
+	var position uint64
+	if cursor != "" {
+		parsed, err := strconv.ParseUint(cursor, 10, 64)
+		if err != nil {
+			return nil, "", fmt.Errorf("invalid cursor %q", cursor)
+		}
+		position = parsed
+	}
+
+	keys, next, err := s.client.Scan(ctx, position, s.key("*"), int64(limit)).Result()
+	if err != nil {
+		return nil, "", err
+	}
+
+	users := make([]string, 0, len(keys))
+	for _, key := range keys {
+		users = append(users, strings.TrimPrefix(key, s.key("")))
+	}
+
+	if next == 0 {
+		return users, "", nil
+	}
+	return users, strconv.FormatUint(next, 10), nil
+}
+
+// ExpireConversation shortens the lifetime of user's conversation to ttl when
+// that is sooner than the store's default expiry.
+func (s *RedisConversationStore) ExpireConversation(ctx context.Context, user string, ttl time.Duration) error {
+	if s == nil || ttl >= s.ttl {
+		return nil
+	}
+	return s.client.Expire(ctx, s.key(user), ttl).Err()
+}
+
+func (s *RedisConversationStore) ClearConversation(ctx context.Context, user string) error {
+	if s == nil {
+		return nil
+	}
+	if err := s.client.Del(ctx, s.key(user)).Err(); err != nil {
+		return err
+	}
+	if s.maxConversations > 0 {
+		return s.client.ZRem(ctx, conversationIndexKey, user).Err()
+	}
+	return nil
+}
+
+// touch records user as most recently used, on every load and save, and evicts
+// the least recently used conversations once more than maxConversations are
+// stored.
+func (s *RedisConversationStore) touch(ctx context.Context, user string) error {
+	if s.maxConversations <= 0 {
 		return nil
 	}
 
-	if len(messages) > s.maxMessages {
-		messages = messages[len(messages)-s.maxMessages:]
+	now := time.Now()
+	pipe := s.client.TxPipeline()
+	pipe.ZAdd(ctx, conversationIndexKey, redis.Z{Score: float64(now.UnixNano()), Member: user})
+	pipe.ZRemRangeByScore(ctx, conversationIndexKey, "-inf", fmt.Sprintf("(%d", now.Add(-s.ttl).UnixNano()))
+	size := pipe.ZCard(ctx, conversationIndexKey)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return err
 	}
 
-	payload, err := json.Marshal(messages)
+	excess := size.Val() - int64(s.maxConversations)
+	if excess <= 0 {
+		return nil
+	}
+
+	evicted, err := s.client.ZPopMin(ctx, conversationIndexKey, excess).Result()
 	if err != nil {
-		return fmt.Errorf("encode conversation: %w", err)
+		return err
 	}
 
-	return s.client.Set(ctx, s.key(user), payload, s.ttl).Err()
+	for _, entry := range evicted {
+		member, _ := entry.Member.(string)
+		if err := s.client.Del(ctx, s.key(member)).Err(); err != nil {
+			return err
+		}
+		conversationEvictions.Add(1)
+		log.Printf("conversation evicted for %s: store limit of %d reached", member, s.maxConversations)
+	}
+
+	return nil
 }
 
-func (s *ConversationStore) key(user string) string {
+func (s *RedisConversationStore) key(user string) string {
 	return fmt.Sprintf("conversation:%s", user)
 }
+
+// decodeConversation reads a stored conversation. Histories written before
+// versioning was introduced are plain JSON arrays and decode as version 0.
+func decodeConversation(data []byte) (storedConversation, error) {
+	var stored storedConversation
+	data = bytes.TrimSpace(data)
+	if len(data) == 0 {
+		return stored, nil
+	}
+
+	var target any = &stored
+	if data[0] == '[' {
+		target = &stored.Messages
+	}
+
+	if err := json.Unmarshal(data, target); err != nil {
+		return stored, fmt.Errorf("decode conversation: %w", err)
+	}
+	return stored, nil
+}
+
+func trimConversation(messages []openai.ChatCompletionMessage, maxMessages int) []openai.ChatCompletionMessage {
+	if maxMessages > 0 && len(messages) > maxMessages {
+		return messages[len(messages)-maxMessages:]
+	}
+	return messages
+}
+
+// normalizeTurns merges consecutive user or assistant messages so the history
+// alternates between the two, as some models require. A crash between storing
+// the user's message and the reply can otherwise leave two user turns in a
+// row. Merged contents are joined, so nothing the user said is lost.
+func normalizeTurns(messages []openai.ChatCompletionMessage) []openai.ChatCompletionMessage {
+	normalized := make([]openai.ChatCompletionMessage, 0, len(messages))
+	for _, msg := range messages {
+		if n := len(normalized); n > 0 && mergeableTurns(normalized[n-1], msg) {
+			normalized[n-1].Content += "\n\n" + msg.Content
+			continue
+		}
+		normalized = append(normalized, msg)
+	}
+	return normalized
+}
+
+func mergeableTurns(prev, next openai.ChatCompletionMessage) bool {
+	if prev.Role != next.Role {
+		return false
+	}
+	if prev.Role != openai.ChatMessageRoleUser && prev.Role != openai.ChatMessageRoleAssistant {
+		return false
+	}
+	plain := func(msg openai.ChatCompletionMessage) bool {
+		return len(msg.MultiContent) == 0 && len(msg.ToolCalls) == 0 && msg.FunctionCall == nil
+	}
+	return plain(prev) && plain(next)
+}
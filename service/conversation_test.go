@@ -0,0 +1,146 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"sync"
+	"testing"
+
+	openai "github.com/sashabaranov/go-openai"
+)
+
+func TestRedisConversationStoreEvictsLeastRecentlyUsed(t *testing.T) {
+	_, client := newTestRedis(t)
+	cfg := testConfig("")
+	cfg.MaxConversations = 2
+	store := NewRedisConversationStore(client, cfg)
+	ctx := context.Background()
+
+	turn := []openai.ChatCompletionMessage{{Role: openai.ChatMessageRoleUser, Content: "hi"}}
+	for _, user := range []string{"alice", "bob"} {
+		if err := store.SaveConversation(ctx, user, turn); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Reading alice makes bob the least recently used conversation.
+	if _, err := store.GetConversation(ctx, "alice"); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.SaveConversation(ctx, "carol", turn); err != nil {
+		t.Fatal(err)
+	}
+
+	for user, kept := range map[string]bool{"alice": true, "bob": false, "carol": true} {
+		messages, err := store.GetConversation(ctx, user)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := len(messages) > 0; got != kept {
+			t.Errorf("conversation for %s kept = %v, want %v", user, got, kept)
+		}
+	}
+}
+
+func TestRedisConversationStoreRejectsStaleVersion(t *testing.T) {
+	_, client := newTestRedis(t)
+	store := NewRedisConversationStore(client, testConfig(""))
+	ctx := context.Background()
+
+	turn := []openai.ChatCompletionMessage{{Role: openai.ChatMessageRoleUser, Content: "hi"}}
+	if err := store.SaveConversationVersion(ctx, "user", turn, 0); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.SaveConversationVersion(ctx, "user", turn, 0); !errors.Is(err, ErrConversationConflict) {
+		t.Errorf("save on a stale version returned %v, want ErrConversationConflict", err)
+	}
+
+	_, version, err := store.LoadConversation(ctx, "user")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if version != 1 {
+		t.Errorf("version = %d, want 1", version)
+	}
+}
+
+func TestConcurrentRepliesLoseNoTurns(t *testing.T) {
+	_, client := newTestRedis(t)
+	oa := newFakeOpenAI(t)
+	cfg := testConfig("")
+	store := NewRedisConversationStore(client, cfg)
+	ctx := context.Background()
+
+	// Each round of conflicting saves has a winner, so this many concurrent
+	// replies all fit within maxConversationSaveAttempts.
+	inputs := make([]string, maxConversationSaveAttempts)
+	var wg sync.WaitGroup
+	for i := range inputs {
+		inputs[i] = fmt.Sprintf("message %d", i)
+		wg.Add(1)
+		go func(input string) {
+			defer wg.Done()
+			if _, err := generateAssistantReply(ctx, oa.Client(), store, cfg, "5511999999999", input, replyOptions{}); err != nil {
+				t.Error(err)
+			}
+		}(inputs[i])
+	}
+	wg.Wait()
+
+	messages, err := store.GetConversation(ctx, "5511999999999")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(messages) != 2*len(inputs) {
+		t.Fatalf("stored %d messages, want %d", len(messages), 2*len(inputs))
+	}
+	for _, input := range inputs {
+		if !slices.ContainsFunc(messages, func(msg openai.ChatCompletionMessage) bool { return msg.Content == input }) {
+			t.Errorf("turn %q was lost", input)
+		}
+	}
+}
+
+func TestNormalizeTurns(t *testing.T) {
+	user := func(content string) openai.ChatCompletionMessage {
+		return openai.ChatCompletionMessage{Role: openai.ChatMessageRoleUser, Content: content}
+	}
+	assistant := func(content string) openai.ChatCompletionMessage {
+		return openai.ChatCompletionMessage{Role: openai.ChatMessageRoleAssistant, Content: content}
+	}
+	system := openai.ChatCompletionMessage{Role: openai.ChatMessageRoleSystem, Content: "be nice"}
+	image := openai.ChatCompletionMessage{Role: openai.ChatMessageRoleUser, MultiContent: []openai.ChatMessagePart{{Type: openai.ChatMessagePartTypeText, Text: "look"}}}
+
+	// A crash left two user turns in a row, a duplicated reply, a repeated
+	// system prompt and a multi-part message that must not be flattened.
+	malformed := []openai.ChatCompletionMessage{
+		system, system,
+		user("hi"), user("are you there?"),
+		assistant("yes"), assistant("how can I help?"),
+		user("this"), image,
+	}
+	want := []openai.ChatCompletionMessage{
+		system, system,
+		user("hi\n\nare you there?"),
+		assistant("yes\n\nhow can I help?"),
+		user("this"), image,
+	}
+
+	got := normalizeTurns(malformed)
+	if len(got) != len(want) {
+		t.Fatalf("%d messages, want %d: %+v", len(got), len(want), got)
+	}
+	for i := range want {
+		if got[i].Role != want[i].Role || got[i].Content != want[i].Content || len(got[i].MultiContent) != len(want[i].MultiContent) {
+			t.Errorf("message %d = %+v, want %+v", i, got[i], want[i])
+		}
+	}
+	if malformed[2].Content != "hi" {
+		t.Error("normalizeTurns modified its input")
+	}
+	if got := normalizeTurns(nil); len(got) != 0 {
+		t.Errorf("normalizeTurns(nil) = %+v", got)
+	}
+}
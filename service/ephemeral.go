@@ -0,0 +1,143 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log"
+	"time"
+
+	openai "github.com/sashabaranov/go-openai"
+
+	"hackathon/model"
+)
+
+const (
+	EphemeralPersistFull = "full"
+	EphemeralPersistTTL  = "ttl"
+	EphemeralPersistOff  = "off"
+)
+
+// ExpiringConversationStore is implemented by stores that can expire a single
+// conversation sooner than their default TTL.
+type ExpiringConversationStore interface {
+	ExpireConversation(ctx context.Context, user string, ttl time.Duration) error
+}
+
+// messageExpiration returns the disappearing-messages timer of the chat msg
+// was sent in, or zero for a regular chat.
+func messageExpiration(msg model.WebhookMessage) time.Duration {
+	for _, info := range []*model.ContextInfo{msg.ContextInfo, extendedContextInfo(msg)} {
+		if info != nil && info.Expiration > 0 {
+			return time.Duration(info.Expiration) * time.Second
+		}
+	}
+	return 0
+}
+
+func extendedContextInfo(msg model.WebhookMessage) *model.ContextInfo {
+	if msg.ExtendedTextMessage == nil {
+		return nil
+	}
+	return msg.ExtendedTextMessage.ContextInfo
+}
+
+// ephemeralChat reports whether msg was sent in a disappearing chat whose
+// content must not outlive the conversation history. Such messages are kept
+// out of the reply cache, the retry queue and the webhook history.
+func ephemeralChat(cfg *model.Config, msg model.WebhookMessage) bool {
+	return messageExpiration(msg) > 0 && cfg.EphemeralPersistence != EphemeralPersistFull
+}
+
+// ephemeralPayload reports whether a raw webhook body carries a message for
+// which ephemeralChat holds.
+func ephemeralPayload(cfg *model.Config, body []byte) bool {
+	if cfg.EphemeralPersistence == EphemeralPersistFull {
+		return false
+	}
+
+	var payload model.WebhookPayload
+	if err := json.Unmarshal(body, &payload); err != nil {
+		return false
+	}
+
+	var container struct {
+		Messages []model.MessagesUpsertEntry `json:"messages"`
+	}
+	var single model.MessagesUpsertEntry
+	_ = json.Unmarshal(payload.Data, &container)
+	_ = json.Unmarshal(payload.Data, &single)
+
+	for _, entry := range append(container.Messages, single) {
+		if ephemeralChat(cfg, entryMessage(entry)) {
+			return true
+		}
+	}
+	return false
+}
+
+// ephemeralSetting returns the disappearing-messages timer set by msg, and
+// whether msg is such a setting at all. A zero timer turns the feature off.
+func ephemeralSetting(msg model.WebhookMessage) (time.Duration, bool) {
+	if msg.ProtocolMessage == nil {
+		return 0, false
+	}
+
+	kind := bytes.TrimSpace(msg.ProtocolMessage.Type)
+	if !bytes.Equal(kind, []byte(`"EPHEMERAL_SETTING"`)) && !bytes.Equal(kind, []byte(`3`)) {
+		return 0, false
+	}
+	return time.Duration(msg.ProtocolMessage.EphemeralExpiration) * time.Second, true
+}
+
+// applyEphemeralSetting brings the stored history of a chat that just turned
+// disappearing messages on in line with EPHEMERAL_PERSISTENCE, so turns from
+// before the switch do not outlive the ones after it.
+func (b *Bot) applyEphemeralSetting(ctx context.Context, cfg *model.Config, sender string, msg model.WebhookMessage, key model.WebhookKey, expiration time.Duration) {
+	if expiration <= 0 || cfg.EphemeralPersistence == EphemeralPersistFull || b.Store == nil {
+		return
+	}
+
+	recipient := resolveRecipient(cfg, key, msg, sender)
+	if recipient == "" {
+		return
+	}
+	user := normalizeWhatsAppID(recipient)
+
+	var err error
+	expiring, ok := b.Store.(ExpiringConversationStore)
+	if cfg.EphemeralPersistence == EphemeralPersistOff || !ok {
+		err = b.Store.ClearConversation(ctx, user)
+	} else {
+		err = expiring.ExpireConversation(ctx, user, expiration)
+	}
+	if err != nil {
+		log.Printf("applying disappearing-messages timer to %s failed: %v", user, err)
+		return
+	}
+	log.Printf("disappearing messages turned on for %s (%s)", user, expiration)
+}
+
+// persistConversation saves user's history, honouring the chat's
+// disappearing-messages timer. Depending on EPHEMERAL_PERSISTENCE, history of a
+// disappearing chat expires with the timer ("ttl"), is not kept at all
+// ("off") or is stored like any other ("full"). Stores that cannot expire a
+// single conversation fall back to not keeping it.
+func persistConversation(ctx context.Context, store ConversationStore, cfg *model.Config, user string, messages []openai.ChatCompletionMessage, version int64, expiration time.Duration) error {
+	if expiration <= 0 || cfg.EphemeralPersistence == EphemeralPersistFull {
+		return saveConversation(ctx, store, user, messages, version)
+	}
+	if store == nil {
+		return nil
+	}
+
+	expiring, ok := store.(ExpiringConversationStore)
+	if cfg.EphemeralPersistence == EphemeralPersistOff || !ok {
+		return store.ClearConversation(ctx, user)
+	}
+
+	if err := saveConversation(ctx, store, user, messages, version); err != nil {
+		return err
+	}
+	return expiring.ExpireConversation(ctx, user, expiration)
+}
@@ -0,0 +1,173 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	openai "github.com/sashabaranov/go-openai"
+
+	"hackathon/model"
+)
+
+// ephemeralEntry is a text message from a chat with a one-day
+// disappearing-messages timer, wrapped the way WhatsApp sends it.
+const ephemeralEntry = `{
+	"key": {"remoteJid": "5511999999999@s.whatsapp.net", "id": "in-1"},
+	"message": {"ephemeralMessage": {"message": {"extendedTextMessage": {
+		"text": "secret plans",
+		"contextInfo": {"expiration": 86400}
+	}}}}
+}`
+
+func TestEntryMessageUnwrapsEphemeralMessage(t *testing.T) {
+	var entry model.MessagesUpsertEntry
+	if err := json.Unmarshal([]byte(ephemeralEntry), &entry); err != nil {
+		t.Fatal(err)
+	}
+
+	msg := entryMessage(entry)
+	if got := extractMessageText(msg); got != "secret plans" {
+		t.Errorf("text %q, want the wrapped message's text", got)
+	}
+	if got := messageExpiration(msg); got != 24*time.Hour {
+		t.Errorf("expiration %s, want 24h", got)
+	}
+}
+
+func TestEphemeralSetting(t *testing.T) {
+	for _, tc := range []struct {
+		message string
+		want    time.Duration
+		ok      bool
+	}{
+		{`{"protocolMessage":{"type":"EPHEMERAL_SETTING","ephemeralExpiration":604800}}`, 7 * 24 * time.Hour, true},
+		{`{"protocolMessage":{"type":3,"ephemeralExpiration":86400}}`, 24 * time.Hour, true},
+		{`{"protocolMessage":{"type":"EPHEMERAL_SETTING","ephemeralExpiration":0}}`, 0, true},
+		{`{"protocolMessage":{"type":"REVOKE"}}`, 0, false},
+		{`{"conversation":"hi"}`, 0, false},
+	} {
+		var msg model.WebhookMessage
+		if err := json.Unmarshal([]byte(tc.message), &msg); err != nil {
+			t.Fatal(err)
+		}
+		if got, ok := ephemeralSetting(msg); got != tc.want || ok != tc.ok {
+			t.Errorf("%s: got %s, %v, want %s, %v", tc.message, got, ok, tc.want, tc.ok)
+		}
+	}
+}
+
+func TestEphemeralMessagesNotPersisted(t *testing.T) {
+	for _, tc := range []struct {
+		mode    string
+		persist bool
+	}{
+		{EphemeralPersistTTL, false},
+		{EphemeralPersistOff, false},
+		{EphemeralPersistFull, true},
+	} {
+		t.Run(tc.mode, func(t *testing.T) {
+			evo, oa := newFakeEvolution(t), newFakeOpenAI(t)
+			cfg := testConfig(evo.URL)
+			cfg.EphemeralPersistence = tc.mode
+			_, client := newTestRedis(t)
+			bot := newTestBot(cfg, evo, oa)
+			bot.Replies = NewReplyCache(client, time.Hour)
+			bot.History = NewWebhookHistory(5)
+
+			body, _ := json.Marshal(model.WebhookPayload{Event: "messages.upsert", Instance: "bot", Data: json.RawMessage(ephemeralEntry)})
+			rec := httptest.NewRecorder()
+			WebhookHandler(bot)(rec, httptest.NewRequest(http.MethodPost, "/webhook", strings.NewReader(string(body))))
+			if rec.Code != http.StatusOK {
+				t.Fatalf("status %d", rec.Code)
+			}
+
+			if texts := evo.Texts(); len(texts) != 1 {
+				t.Errorf("sent %q, want the reply", texts)
+			}
+			if cached := client.Exists(context.Background(), "reply:in-1").Val() == 1; cached != tc.persist {
+				t.Errorf("reply cached = %v, want %v", cached, tc.persist)
+			}
+			if stored := len(bot.History.Entries()) == 1; stored != tc.persist {
+				t.Errorf("webhook stored in history = %v, want %v", stored, tc.persist)
+			}
+		})
+	}
+}
+
+func TestEphemeralMessagesNotQueuedForRetry(t *testing.T) {
+	evo, oa := newFakeEvolution(t), newFakeOpenAI(t)
+	oa.Reply(fakeCompletion{Status: http.StatusServiceUnavailable})
+	cfg := testConfig(evo.URL)
+	cfg.GenerationRetryMax = 10
+	cfg.GenerationRetryMaxAge = time.Hour
+	_, client := newTestRedis(t)
+	bot := newTestBot(cfg, evo, oa)
+	bot.Retries = NewGenerationRetryQueue(client, cfg)
+
+	var entry model.MessagesUpsertEntry
+	if err := json.Unmarshal([]byte(ephemeralEntry), &entry); err != nil {
+		t.Fatal(err)
+	}
+	if err := bot.handleMessage(context.Background(), cfg, "", "", entryMessage(entry), entry.Key); err == nil {
+		t.Fatal("generation failure was not reported")
+	}
+	if n := client.LLen(context.Background(), "generation-retry:bot").Val(); n != 0 {
+		t.Errorf("%d messages queued for retry, want the disappearing message kept out", n)
+	}
+
+	msg, key := textMessage("in-2", "regular chat")
+	if err := bot.handleMessage(context.Background(), cfg, "", "", msg, key); err != nil {
+		t.Fatal(err)
+	}
+	if n := client.LLen(context.Background(), "generation-retry:bot").Val(); n != 1 {
+		t.Errorf("%d messages queued for retry, want the regular message queued", n)
+	}
+}
+
+func TestEphemeralSettingAppliesToStoredHistory(t *testing.T) {
+	for _, tc := range []struct {
+		mode    string
+		wantTTL time.Duration
+		kept    bool
+	}{
+		{EphemeralPersistTTL, time.Hour, true},
+		{EphemeralPersistOff, 0, false},
+		{EphemeralPersistFull, defaultConversationTTL, true},
+	} {
+		t.Run(tc.mode, func(t *testing.T) {
+			evo := newFakeEvolution(t)
+			cfg := testConfig(evo.URL)
+			cfg.EphemeralPersistence = tc.mode
+			_, client := newTestRedis(t)
+			bot := newTestBot(cfg, evo, nil)
+			bot.Store = NewRedisConversationStore(client, cfg)
+
+			ctx := context.Background()
+			if err := bot.Store.SaveConversation(ctx, "5511999999999", []openai.ChatCompletionMessage{{Role: openai.ChatMessageRoleUser, Content: "hi"}}); err != nil {
+				t.Fatal(err)
+			}
+
+			msg := model.WebhookMessage{ProtocolMessage: &model.ProtocolMessage{Type: json.RawMessage(`"EPHEMERAL_SETTING"`), EphemeralExpiration: 3600}}
+			_, key := textMessage("in-1", "")
+			if err := bot.handleMessage(ctx, cfg, "", "", msg, key); err != nil {
+				t.Fatal(err)
+			}
+
+			ttl := client.TTL(ctx, "conversation:5511999999999").Val()
+			if kept := ttl > 0; kept != tc.kept {
+				t.Fatalf("history kept = %v, want %v", kept, tc.kept)
+			}
+			if tc.kept && ttl != tc.wantTTL {
+				t.Errorf("history TTL %s, want %s", ttl, tc.wantTTL)
+			}
+			if texts := evo.Texts(); len(texts) != 0 {
+				t.Errorf("sent %q in reply to a protocol message", texts)
+			}
+		})
+	}
+}
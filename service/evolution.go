@@ -3,49 +3,356 @@ package service
 import (
 	"bytes"
 	"context"
+	"encoding/base64"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"log"
+	"net"
 	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"hackathon/model"
 )
 
+const maxEvolutionResponseBytes = 64 << 10
+
+// evolutionRequestTimeout bounds every Evolution API call, including
+// requests Evolution holds open such as presence updates.
+const evolutionRequestTimeout = 10 * time.Second
+
+// mediaDownloadTimeout bounds streaming one media file from object storage,
+// which can take longer than an API call.
+const mediaDownloadTimeout = 60 * time.Second
+
+// errMediaTooLarge is returned when downloaded media exceeds MEDIA_MAX_BYTES.
+var errMediaTooLarge = errors.New("media exceeds the configured size limit")
+
 type EvolutionClient struct {
 	baseURL    string
 	apiKey     string
 	instance   string
 	httpClient *http.Client
+
+	// rateLimitCooldown is how long all requests pause after Evolution answers
+	// 429 without a Retry-After header.
+	rateLimitCooldown time.Duration
+
+	// maxRetries bounds retries of requests that failed with a network error,
+	// a 429 or a 5xx. Delays follow the jitter settings in retryConfig, the
+	// configuration the client was built with, which is why those settings
+	// are static.
+	maxRetries  int
+	retryConfig *model.Config
+
+	// mediaClient fetches media from object storage. It carries no client
+	// certificate, since those are meant for Evolution alone, and only
+	// fetches from mediaHosts: the Evolution host and MEDIA_ALLOWED_HOSTS.
+	mediaClient *http.Client
+	mediaHosts  []string
+
+	mu            sync.Mutex
+	cooldownUntil time.Time
 }
 
 func NewEvolutionClient(cfg *model.Config) *EvolutionClient {
-	return &EvolutionClient{
-		baseURL:    cfg.EvolutionAPIURL,
-		apiKey:     cfg.EvolutionAPIKey,
-		instance:   cfg.EvolutionInstance,
-		httpClient: &http.Client{Timeout: 10 * time.Second},
+	httpClient := &http.Client{Timeout: evolutionRequestTimeout}
+	if cfg.EvolutionTLS != nil {
+		transport := http.DefaultTransport.(*http.Transport).Clone()
+		transport.TLSClientConfig = cfg.EvolutionTLS
+		httpClient.Transport = transport
+	}
+
+	mediaHosts := slices.Clone(cfg.MediaAllowedHosts)
+	if base, err := url.Parse(cfg.EvolutionAPIURL); err == nil && base.Host != "" {
+		mediaHosts = append(mediaHosts, strings.ToLower(base.Host))
 	}
+
+	e := &EvolutionClient{
+		baseURL:           cfg.EvolutionAPIURL,
+		apiKey:            cfg.EvolutionAPIKey,
+		instance:          cfg.EvolutionInstance,
+		httpClient:        httpClient,
+		rateLimitCooldown: cfg.EvolutionRateLimitCooldown,
+		maxRetries:        cfg.EvolutionMaxRetries,
+		retryConfig:       cfg,
+		mediaHosts:        mediaHosts,
+	}
+	e.mediaClient = &http.Client{CheckRedirect: e.checkMediaRedirect}
+	return e
 }
 
-func (e *EvolutionClient) SendTextMessage(ctx context.Context, to, message string) error {
+func (e *EvolutionClient) SendTextMessage(ctx context.Context, to, message string) (*model.SendMessageResponse, error) {
 	payload := map[string]any{
 		"number": to,
 		"text":   message,
 	}
 
-	return e.postJSON(ctx, fmt.Sprintf("%s/message/sendText/%s", e.baseURL, e.instance), payload)
+	var sent model.SendMessageResponse
+	if err := e.postJSON(ctx, fmt.Sprintf("%s/message/sendText/%s", e.baseURL, e.instance), payload, &sent); err != nil {
+		return nil, err
+	}
+	return &sent, nil
+}
+
+func (e *EvolutionClient) SendAudioMessage(ctx context.Context, to string, audio []byte) (*model.SendMessageResponse, error) {
+	payload := map[string]any{
+		"number":   to,
+		"audio":    base64.StdEncoding.EncodeToString(audio),
+		"encoding": true,
+	}
+
+	var sent model.SendMessageResponse
+	if err := e.postJSON(ctx, fmt.Sprintf("%s/message/sendWhatsAppAudio/%s", e.baseURL, e.instance), payload, &sent); err != nil {
+		return nil, err
+	}
+	return &sent, nil
+}
+
+func (e *EvolutionClient) MarkMessageAsRead(ctx context.Context, key model.WebhookKey) error {
+	payload := map[string]any{
+		"readMessages": []map[string]any{
+			{
+				"remoteJid": key.RemoteJID,
+				"fromMe":    key.FromMe,
+				"id":        key.ID,
+			},
+		},
+	}
+
+	return e.postJSON(ctx, fmt.Sprintf("%s/chat/markMessageAsRead/%s", e.baseURL, e.instance), payload, nil)
+}
+
+// EditMessage replaces the text of a message previously sent by the instance.
+func (e *EvolutionClient) EditMessage(ctx context.Context, to string, key model.WebhookKey, text string) (*model.SendMessageResponse, error) {
+	payload := map[string]any{
+		"number": to,
+		"key": map[string]any{
+			"remoteJid": key.RemoteJID,
+			"fromMe":    true,
+			"id":        key.ID,
+		},
+		"text": text,
+	}
+
+	var edited model.SendMessageResponse
+	if err := e.postJSON(ctx, fmt.Sprintf("%s/chat/updateMessage/%s", e.baseURL, e.instance), payload, &edited); err != nil {
+		return nil, err
+	}
+	return &edited, nil
+}
+
+// DeleteMessage deletes a message previously sent by the instance for
+// everyone in the chat.
+func (e *EvolutionClient) DeleteMessage(ctx context.Context, key model.WebhookKey) error {
+	payload := map[string]any{
+		"id":        key.ID,
+		"remoteJid": key.RemoteJID,
+		"fromMe":    true,
+	}
+
+	return e.doJSON(ctx, http.MethodDelete, fmt.Sprintf("%s/chat/deleteMessageForEveryone/%s", e.baseURL, e.instance), payload, nil)
+}
+
+// SendPresence shows presence, e.g. "composing", in the chat with to for
+// delay. Evolution holds the request open while the presence is shown.
+func (e *EvolutionClient) SendPresence(ctx context.Context, to, presence string, delay time.Duration) error {
+	payload := map[string]any{
+		"number":   to,
+		"presence": presence,
+		"delay":    delay.Milliseconds(),
+	}
+
+	return e.postJSON(ctx, fmt.Sprintf("%s/chat/sendPresence/%s", e.baseURL, e.instance), payload, nil)
+}
+
+// DownloadMedia writes the decrypted media attached to a received message to
+// w and returns its mimetype. Media larger than maxBytes fails with
+// errMediaTooLarge. When Evolution keeps media in object storage, mediaURL
+// points at the file and it is streamed from there; otherwise Evolution sends
+// it base64-encoded, capped at the encoded size of maxBytes.
+func (e *EvolutionClient) DownloadMedia(ctx context.Context, key model.WebhookKey, mediaURL string, maxBytes int64, w io.Writer) (string, error) {
+	if mediaURL != "" {
+		return e.streamMedia(ctx, mediaURL, maxBytes, w)
+	}
+
+	payload := map[string]any{
+		"message": map[string]any{
+			"key": map[string]any{"id": key.ID},
+		},
+		"convertToMp4": false,
+	}
+
+	var media struct {
+		Base64   string `json:"base64"`
+		Mimetype string `json:"mimetype"`
+	}
+	endpoint := fmt.Sprintf("%s/chat/getBase64FromMediaMessage/%s", e.baseURL, e.instance)
+	limit := int64(base64.StdEncoding.EncodedLen(int(maxBytes))) + maxEvolutionResponseBytes
+	if err := e.doJSONLimit(ctx, http.MethodPost, endpoint, payload, &media, limit); err != nil {
+		var tooLarge *responseTooLargeError
+		if errors.As(err, &tooLarge) {
+			return "", errMediaTooLarge
+		}
+		return "", err
+	}
+	if media.Base64 == "" {
+		return "", errors.New("evolution API returned no media content")
+	}
+
+	if err := copyCapped(w, base64.NewDecoder(base64.StdEncoding, strings.NewReader(media.Base64)), maxBytes); err != nil {
+		if errors.Is(err, errMediaTooLarge) {
+			return "", err
+		}
+		return "", fmt.Errorf("decode media content: %w", err)
+	}
+	return media.Mimetype, nil
+}
+
+func (e *EvolutionClient) streamMedia(ctx context.Context, mediaURL string, maxBytes int64, w io.Writer) (string, error) {
+	if err := e.checkMediaURL(mediaURL); err != nil {
+		return "", err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, mediaDownloadTimeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, mediaURL, nil)
+	if err != nil {
+		return "", err
+	}
+
+	resp, err := e.mediaClient.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		return "", fmt.Errorf("media download error: %s", resp.Status)
+	}
+	if resp.ContentLength > maxBytes {
+		return "", errMediaTooLarge
+	}
+
+	if err := copyCapped(w, resp.Body, maxBytes); err != nil {
+		return "", err
+	}
+	return resp.Header.Get("Content-Type"), nil
+}
+
+// checkMediaURL rejects media URLs outside mediaHosts, so a forged webhook
+// cannot make the server fetch arbitrary addresses.
+func (e *EvolutionClient) checkMediaURL(mediaURL string) error {
+	parsed, err := url.Parse(mediaURL)
+	if err != nil {
+		return fmt.Errorf("invalid media URL: %w", err)
+	}
+	if parsed.Scheme != "http" && parsed.Scheme != "https" {
+		return fmt.Errorf("media URL scheme %q is not allowed", parsed.Scheme)
+	}
+
+	host := strings.ToLower(parsed.Host)
+	for _, allowed := range e.mediaHosts {
+		if host == allowed || strings.ToLower(parsed.Hostname()) == allowed {
+			return nil
+		}
+	}
+	return fmt.Errorf("media host %s is not allowed", parsed.Host)
 }
 
-func (e *EvolutionClient) postJSON(ctx context.Context, url string, body any) error {
-	buf := new(bytes.Buffer)
-	if err := json.NewEncoder(buf).Encode(body); err != nil {
+// checkMediaRedirect applies the media host allowlist to every redirect, so an
+// allowed host cannot send the download somewhere else.
+func (e *EvolutionClient) checkMediaRedirect(req *http.Request, via []*http.Request) error {
+	if len(via) >= 10 {
+		return errors.New("stopped after 10 redirects")
+	}
+	return e.checkMediaURL(req.URL.String())
+}
+
+// copyCapped copies src to dst, failing with errMediaTooLarge as soon as more
+// than maxBytes have been read.
+func copyCapped(dst io.Writer, src io.Reader, maxBytes int64) error {
+	n, err := io.Copy(dst, io.LimitReader(src, maxBytes+1))
+	if err != nil {
 		return err
 	}
+	if n > maxBytes {
+		return errMediaTooLarge
+	}
+	return nil
+}
+
+// ConnectionState returns the WhatsApp connection state of the instance,
+// e.g. "open", "connecting" or "close".
+func (e *EvolutionClient) ConnectionState(ctx context.Context) (string, error) {
+	var state struct {
+		Instance struct {
+			State string `json:"state"`
+		} `json:"instance"`
+	}
+
+	if err := e.doJSON(ctx, http.MethodGet, fmt.Sprintf("%s/instance/connectionState/%s", e.baseURL, e.instance), nil, &state); err != nil {
+		return "", err
+	}
+	return state.Instance.State, nil
+}
+
+// Connect asks Evolution to re-establish the instance's WhatsApp session.
+func (e *EvolutionClient) Connect(ctx context.Context) error {
+	return e.doJSON(ctx, http.MethodGet, fmt.Sprintf("%s/instance/connect/%s", e.baseURL, e.instance), nil, nil)
+}
+
+func (e *EvolutionClient) postJSON(ctx context.Context, url string, body any, out any) error {
+	return e.doJSON(ctx, http.MethodPost, url, body, out)
+}
+
+// doJSON sends body (if any) to url, retrying transient failures, and on
+// success decodes the response into out when out is non-nil. A response that
+// cannot be decoded is logged and left zero-valued, since the request itself
+// succeeded.
+func (e *EvolutionClient) doJSON(ctx context.Context, method, url string, body any, out any) error {
+	return e.doJSONLimit(ctx, method, url, body, out, maxEvolutionResponseBytes)
+}
+
+// doJSONLimit is doJSON reading at most limit bytes of the response body.
+func (e *EvolutionClient) doJSONLimit(ctx context.Context, method, url string, body any, out any, limit int64) error {
+	var payload []byte
+	if body != nil {
+		var err error
+		if payload, err = json.Marshal(body); err != nil {
+			return err
+		}
+	}
+
+	delays := newBackoff(e.retryConfig)
+	for attempt := 1; ; attempt++ {
+		err := e.doJSONOnce(ctx, method, url, payload, out, limit)
+		if err == nil || attempt > e.maxRetries || !isRetryableEvolutionError(err) || ctx.Err() != nil {
+			return err
+		}
+
+		delay := delays.next()
+		log.Printf("Evolution API request failed, retrying in %s (retry %d of %d): %v", delay, attempt, e.maxRetries, err)
+		if err := sleepContext(ctx, delay); err != nil {
+			return err
+		}
+	}
+}
+
+func (e *EvolutionClient) doJSONOnce(ctx context.Context, method, url string, payload []byte, out any, limit int64) error {
+	var reqBody io.Reader
+	if payload != nil {
+		reqBody = bytes.NewReader(payload)
+	}
 
-	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, buf)
+	req, err := http.NewRequestWithContext(ctx, method, url, reqBody)
 	if err != nil {
 		return err
 	}
@@ -53,18 +360,159 @@ func (e *EvolutionClient) postJSON(ctx context.Context, url string, body any) er
 	req.Header.Set("Content-Type", "application/json")
 	req.Header.Set("apikey", e.apiKey)
 
+	if err := e.waitCooldown(ctx); err != nil {
+		return err
+	}
+
 	resp, err := e.httpClient.Do(req)
 	if err != nil {
 		return err
 	}
 	defer resp.Body.Close()
 
-	responseBody, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
-	log.Printf("Evolution API response: status=%d body=%s", resp.StatusCode, strings.TrimSpace(string(responseBody)))
+	responseBody, _ := io.ReadAll(io.LimitReader(resp.Body, limit+1))
+	log.Printf("Evolution API response: status=%d body=%s", resp.StatusCode, truncateForLog(responseBody, 512))
+	if int64(len(responseBody)) > limit {
+		return &responseTooLargeError{limit: limit}
+	}
+
+	if resp.StatusCode == http.StatusTooManyRequests {
+		e.startCooldown(parseRetryAfter(resp.Header.Get("Retry-After"), e.rateLimitCooldown))
+	}
 
 	if resp.StatusCode >= 300 {
-		return fmt.Errorf("evolution API error: %s - %s", resp.Status, strings.TrimSpace(string(responseBody)))
+		return &APIError{
+			StatusCode: resp.StatusCode,
+			Status:     resp.Status,
+			Body:       truncateForLog(responseBody, 512),
+		}
+	}
+
+	if out != nil && len(bytes.TrimSpace(responseBody)) > 0 {
+		if err := json.Unmarshal(responseBody, out); err != nil {
+			log.Printf("Evolution API response decode error: %v", err)
+		}
 	}
 
 	return nil
 }
+
+func truncateForLog(body []byte, limit int) string {
+	if len(body) > limit {
+		body = body[:limit]
+	}
+	return strings.TrimSpace(string(body))
+}
+
+// waitCooldown blocks until any rate-limit cooldown started by a previous
+// 429 response has elapsed.
+func (e *EvolutionClient) waitCooldown(ctx context.Context) error {
+	e.mu.Lock()
+	wait := time.Until(e.cooldownUntil)
+	e.mu.Unlock()
+
+	if wait <= 0 {
+		return nil
+	}
+
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+func (e *EvolutionClient) startCooldown(wait time.Duration) {
+	until := time.Now().Add(wait)
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if until.After(e.cooldownUntil) {
+		e.cooldownUntil = until
+		log.Printf("Evolution API rate limited, pausing requests for %s", wait)
+	}
+}
+
+// parseRetryAfter reads a Retry-After header given either in seconds or as an
+// HTTP date, falling back to def when it is missing or malformed.
+func parseRetryAfter(value string, def time.Duration) time.Duration {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return def
+	}
+
+	if seconds, err := strconv.Atoi(value); err == nil && seconds >= 0 {
+		return time.Duration(seconds) * time.Second
+	}
+
+	if at, err := http.ParseTime(value); err == nil {
+		if wait := time.Until(at); wait > 0 {
+			return wait
+		}
+		return 0
+	}
+
+	return def
+}
+
+// responseTooLargeError is returned when an Evolution response body exceeds
+// the limit the request was made with.
+type responseTooLargeError struct {
+	limit int64
+}
+
+func (e *responseTooLargeError) Error() string {
+	return fmt.Sprintf("evolution API response exceeds %d bytes", e.limit)
+}
+
+// APIError is returned when Evolution answers with a non-success status.
+type APIError struct {
+	StatusCode int
+	Status     string
+	Body       string
+}
+
+func (e *APIError) Error() string {
+	return fmt.Sprintf("evolution API error: %s - %s", e.Status, e.Body)
+}
+
+// isDisconnectedError reports whether err means the WhatsApp session behind
+// the instance is not connected, so the message may succeed later.
+func isDisconnectedError(err error) bool {
+	var apiErr *APIError
+	if !errors.As(err, &apiErr) {
+		return false
+	}
+
+	body := strings.ToLower(apiErr.Body)
+	for _, marker := range []string{"connection closed", "not connected", "disconnected"} {
+		if strings.Contains(body, marker) {
+			return true
+		}
+	}
+	return false
+}
+
+// isRetryableEvolutionError reports whether err is a network failure, a 429
+// or a server error from Evolution.
+func isRetryableEvolutionError(err error) bool {
+	var apiErr *APIError
+	if errors.As(err, &apiErr) {
+		return apiErr.StatusCode == http.StatusTooManyRequests || apiErr.StatusCode >= 500
+	}
+
+	var netErr net.Error
+	return errors.As(err, &netErr)
+}
+
+// isRateLimitedError reports whether err is a 429 from Evolution, as opposed
+// to a rate limit reported by OpenAI.
+func isRateLimitedError(err error) bool {
+	var apiErr *APIError
+	return errors.As(err, &apiErr) && apiErr.StatusCode == http.StatusTooManyRequests
+}
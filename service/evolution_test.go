@@ -0,0 +1,240 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"hackathon/model"
+)
+
+func TestEvolutionRateLimitDelaysNextSend(t *testing.T) {
+	for _, tc := range []struct {
+		name       string
+		retryAfter string
+		cooldown   time.Duration
+		wantDelay  time.Duration
+	}{
+		{"Retry-After header", "1", time.Hour, time.Second},
+		{"default cooldown", "", 200 * time.Millisecond, 200 * time.Millisecond},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			evo := newFakeEvolution(t)
+			var calls atomic.Int32
+			evo.Handle("/message/sendText", func(w http.ResponseWriter, r *http.Request) {
+				if calls.Add(1) == 1 {
+					if tc.retryAfter != "" {
+						w.Header().Set("Retry-After", tc.retryAfter)
+					}
+					http.Error(w, `{"message":"too many requests"}`, http.StatusTooManyRequests)
+					return
+				}
+				w.Write([]byte(`{"key":{"id":"sent"}}`))
+			})
+
+			cfg := testConfig(evo.URL)
+			cfg.EvolutionRateLimitCooldown = tc.cooldown
+			client := NewEvolutionClient(cfg)
+
+			ctx := context.Background()
+			if _, err := client.SendTextMessage(ctx, "5511999999999", "one"); !isRateLimitedError(err) {
+				t.Fatalf("first send error = %v, want a rate limit", err)
+			}
+
+			start := time.Now()
+			if _, err := client.SendTextMessage(ctx, "5511999999999", "two"); err != nil {
+				t.Fatal(err)
+			}
+			if elapsed := time.Since(start); elapsed < tc.wantDelay-50*time.Millisecond {
+				t.Errorf("second send went out after %s, want it held for about %s", elapsed, tc.wantDelay)
+			}
+		})
+	}
+}
+
+func TestEvolutionCooldownRespectsContext(t *testing.T) {
+	cfg := testConfig("http://evolution.test")
+	client := NewEvolutionClient(cfg)
+	client.startCooldown(time.Hour)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if _, err := client.SendTextMessage(ctx, "5511999999999", "hi"); err != context.DeadlineExceeded {
+		t.Errorf("send during cooldown returned %v, want the context deadline", err)
+	}
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	def := 5 * time.Second
+	for _, tc := range []struct {
+		value string
+		want  time.Duration
+	}{
+		{"", def},
+		{"3", 3 * time.Second},
+		{"0", 0},
+		{"-1", def},
+		{"soon", def},
+		{time.Now().Add(-time.Minute).UTC().Format(http.TimeFormat), 0},
+	} {
+		if got := parseRetryAfter(tc.value, def); got != tc.want {
+			t.Errorf("parseRetryAfter(%q) = %s, want %s", tc.value, got, tc.want)
+		}
+	}
+
+	future := time.Now().Add(time.Minute).UTC().Format(http.TimeFormat)
+	if got := parseRetryAfter(future, def); got < 58*time.Second || got > time.Minute {
+		t.Errorf("parseRetryAfter(%q) = %s, want about a minute", future, got)
+	}
+}
+
+func TestSendTextMessageParsesResponse(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		response string
+		wantID   string
+		status   string
+	}{
+		{
+			"representative",
+			`{"key":{"remoteJid":"5511999999999@s.whatsapp.net","fromMe":true,"id":"BAE5F4C1D2E3"},"message":{"extendedTextMessage":{"text":"hi"}},"messageTimestamp":"1717400000","status":"PENDING","messageType":"extendedTextMessage"}`,
+			"BAE5F4C1D2E3",
+			"PENDING",
+		},
+		{"no key", `{"status":"PENDING"}`, "", "PENDING"},
+		{"empty body", ``, "", ""},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			evo := newFakeEvolution(t)
+			evo.Handle("/message/sendText", func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusCreated)
+				w.Write([]byte(tc.response))
+			})
+			bot := newTestBot(testConfig(evo.URL), evo, nil)
+
+			sent, err := bot.Evolution.SendTextMessage(context.Background(), "5511999999999", "hi")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if sent.Key.ID != tc.wantID || sent.Status != tc.status {
+				t.Errorf("parsed id=%q status=%q, want id=%q status=%q", sent.Key.ID, sent.Status, tc.wantID, tc.status)
+			}
+
+			if err := bot.sendText(context.Background(), "5511999999999", "hi"); err != nil {
+				t.Fatal(err)
+			}
+			if got := bot.sent.containsID(tc.wantID, time.Now()); got != (tc.wantID != "") {
+				t.Errorf("sent ID tracked = %v", got)
+			}
+		})
+	}
+}
+
+func TestDownloadMediaStream(t *testing.T) {
+	audio := bytes.Repeat([]byte("a"), 1000)
+	storage := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "audio/ogg")
+		if r.URL.Query().Has("chunked") {
+			// No Content-Length, so only the copy can catch the size.
+			w.(http.Flusher).Flush()
+		}
+		w.Write(audio)
+	}))
+	defer storage.Close()
+
+	cfg := testConfig("http://evolution.test")
+	cfg.MediaAllowedHosts = []string{"127.0.0.1"}
+	client := NewEvolutionClient(cfg)
+
+	var buf bytes.Buffer
+	mimetype, err := client.DownloadMedia(context.Background(), model.WebhookKey{ID: "in-1"}, storage.URL+"/voice.ogg", 1000, &buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if mimetype != "audio/ogg" || !bytes.Equal(buf.Bytes(), audio) {
+		t.Errorf("downloaded %d bytes of %s, want the 1000 byte file", buf.Len(), mimetype)
+	}
+
+	for _, target := range []string{"/voice.ogg", "/voice.ogg?chunked=1"} {
+		_, err := client.DownloadMedia(context.Background(), model.WebhookKey{ID: "in-1"}, storage.URL+target, 999, io.Discard)
+		if !errors.Is(err, errMediaTooLarge) {
+			t.Errorf("%s over the cap: error %v, want errMediaTooLarge", target, err)
+		}
+	}
+}
+
+func TestDownloadMediaRejectsUnknownHosts(t *testing.T) {
+	var fetched atomic.Bool
+	storage := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fetched.Store(true)
+	}))
+	defer storage.Close()
+
+	// The Evolution host itself is allowed without configuration.
+	evo := newFakeEvolution(t)
+	client := NewEvolutionClient(testConfig(evo.URL))
+	if _, err := client.DownloadMedia(context.Background(), model.WebhookKey{}, evo.URL+"/media/voice.ogg", 1000, io.Discard); err != nil {
+		t.Errorf("media on the Evolution host: %v", err)
+	}
+
+	for _, mediaURL := range []string{
+		"http://localhost:" + strings.TrimPrefix(storage.URL, "http://127.0.0.1:") + "/voice.ogg",
+		"http://169.254.169.254/latest/meta-data",
+		"file:///etc/passwd",
+	} {
+		if _, err := client.DownloadMedia(context.Background(), model.WebhookKey{}, mediaURL, 1000, io.Discard); err == nil {
+			t.Errorf("%s: download allowed", mediaURL)
+		}
+	}
+	if fetched.Load() {
+		t.Error("a disallowed host was contacted")
+	}
+}
+
+func TestDownloadMediaRejectsRedirectToUnknownHost(t *testing.T) {
+	var fetched atomic.Bool
+	internal := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fetched.Store(true)
+		w.Write([]byte("secret"))
+	}))
+	defer internal.Close()
+	// Reached as localhost, which is not on the allowlist.
+	internalURL := "http://localhost:" + strings.TrimPrefix(internal.URL, "http://127.0.0.1:")
+
+	storage := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/moved.ogg" {
+			http.Redirect(w, r, "/voice.ogg", http.StatusFound)
+			return
+		}
+		if r.URL.Path == "/voice.ogg" {
+			w.Write([]byte("audio"))
+			return
+		}
+		http.Redirect(w, r, internalURL+"/latest/meta-data", http.StatusFound)
+	}))
+	defer storage.Close()
+
+	cfg := testConfig("http://evolution.test")
+	cfg.MediaAllowedHosts = []string{"127.0.0.1"}
+	client := NewEvolutionClient(cfg)
+
+	// Redirects within allowed hosts are still followed.
+	var buf bytes.Buffer
+	if _, err := client.DownloadMedia(context.Background(), model.WebhookKey{}, storage.URL+"/moved.ogg", 1000, &buf); err != nil || buf.String() != "audio" {
+		t.Errorf("redirect to an allowed host: %q, %v", buf.String(), err)
+	}
+
+	if _, err := client.DownloadMedia(context.Background(), model.WebhookKey{}, storage.URL+"/escape.ogg", 1000, io.Discard); err == nil {
+		t.Error("download followed a redirect to a host that is not allowed")
+	}
+	if fetched.Load() {
+		t.Error("the host that is not allowed was contacted")
+	}
+}
@@ -0,0 +1,161 @@
+package service
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+
+	openai "github.com/sashabaranov/go-openai"
+)
+
+const (
+	defaultExportPageSize = 100
+	maxExportPageSize     = 1000
+)
+
+type exportedConversation struct {
+	User     string                         `json:"user"`
+	Messages []openai.ChatCompletionMessage `json:"messages"`
+}
+
+type exportPage struct {
+	Conversations []exportedConversation `json:"conversations"`
+	NextCursor    string                 `json:"next_cursor"`
+}
+
+// ExportHandler serves GET /admin/export?cursor=&limit=, returning one page of
+// stored conversations. Clients follow next_cursor until it comes back empty.
+func ExportHandler(bot *Bot) http.HandlerFunc {
+	return requireAdmin(bot.Configs, func(w http.ResponseWriter, r *http.Request) {
+		if bot.Store == nil {
+			http.Error(w, "no conversation store configured", http.StatusNotFound)
+			return
+		}
+
+		limit := defaultExportPageSize
+		if raw := r.URL.Query().Get("limit"); raw != "" {
+			parsed, err := strconv.Atoi(raw)
+			if err != nil || parsed <= 0 || parsed > maxExportPageSize {
+				http.Error(w, fmt.Sprintf("limit must be between 1 and %d", maxExportPageSize), http.StatusBadRequest)
+				return
+			}
+			limit = parsed
+		}
+
+		ctx := r.Context()
+		users, next, err := bot.Store.ListConversations(ctx, r.URL.Query().Get("cursor"), limit)
+		if err != nil {
+			log.Printf("conversation export failed: %v", err)
+			http.Error(w, "failed to list conversations", http.StatusInternalServerError)
+			return
+		}
+
+		page := exportPage{Conversations: make([]exportedConversation, 0, len(users)), NextCursor: next}
+		for _, user := range users {
+			// Peeking keeps the export from reordering MAX_CONVERSATIONS
+			// eviction.
+			messages, err := bot.Store.PeekConversation(ctx, user)
+			if err != nil {
+				log.Printf("conversation export failed for %s: %v", user, err)
+				http.Error(w, "failed to read conversation", http.StatusInternalServerError)
+				return
+			}
+			// The conversation may have expired since it was listed.
+			if len(messages) == 0 {
+				continue
+			}
+			page.Conversations = append(page.Conversations, exportedConversation{User: user, Messages: messages})
+		}
+
+		writeJSON(w, http.StatusOK, page)
+	})
+}
+
+// ImportHandler serves POST /admin/import, restoring conversations from a body
+// in the export format. Entries are decoded and saved one at a time, so pages
+// can be concatenated into a single large import. Existing conversations for
+// the same users are overwritten.
+func ImportHandler(bot *Bot) http.HandlerFunc {
+	return requireAdmin(bot.Configs, func(w http.ResponseWriter, r *http.Request) {
+		if bot.Store == nil {
+			http.Error(w, "no conversation store configured", http.StatusNotFound)
+			return
+		}
+
+		ctx := r.Context()
+		imported := 0
+		err := decodeConversations(json.NewDecoder(r.Body), func(conversation exportedConversation) error {
+			if conversation.User == "" {
+				return errors.New("conversation without user")
+			}
+			if err := bot.Store.SaveConversation(ctx, conversation.User, conversation.Messages); err != nil {
+				return fmt.Errorf("save conversation for %s: %w", conversation.User, err)
+			}
+			imported++
+			return nil
+		})
+		if err != nil {
+			log.Printf("conversation import stopped after %d conversations: %v", imported, err)
+			writeJSON(w, http.StatusBadRequest, map[string]any{"error": err.Error(), "imported": imported})
+			return
+		}
+
+		log.Printf("imported %d conversations (remote=%s)", imported, r.RemoteAddr)
+		writeJSON(w, http.StatusOK, map[string]int{"imported": imported})
+	})
+}
+
+// decodeConversations streams the "conversations" array of an export page,
+// calling fn for each entry. Other fields are skipped.
+func decodeConversations(dec *json.Decoder, fn func(exportedConversation) error) error {
+	if err := expectDelim(dec, '{'); err != nil {
+		return err
+	}
+
+	for dec.More() {
+		token, err := dec.Token()
+		if err != nil {
+			return err
+		}
+
+		if token != "conversations" {
+			var skipped json.RawMessage
+			if err := dec.Decode(&skipped); err != nil {
+				return err
+			}
+			continue
+		}
+
+		if err := expectDelim(dec, '['); err != nil {
+			return err
+		}
+		for dec.More() {
+			var conversation exportedConversation
+			if err := dec.Decode(&conversation); err != nil {
+				return err
+			}
+			if err := fn(conversation); err != nil {
+				return err
+			}
+		}
+		if err := expectDelim(dec, ']'); err != nil {
+			return err
+		}
+	}
+
+	return expectDelim(dec, '}')
+}
+
+func expectDelim(dec *json.Decoder, want json.Delim) error {
+	token, err := dec.Token()
+	if err != nil {
+		return err
+	}
+	if token != want {
+		return fmt.Errorf("invalid import body: expected %q, got %v", want, token)
+	}
+	return nil
+}
@@ -0,0 +1,101 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"slices"
+	"testing"
+
+	openai "github.com/sashabaranov/go-openai"
+)
+
+func TestExportImportRoundTrip(t *testing.T) {
+	ctx := context.Background()
+	cfg := testConfig("")
+	cfg.MaxConversations = 100
+
+	_, sourceClient := newTestRedis(t)
+	source := &Bot{Configs: NewConfigHolder(cfg), Store: NewRedisConversationStore(sourceClient, cfg)}
+	want := make(map[string][]openai.ChatCompletionMessage)
+	for i := range 7 {
+		user := fmt.Sprintf("55119000000%02d", i)
+		want[user] = []openai.ChatCompletionMessage{
+			{Role: openai.ChatMessageRoleUser, Content: "question " + user},
+			{Role: openai.ChatMessageRoleAssistant, Content: "answer " + user},
+		}
+		if err := source.Store.SaveConversation(ctx, user, want[user]); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	usage := sourceClient.ZRangeWithScores(ctx, conversationIndexKey, 0, -1).Val()
+
+	_, targetClient := newTestRedis(t)
+	target := &Bot{Configs: NewConfigHolder(cfg), Store: NewRedisConversationStore(targetClient, cfg)}
+
+	cursor, pages := "", 0
+	for {
+		rec := adminRequest(ExportHandler(source), http.MethodGet, "/admin/export?limit=3&cursor="+url.QueryEscape(cursor), "admin-key", "")
+		if rec.Code != http.StatusOK {
+			t.Fatalf("export: status %d, body %s", rec.Code, rec.Body)
+		}
+		var page exportPage
+		if err := json.Unmarshal(rec.Body.Bytes(), &page); err != nil {
+			t.Fatal(err)
+		}
+
+		rec = adminRequest(ImportHandler(target), http.MethodPost, "/admin/import", "admin-key", rec.Body.String())
+		if rec.Code != http.StatusOK {
+			t.Fatalf("import: status %d, body %s", rec.Code, rec.Body)
+		}
+
+		pages++
+		if cursor = page.NextCursor; cursor == "" {
+			break
+		}
+		if pages > 20 {
+			t.Fatal("export never returned an empty cursor")
+		}
+	}
+
+	// Exporting is not use: the eviction order is left as it was.
+	if after := sourceClient.ZRangeWithScores(ctx, conversationIndexKey, 0, -1).Val(); !slices.Equal(after, usage) {
+		t.Errorf("export changed the LRU index from %v to %v", usage, after)
+	}
+
+	for user, messages := range want {
+		got, err := target.Store.GetConversation(ctx, user)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !slices.EqualFunc(got, messages, func(a, b openai.ChatCompletionMessage) bool {
+			return a.Role == b.Role && a.Content == b.Content
+		}) {
+			t.Errorf("%s: imported %+v, want %+v", user, got, messages)
+		}
+	}
+	if keys := targetClient.Keys(ctx, "conversation:*").Val(); len(keys) != len(want) {
+		t.Errorf("target holds %d conversations, want %d", len(keys), len(want))
+	}
+}
+
+func TestImportRejectsMalformedBody(t *testing.T) {
+	_, client := newTestRedis(t)
+	cfg := testConfig("")
+	bot := &Bot{Configs: NewConfigHolder(cfg), Store: NewRedisConversationStore(client, cfg)}
+
+	body := `{"conversations":[{"user":"alice","messages":[{"role":"user","content":"hi"}]},{"messages":[]}]}`
+	rec := adminRequest(ImportHandler(bot), http.MethodPost, "/admin/import", "admin-key", body)
+	if rec.Code != http.StatusBadRequest {
+		t.Fatalf("status %d, want 400 for a conversation without user", rec.Code)
+	}
+	var result struct {
+		Imported int `json:"imported"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &result); err != nil || result.Imported != 1 {
+		t.Errorf("body %s, want one conversation imported before the error", rec.Body)
+	}
+}
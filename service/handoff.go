@@ -0,0 +1,153 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	openai "github.com/sashabaranov/go-openai"
+
+	"hackathon/model"
+)
+
+const handoffContextMessages = 10
+
+type handoffNotification struct {
+	User    string                         `json:"user"`
+	Reason  string                         `json:"reason"`
+	Message string                         `json:"message"`
+	Context []openai.ChatCompletionMessage `json:"context"`
+}
+
+// HandoffNotifier tells an external system that a human should take over a
+// conversation and keeps the bot quiet for that user in the meantime. When
+// backed by Redis the pauses survive restarts and are shared by every replica;
+// the local record is used whenever Redis cannot be reached.
+type HandoffNotifier struct {
+	url        string
+	httpClient *http.Client
+	client     *redis.Client
+
+	mu     sync.Mutex
+	paused map[string]time.Time
+}
+
+func NewHandoffNotifier(cfg *model.Config, client *redis.Client) *HandoffNotifier {
+	return &HandoffNotifier{
+		url:        cfg.HandoffWebhookURL,
+		httpClient: &http.Client{Timeout: 10 * time.Second},
+		client:     client,
+		paused:     make(map[string]time.Time),
+	}
+}
+
+// Paused reports whether the bot is paused for user at now.
+func (h *HandoffNotifier) Paused(ctx context.Context, user string, now time.Time) bool {
+	if h == nil {
+		return false
+	}
+
+	if h.client != nil {
+		n, err := h.client.Exists(ctx, h.key(user)).Result()
+		if err == nil {
+			return n > 0
+		}
+		log.Printf("handoff: redis read failed for %s, using local state: %v", user, err)
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	until, ok := h.paused[user]
+	if !ok {
+		return false
+	}
+	if !now.Before(until) {
+		delete(h.paused, user)
+		return false
+	}
+	return true
+}
+
+// Trigger posts the handoff notification and, once delivered, pauses the bot
+// for user for the given duration.
+func (h *HandoffNotifier) Trigger(ctx context.Context, user, reason, message string, history []openai.ChatCompletionMessage, pause time.Duration) error {
+	if h == nil {
+		return nil
+	}
+
+	if len(history) > handoffContextMessages {
+		history = history[len(history)-handoffContextMessages:]
+	}
+
+	buf := new(bytes.Buffer)
+	if err := json.NewEncoder(buf).Encode(handoffNotification{
+		User:    user,
+		Reason:  reason,
+		Message: message,
+		Context: history,
+	}); err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, h.url, buf)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := h.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		responseBody, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("handoff webhook error: %s - %s", resp.Status, strings.TrimSpace(string(responseBody)))
+	}
+
+	h.mu.Lock()
+	h.paused[user] = time.Now().Add(pause)
+	h.mu.Unlock()
+
+	if h.client != nil {
+		if err := h.client.Set(ctx, h.key(user), "1", pause).Err(); err != nil {
+			log.Printf("handoff: redis write failed for %s, pause is local to this replica: %v", user, err)
+		}
+	}
+
+	return nil
+}
+
+func (h *HandoffNotifier) key(user string) string {
+	return fmt.Sprintf("handoff-pause:%s", user)
+}
+
+// wantsHuman reports whether text contains one of the configured handoff keywords.
+func wantsHuman(cfg *model.Config, text string) bool {
+	lowered := strings.ToLower(text)
+	for _, keyword := range cfg.HandoffKeywords {
+		if strings.Contains(lowered, keyword) {
+			return true
+		}
+	}
+	return false
+}
+
+// stripHandoffMarker removes the model's handoff marker from reply and
+// reports whether it was present.
+func stripHandoffMarker(cfg *model.Config, reply string) (string, bool) {
+	if cfg.HandoffMarker == "" || !strings.Contains(reply, cfg.HandoffMarker) {
+		return reply, false
+	}
+	return strings.TrimSpace(strings.ReplaceAll(reply, cfg.HandoffMarker, "")), true
+}
@@ -0,0 +1,144 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	openai "github.com/sashabaranov/go-openai"
+)
+
+// recordHandoffs starts a handoff webhook that records every notification.
+func recordHandoffs(t *testing.T) (*httptest.Server, chan handoffNotification) {
+	t.Helper()
+
+	received := make(chan handoffNotification, 10)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var notification handoffNotification
+		if r.Method != http.MethodPost || r.Header.Get("Content-Type") != "application/json" {
+			http.Error(w, "bad request", http.StatusBadRequest)
+			return
+		}
+		if err := json.NewDecoder(r.Body).Decode(&notification); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		received <- notification
+	}))
+	t.Cleanup(server.Close)
+	return server, received
+}
+
+func TestHandoffTriggerPostsNotification(t *testing.T) {
+	server, received := recordHandoffs(t)
+	cfg := testConfig("")
+	cfg.HandoffWebhookURL = server.URL
+	notifier := NewHandoffNotifier(cfg, nil)
+
+	var history []openai.ChatCompletionMessage
+	for i := 0; i < handoffContextMessages+4; i++ {
+		history = append(history, openai.ChatCompletionMessage{Role: openai.ChatMessageRoleUser, Content: string(rune('a' + i))})
+	}
+
+	if err := notifier.Trigger(context.Background(), "5511999999999", "keyword", "talk to a human", history, time.Hour); err != nil {
+		t.Fatal(err)
+	}
+
+	notification := <-received
+	if notification.User != "5511999999999" || notification.Reason != "keyword" || notification.Message != "talk to a human" {
+		t.Errorf("unexpected notification %+v", notification)
+	}
+	if len(notification.Context) != handoffContextMessages || notification.Context[0].Content != "e" {
+		t.Errorf("context holds %d messages starting with %q, want the last %d", len(notification.Context), notification.Context[0].Content, handoffContextMessages)
+	}
+
+	now := time.Now()
+	if !notifier.Paused(context.Background(), "5511999999999", now) {
+		t.Error("user not paused after handoff")
+	}
+	if notifier.Paused(context.Background(), "5511999999999", now.Add(2*time.Hour)) {
+		t.Error("user still paused after the pause duration")
+	}
+	if notifier.Paused(context.Background(), "5511888888888", now) {
+		t.Error("another user was paused")
+	}
+}
+
+func TestHandoffTriggerFailureDoesNotPause(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "down", http.StatusBadGateway)
+	}))
+	defer server.Close()
+
+	cfg := testConfig("")
+	cfg.HandoffWebhookURL = server.URL
+	notifier := NewHandoffNotifier(cfg, nil)
+
+	if err := notifier.Trigger(context.Background(), "user", "keyword", "help", nil, time.Hour); err == nil {
+		t.Fatal("Trigger succeeded although the webhook failed")
+	}
+	if notifier.Paused(context.Background(), "user", time.Now()) {
+		t.Error("user paused although the handoff was not delivered")
+	}
+}
+
+func TestHandoffPauseSharedThroughRedis(t *testing.T) {
+	server, _ := recordHandoffs(t)
+	redisServer, client := newTestRedis(t)
+	cfg := testConfig("")
+	cfg.HandoffWebhookURL = server.URL
+
+	ctx := context.Background()
+	if err := NewHandoffNotifier(cfg, client).Trigger(ctx, "user", "keyword", "help", nil, time.Hour); err != nil {
+		t.Fatal(err)
+	}
+
+	// A notifier on another replica, or after a restart, sees the pause.
+	replica := NewHandoffNotifier(cfg, client)
+	if !replica.Paused(ctx, "user", time.Now()) {
+		t.Fatal("pause not visible to another notifier")
+	}
+
+	redisServer.FastForward(time.Hour)
+	if replica.Paused(ctx, "user", time.Now()) {
+		t.Error("pause did not expire with its TTL")
+	}
+}
+
+func TestHandleMessageSkipsPausedConversation(t *testing.T) {
+	handoffServer, received := recordHandoffs(t)
+	evo, oa := newFakeEvolution(t), newFakeOpenAI(t)
+	cfg := testConfig(evo.URL)
+	cfg.HandoffWebhookURL = handoffServer.URL
+	cfg.HandoffKeywords = []string{"human"}
+	cfg.HandoffMessage = "A person will reply."
+	cfg.HandoffPauseDuration = time.Hour
+
+	_, client := newTestRedis(t)
+	bot := newTestBot(cfg, evo, oa)
+	bot.Handoff = NewHandoffNotifier(cfg, client)
+
+	ctx := context.Background()
+	msg, key := textMessage("in-1", "I want a human")
+	if err := bot.handleMessage(ctx, cfg, "", "", msg, key); err != nil {
+		t.Fatal(err)
+	}
+	if notification := <-received; notification.Reason != "keyword" {
+		t.Errorf("handoff reason = %q, want keyword", notification.Reason)
+	}
+
+	msg, key = textMessage("in-2", "hello?")
+	if err := bot.handleMessage(ctx, cfg, "", "", msg, key); err != nil {
+		t.Fatal(err)
+	}
+
+	if texts := evo.Texts(); len(texts) != 1 || texts[0] != cfg.HandoffMessage {
+		t.Errorf("sent %q, want only the handoff message", texts)
+	}
+	if len(oa.Requests()) != 0 {
+		t.Error("OpenAI was called for a paused conversation")
+	}
+}
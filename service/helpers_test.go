@@ -0,0 +1,328 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+	openai "github.com/sashabaranov/go-openai"
+
+	"hackathon/model"
+)
+
+// testConfig returns a configuration with every setting at the value
+// LoadConfig would give it by default, pointed at the given Evolution server.
+func testConfig(evolutionURL string) *model.Config {
+	return &model.Config{
+		EvolutionAPIURL:      evolutionURL,
+		EvolutionAPIKey:      "evolution-key",
+		EvolutionInstance:    "bot",
+		AllowedInstances:     []string{"bot"},
+		OpenAIAPIKey:         "openai-key",
+		AdminAPIKey:          "admin-key",
+		OpenAIVoice:          "alloy",
+		OpenAIModel:          "gpt-4o-mini",
+		OpenAIAllowedModels:  []string{"gpt-4o-mini"},
+		ReplyMode:            ReplyModeText,
+		StreamRecovery:       StreamRecoveryRetry,
+		MarkReadTiming:       MarkReadOff,
+		RetryJitter:          JitterNone,
+		RetryBaseDelay:       time.Millisecond,
+		RetryMaxDelay:        time.Millisecond,
+		ContentFilterMessage: "filtered",
+		TimeoutMessage:       "timed out",
+		HumanizeProfile:      HumanizeOff,
+		ConfidenceGate:       ConfidenceGateOff,
+		LowConfidenceAction:  LowConfidenceFallback,
+		LowConfidenceMessage: "not sure",
+		EphemeralPersistence: EphemeralPersistTTL,
+		LanguageDetection:    LanguageDetectOff,
+		TranscribeWhenBusy:   TranscribeBusyQueue,
+		MediaMaxBytes:        1 << 20,
+		UnauthorizedMessage:  "unauthorized",
+		QuotaLocation:        time.UTC,
+		PromptLocation:       time.UTC,
+	}
+}
+
+// evolutionRequest is one call received by fakeEvolution.
+type evolutionRequest struct {
+	Method string
+	Path   string
+	Body   map[string]any
+	At     time.Time
+}
+
+// fakeEvolution is an Evolution API stand-in that records every request and
+// answers sends with an incrementing message ID. Handle overrides the answer
+// for one endpoint.
+type fakeEvolution struct {
+	*httptest.Server
+
+	mu       sync.Mutex
+	requests []evolutionRequest
+	handlers map[string]http.HandlerFunc
+	sent     int
+}
+
+func newFakeEvolution(t *testing.T) *fakeEvolution {
+	t.Helper()
+
+	f := &fakeEvolution{handlers: make(map[string]http.HandlerFunc)}
+	f.Server = httptest.NewServer(http.HandlerFunc(f.serve))
+	t.Cleanup(f.Close)
+	return f
+}
+
+// Handle routes requests whose path starts with endpoint, e.g.
+// "/message/sendText", to h instead of the default answer.
+func (f *fakeEvolution) Handle(endpoint string, h http.HandlerFunc) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.handlers[endpoint] = h
+}
+
+func (f *fakeEvolution) serve(w http.ResponseWriter, r *http.Request) {
+	raw, _ := io.ReadAll(r.Body)
+	r.Body = io.NopCloser(bytes.NewReader(raw))
+	var body map[string]any
+	_ = json.Unmarshal(raw, &body)
+
+	f.mu.Lock()
+	f.requests = append(f.requests, evolutionRequest{Method: r.Method, Path: r.URL.Path, Body: body, At: time.Now()})
+	var handler http.HandlerFunc
+	for endpoint, h := range f.handlers {
+		if strings.HasPrefix(r.URL.Path, endpoint) {
+			handler = h
+		}
+	}
+	f.sent++
+	id := f.sent
+	f.mu.Unlock()
+
+	if handler != nil {
+		handler(w, r)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	fmt.Fprintf(w, `{"key":{"remoteJid":"chat@s.whatsapp.net","fromMe":true,"id":"sent-%d"},"status":"PENDING"}`, id)
+}
+
+// Requests returns the recorded requests whose path starts with endpoint, or
+// every request when endpoint is "".
+func (f *fakeEvolution) Requests(endpoint string) []evolutionRequest {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	var matched []evolutionRequest
+	for _, req := range f.requests {
+		if strings.HasPrefix(req.Path, endpoint) {
+			matched = append(matched, req)
+		}
+	}
+	return matched
+}
+
+// Texts returns the text of every sendText request in order.
+func (f *fakeEvolution) Texts() []string {
+	var texts []string
+	for _, req := range f.Requests("/message/sendText") {
+		text, _ := req.Body["text"].(string)
+		texts = append(texts, text)
+	}
+	return texts
+}
+
+// Paths returns the paths of every recorded request in order.
+func (f *fakeEvolution) Paths() []string {
+	var paths []string
+	for _, req := range f.Requests("") {
+		paths = append(paths, req.Path)
+	}
+	return paths
+}
+
+// fakeCompletion is one canned chat completion answer.
+type fakeCompletion struct {
+	Content      string
+	FinishReason openai.FinishReason
+	Status       int
+}
+
+// fakeOpenAI is an OpenAI API stand-in. Chat completions are answered from
+// the queue set with Reply, repeating the last answer once it runs out; other
+// endpoints can be overridden with Handle.
+type fakeOpenAI struct {
+	*httptest.Server
+
+	mu       sync.Mutex
+	requests []openai.ChatCompletionRequest
+	headers  []http.Header
+	replies  []fakeCompletion
+	handlers map[string]http.HandlerFunc
+}
+
+func newFakeOpenAI(t *testing.T) *fakeOpenAI {
+	t.Helper()
+
+	f := &fakeOpenAI{
+		replies:  []fakeCompletion{{Content: "Hello!", FinishReason: openai.FinishReasonStop}},
+		handlers: make(map[string]http.HandlerFunc),
+	}
+	f.Server = httptest.NewServer(http.HandlerFunc(f.serve))
+	t.Cleanup(f.Close)
+	return f
+}
+
+// Client returns an OpenAI client talking to the fake server.
+func (f *fakeOpenAI) Client() *openai.Client {
+	config := openai.DefaultConfig("openai-key")
+	config.BaseURL = f.URL + "/v1"
+	return openai.NewClientWithConfig(config)
+}
+
+// Reply queues the answers to the next chat completions.
+func (f *fakeOpenAI) Reply(replies ...fakeCompletion) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.replies = replies
+}
+
+func (f *fakeOpenAI) Handle(path string, h http.HandlerFunc) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.handlers[path] = h
+}
+
+// Requests returns the chat completion requests received so far.
+func (f *fakeOpenAI) Requests() []openai.ChatCompletionRequest {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]openai.ChatCompletionRequest(nil), f.requests...)
+}
+
+func (f *fakeOpenAI) serve(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	f.headers = append(f.headers, r.Header.Clone())
+	handler, ok := f.handlers[r.URL.Path]
+	f.mu.Unlock()
+	if ok {
+		handler(w, r)
+		return
+	}
+
+	if r.URL.Path != "/v1/chat/completions" {
+		http.NotFound(w, r)
+		return
+	}
+
+	var req openai.ChatCompletionRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	f.mu.Lock()
+	f.requests = append(f.requests, req)
+	reply := f.replies[0]
+	if len(f.replies) > 1 {
+		f.replies = f.replies[1:]
+	}
+	f.mu.Unlock()
+
+	if reply.Status != 0 {
+		w.WriteHeader(reply.Status)
+		fmt.Fprintf(w, `{"error":{"message":"fake error","type":"server_error"}}`)
+		return
+	}
+	if reply.FinishReason == "" {
+		reply.FinishReason = openai.FinishReasonStop
+	}
+
+	if req.Stream {
+		w.Header().Set("Content-Type", "text/event-stream")
+		writeStreamChunk(w, openai.ChatCompletionStreamResponse{
+			Choices: []openai.ChatCompletionStreamChoice{{Delta: openai.ChatCompletionStreamChoiceDelta{Content: reply.Content}}},
+		})
+		writeStreamChunk(w, openai.ChatCompletionStreamResponse{
+			Choices: []openai.ChatCompletionStreamChoice{{FinishReason: reply.FinishReason}},
+		})
+		fmt.Fprint(w, "data: [DONE]\n\n")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(openai.ChatCompletionResponse{
+		Choices: []openai.ChatCompletionChoice{{
+			Message:      openai.ChatCompletionMessage{Role: openai.ChatMessageRoleAssistant, Content: reply.Content},
+			FinishReason: reply.FinishReason,
+		}},
+	})
+}
+
+func writeStreamChunk(w io.Writer, chunk openai.ChatCompletionStreamResponse) {
+	data, _ := json.Marshal(chunk)
+	fmt.Fprintf(w, "data: %s\n\n", data)
+}
+
+// newTestRedis starts an in-memory Redis server for the test.
+func newTestRedis(t *testing.T) (*miniredis.Miniredis, *redis.Client) {
+	t.Helper()
+
+	server := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: server.Addr()})
+	t.Cleanup(func() { client.Close() })
+	return server, client
+}
+
+// newTestBot wires a bot to the fake servers with the default handlers.
+func newTestBot(cfg *model.Config, evo *fakeEvolution, oa *fakeOpenAI) *Bot {
+	cfg.EvolutionAPIURL = evo.URL
+
+	bot := &Bot{
+		Evolution: NewEvolutionClient(cfg),
+		Configs:   NewConfigHolder(cfg),
+		Handlers:  NewHandlerRegistry(),
+	}
+	if oa != nil {
+		bot.OpenAI = oa.Client()
+	}
+	RegisterDefaultHandlers(bot.Handlers)
+	return bot
+}
+
+// textMessage returns a direct text message from the user 5511999999999.
+func textMessage(id, text string) (model.WebhookMessage, model.WebhookKey) {
+	return model.WebhookMessage{Conversation: text}, model.WebhookKey{RemoteJID: "5511999999999@s.whatsapp.net", ID: id}
+}
+
+// fixedScore is a ConfidenceScorer that rates every reply the same.
+type fixedScore float64
+
+func (s fixedScore) Score(context.Context, *model.Config, string, string) (float64, error) {
+	return float64(s), nil
+}
+
+// slowCompletion answers chat completions with content after delay.
+func slowCompletion(delay time.Duration, content string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(delay)
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(openai.ChatCompletionResponse{
+			Choices: []openai.ChatCompletionChoice{{
+				Message:      openai.ChatCompletionMessage{Role: openai.ChatMessageRoleAssistant, Content: content},
+				FinishReason: openai.FinishReasonStop,
+			}},
+		})
+	}
+}
@@ -0,0 +1,165 @@
+package service
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const redactedValue = "[REDACTED]"
+
+// sensitiveFields are JSON keys whose values are hidden when stored payloads
+// are listed. Matching is case-insensitive.
+var sensitiveFields = map[string]bool{
+	"apikey":        true,
+	"token":         true,
+	"password":      true,
+	"secret":        true,
+	"authorization": true,
+}
+
+// webhookRecord is one stored webhook body. IDs increase with every body
+// received, so an ID keeps naming the same payload as older ones are evicted.
+type webhookRecord struct {
+	ID         uint64
+	ReceivedAt time.Time
+	Body       []byte
+}
+
+// WebhookHistory keeps the last raw webhook bodies in memory for debugging.
+type WebhookHistory struct {
+	mu      sync.Mutex
+	records []webhookRecord
+	next    int
+	full    bool
+	lastID  uint64
+}
+
+func NewWebhookHistory(size int) *WebhookHistory {
+	return &WebhookHistory{records: make([]webhookRecord, size)}
+}
+
+// Add stores a copy of body, overwriting the oldest entry once full.
+func (h *WebhookHistory) Add(body []byte, now time.Time) {
+	if h == nil || len(h.records) == 0 {
+		return
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.lastID++
+	h.records[h.next] = webhookRecord{ID: h.lastID, ReceivedAt: now, Body: append([]byte(nil), body...)}
+	h.next = (h.next + 1) % len(h.records)
+	if h.next == 0 {
+		h.full = true
+	}
+}
+
+// Entries returns the stored payloads, oldest first.
+func (h *WebhookHistory) Entries() []webhookRecord {
+	if h == nil {
+		return nil
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if !h.full {
+		return append([]webhookRecord(nil), h.records[:h.next]...)
+	}
+	return append(append([]webhookRecord(nil), h.records[h.next:]...), h.records[:h.next]...)
+}
+
+// Get returns the stored payload with the given ID, if it has not been
+// evicted yet.
+func (h *WebhookHistory) Get(id uint64) (webhookRecord, bool) {
+	for _, record := range h.Entries() {
+		if record.ID == id {
+			return record, true
+		}
+	}
+	return webhookRecord{}, false
+}
+
+// redactPayload hides sensitive fields in a JSON body. Bodies that are not
+// valid JSON are withheld entirely.
+func redactPayload(body []byte) json.RawMessage {
+	var decoded any
+	if err := json.Unmarshal(body, &decoded); err != nil {
+		return json.RawMessage(strconv.Quote(redactedValue))
+	}
+
+	redacted, err := json.Marshal(redactValue(decoded))
+	if err != nil {
+		return json.RawMessage(strconv.Quote(redactedValue))
+	}
+	return redacted
+}
+
+func redactValue(value any) any {
+	switch v := value.(type) {
+	case map[string]any:
+		for key, inner := range v {
+			if sensitiveFields[strings.ToLower(key)] {
+				v[key] = redactedValue
+			} else {
+				v[key] = redactValue(inner)
+			}
+		}
+	case []any:
+		for i := range v {
+			v[i] = redactValue(v[i])
+		}
+	}
+	return value
+}
+
+// WebhookHistoryHandler serves GET /debug/webhooks, listing stored payloads
+// with sensitive fields redacted.
+func WebhookHistoryHandler(bot *Bot) http.HandlerFunc {
+	return requireAdmin(bot.Configs, func(w http.ResponseWriter, r *http.Request) {
+		type entry struct {
+			ID         uint64          `json:"id"`
+			ReceivedAt time.Time       `json:"received_at"`
+			Payload    json.RawMessage `json:"payload"`
+		}
+
+		records := bot.History.Entries()
+		entries := make([]entry, 0, len(records))
+		for _, record := range records {
+			entries = append(entries, entry{ID: record.ID, ReceivedAt: record.ReceivedAt, Payload: redactPayload(record.Body)})
+		}
+
+		writeJSON(w, http.StatusOK, entries)
+	})
+}
+
+// WebhookReplayHandler serves POST /debug/replay/{id}, feeding a stored
+// payload through the webhook pipeline again.
+func WebhookReplayHandler(bot *Bot) http.HandlerFunc {
+	return requireAdmin(bot.Configs, func(w http.ResponseWriter, r *http.Request) {
+		id, err := strconv.ParseUint(r.PathValue("id"), 10, 64)
+		if err != nil {
+			http.Error(w, "unknown webhook id", http.StatusNotFound)
+			return
+		}
+		record, ok := bot.History.Get(id)
+		if !ok {
+			http.Error(w, "unknown webhook id", http.StatusNotFound)
+			return
+		}
+
+		log.Printf("replaying stored webhook %d received at %s", id, record.ReceivedAt.Format(time.RFC3339))
+		if err := bot.handlePayload(r.Context(), record.Body); err != nil {
+			http.Error(w, "stored payload is invalid: "+err.Error(), http.StatusUnprocessableEntity)
+			return
+		}
+
+		writeJSON(w, http.StatusOK, map[string]uint64{"replayed": id})
+	})
+}
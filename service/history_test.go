@@ -0,0 +1,103 @@
+package service
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestWebhookHistoryKeepsLatestWithStableIDs(t *testing.T) {
+	history := NewWebhookHistory(3)
+	now := time.Now()
+	for i := 1; i <= 5; i++ {
+		history.Add([]byte(fmt.Sprintf(`{"n":%d}`, i)), now)
+	}
+
+	entries := history.Entries()
+	if len(entries) != 3 {
+		t.Fatalf("%d entries, want 3", len(entries))
+	}
+	for i, entry := range entries {
+		want := uint64(i + 3)
+		if entry.ID != want || string(entry.Body) != fmt.Sprintf(`{"n":%d}`, want) {
+			t.Errorf("entry %d = %d %s, want payload %d", i, entry.ID, entry.Body, want)
+		}
+	}
+
+	if record, ok := history.Get(4); !ok || string(record.Body) != `{"n":4}` {
+		t.Errorf("Get(4) = %s, %v, want the fourth payload", record.Body, ok)
+	}
+	if _, ok := history.Get(2); ok {
+		t.Error("Get returned an evicted payload")
+	}
+}
+
+func TestWebhookHistoryHandlerRedacts(t *testing.T) {
+	bot := &Bot{Configs: NewConfigHolder(testConfig("")), History: NewWebhookHistory(5)}
+	bot.History.Add([]byte(`{"event":"messages.upsert","apikey":"secret-key","data":{"Token":"t"}}`), time.Now())
+	bot.History.Add([]byte(`not json`), time.Now())
+
+	rec := adminRequest(WebhookHistoryHandler(bot), http.MethodGet, "/debug/webhooks", "admin-key", "")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status %d", rec.Code)
+	}
+
+	var entries []struct {
+		ID      uint64          `json:"id"`
+		Payload json.RawMessage `json:"payload"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &entries); err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 2 || entries[0].ID != 1 || entries[1].ID != 2 {
+		t.Fatalf("entries %+v, want IDs 1 and 2", entries)
+	}
+	if got := string(entries[0].Payload); got != `{"apikey":"[REDACTED]","data":{"Token":"[REDACTED]"},"event":"messages.upsert"}` {
+		t.Errorf("payload %s, want sensitive fields redacted", got)
+	}
+	if got := string(entries[1].Payload); got != `"[REDACTED]"` {
+		t.Errorf("invalid JSON listed as %s, want it withheld", got)
+	}
+}
+
+func TestWebhookReplayHandler(t *testing.T) {
+	evo, oa := newFakeEvolution(t), newFakeOpenAI(t)
+	bot := newTestBot(testConfig(evo.URL), evo, oa)
+	bot.History = NewWebhookHistory(2)
+
+	msg, key := textMessage("in-1", "hi")
+	bot.History.Add(upsertPayload(t, "bot", messageEntry(msg, key)), time.Now())
+	bot.History.Add([]byte(`{`), time.Now())
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /debug/replay/{id}", WebhookReplayHandler(bot))
+	replay := func(id string) int {
+		req := httptest.NewRequest(http.MethodPost, "/debug/replay/"+id, nil)
+		req.Header.Set("Authorization", "Bearer admin-key")
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, req)
+		return rec.Code
+	}
+
+	if code := replay("1"); code != http.StatusOK {
+		t.Fatalf("replay 1: status %d", code)
+	}
+	if texts := evo.Texts(); len(texts) != 1 {
+		t.Errorf("replay sent %q, want one reply", texts)
+	}
+
+	for id, want := range map[string]int{"2": http.StatusUnprocessableEntity, "3": http.StatusNotFound, "x": http.StatusNotFound} {
+		if code := replay(id); code != want {
+			t.Errorf("replay %s: status %d, want %d", id, code, want)
+		}
+	}
+
+	// Once evicted, an ID no longer resolves to whatever took its slot.
+	bot.History.Add([]byte(`{}`), time.Now())
+	if code := replay("1"); code != http.StatusNotFound {
+		t.Errorf("replay of an evicted ID: status %d, want 404", code)
+	}
+}
@@ -0,0 +1,123 @@
+package service
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"hackathon/model"
+)
+
+const (
+	HumanizeOff     = "off"
+	HumanizeNatural = "natural"
+	HumanizeInstant = "instant"
+)
+
+// humanizeDeadlineReserve is the part of the message deadline pacing never
+// eats into, so a slow completion is not turned into a timeout by waiting.
+const humanizeDeadlineReserve = 5 * time.Second
+
+// humanizeTiming is the pacing of a humanization profile: the message is
+// marked read after ReadDelay, typing starts TypingDelay later, and the reply
+// is sent once ReplyDelayPerChar per reply character, capped at
+// MaxReplyDelay, has passed since typing started.
+type humanizeTiming struct {
+	ReadDelay         time.Duration
+	TypingDelay       time.Duration
+	ReplyDelayPerChar time.Duration
+	MaxReplyDelay     time.Duration
+}
+
+var humanizePresets = map[string]humanizeTiming{
+	HumanizeNatural: {
+		ReadDelay:         2 * time.Second,
+		TypingDelay:       time.Second,
+		ReplyDelayPerChar: 40 * time.Millisecond,
+		MaxReplyDelay:     8 * time.Second,
+	},
+	HumanizeInstant: {},
+}
+
+// pacer walks one reply through the humanization profile. A nil pacer, used
+// when the profile is off, does nothing.
+type pacer struct {
+	bot   *Bot
+	cfg   *model.Config
+	to    string
+	key   model.WebhookKey
+	start time.Time
+
+	cancelTyping context.CancelFunc
+}
+
+// startPacing starts the profile's clock for the message identified by key.
+// Nothing is shown to the user until waitReply, so a message whose reply
+// fails is not left read without an answer.
+func (b *Bot) startPacing(cfg *model.Config, to string, key model.WebhookKey) *pacer {
+	if cfg.HumanizeProfile == HumanizeOff {
+		return nil
+	}
+	return &pacer{bot: b, cfg: cfg, to: to, key: key, start: time.Now()}
+}
+
+// waitReply runs the profile's timeline once the reply is ready: the message
+// is marked read ReadDelay after it arrived, counting the time spent
+// generating, typing is shown TypingDelay later, and the reply is then held
+// for as long as it would plausibly have taken to type. Waits are cut short
+// so they never use up the last humanizeDeadlineReserve of ctx's deadline.
+func (p *pacer) waitReply(ctx context.Context, reply string) {
+	if p == nil {
+		return
+	}
+
+	p.wait(ctx, p.cfg.HumanizeReadDelay-time.Since(p.start))
+	markRead(ctx, p.bot.Evolution, p.key)
+	p.wait(ctx, p.cfg.HumanizeTypingDelay)
+
+	typingCtx, cancel := context.WithCancel(ctx)
+	p.cancelTyping = cancel
+	go func() {
+		// Evolution keeps the indicator up for the given delay; the request is
+		// abandoned once the reply is sent.
+		if err := p.bot.Evolution.SendPresence(typingCtx, p.to, "composing", p.cfg.HumanizeMaxReplyDelay+p.cfg.HumanizeTypingDelay); err != nil && typingCtx.Err() == nil {
+			log.Printf("typing indicator for %s failed: %v", p.to, err)
+		}
+	}()
+
+	delay := time.Duration(len([]rune(reply))) * p.cfg.HumanizeReplyDelayPerChar
+	if delay > p.cfg.HumanizeMaxReplyDelay {
+		delay = p.cfg.HumanizeMaxReplyDelay
+	}
+	p.wait(ctx, delay)
+}
+
+// stop ends the typing indicator.
+func (p *pacer) stop() {
+	if p == nil || p.cancelTyping == nil {
+		return
+	}
+	p.cancelTyping()
+}
+
+// wait sleeps for d on the bot's pacing clock, shortened so at least
+// humanizeDeadlineReserve of ctx's deadline remains.
+func (p *pacer) wait(ctx context.Context, d time.Duration) {
+	if deadline, ok := ctx.Deadline(); ok {
+		if remaining := time.Until(deadline) - humanizeDeadlineReserve; remaining < d {
+			d = remaining
+		}
+	}
+	if d <= 0 {
+		return
+	}
+
+	after := p.bot.after
+	if after == nil {
+		after = time.After
+	}
+	select {
+	case <-ctx.Done():
+	case <-after(d):
+	}
+}
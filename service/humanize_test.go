@@ -0,0 +1,165 @@
+package service
+
+import (
+	"context"
+	"net/http"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestLoadConfigHumanize(t *testing.T) {
+	setRequiredEnv(t)
+	t.Setenv("HUMANIZE", "natural")
+	cfg, err := LoadConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.HumanizeReadDelay != 2*time.Second || cfg.HumanizeMaxReplyDelay != 8*time.Second {
+		t.Errorf("natural preset not applied: read %s, max reply %s", cfg.HumanizeReadDelay, cfg.HumanizeMaxReplyDelay)
+	}
+
+	// 9.5s of reply delay plus 1s of typing delay outlasts the Evolution
+	// client's timeout.
+	t.Setenv("HUMANIZE_MAX_REPLY_DELAY", "9500ms")
+	if _, err := LoadConfig(); err == nil {
+		t.Error("LoadConfig accepted a typing indicator longer than the Evolution request timeout")
+	}
+	t.Setenv("HUMANIZE_TYPING_DELAY", "500ms")
+	if _, err := LoadConfig(); err != nil {
+		t.Errorf("typing indicator of exactly the timeout: %v", err)
+	}
+}
+
+func TestPacingNaturalTimeline(t *testing.T) {
+	bot, evo, oa := humanizedBot(t)
+	oa.Handle("/v1/chat/completions", slowCompletion(100*time.Millisecond, "Hello!"))
+	ticker := newFakeTicker()
+	bot.after = ticker.after
+	preset := humanizePresets[HumanizeNatural]
+
+	done := make(chan error, 1)
+	go func() {
+		msg, key := textMessage("in-1", "hi")
+		done <- bot.handleMessage(context.Background(), bot.Configs.Load(), "", "", msg, key)
+	}()
+
+	// The time spent generating counts towards the read delay.
+	if wait := ticker.nextWait(t); wait > preset.ReadDelay-100*time.Millisecond || wait <= 0 {
+		t.Errorf("read wait %s, want the read delay less the generation time", wait)
+	}
+	if paths := evo.Paths(); len(paths) != 0 {
+		t.Fatalf("sent %q before the read delay", paths)
+	}
+	ticker.fire <- time.Now()
+
+	if wait := ticker.nextWait(t); wait != preset.TypingDelay {
+		t.Errorf("typing wait %s, want %s", wait, preset.TypingDelay)
+	}
+	if paths := evo.Paths(); !slices.Equal(paths, []string{"/chat/markMessageAsRead/bot"}) {
+		t.Fatalf("sent %q before typing, want only the read receipt", paths)
+	}
+	ticker.fire <- time.Now()
+
+	// 6 characters of "Hello!" at 40ms each.
+	if wait := ticker.nextWait(t); wait != 6*preset.ReplyDelayPerChar {
+		t.Errorf("reply wait %s, want %s", wait, 6*preset.ReplyDelayPerChar)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for len(evo.Requests("/chat/sendPresence")) == 0 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	if paths := evo.Paths(); !slices.Equal(paths, []string{"/chat/markMessageAsRead/bot", "/chat/sendPresence/bot"}) {
+		t.Fatalf("sent %q while holding the reply, want the read receipt and typing", paths)
+	}
+	ticker.fire <- time.Now()
+
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	if texts := evo.Texts(); !slices.Equal(texts, []string{"Hello!"}) {
+		t.Errorf("sent %q, want the reply last", texts)
+	}
+}
+
+func TestPacingInstantTimeline(t *testing.T) {
+	bot, evo, _ := humanizedBot(t)
+	cfg := bot.Configs.Load()
+	cfg.HumanizeProfile = HumanizeInstant
+	preset := humanizePresets[HumanizeInstant]
+	cfg.HumanizeReadDelay = preset.ReadDelay
+	cfg.HumanizeTypingDelay = preset.TypingDelay
+	cfg.HumanizeReplyDelayPerChar = preset.ReplyDelayPerChar
+	cfg.HumanizeMaxReplyDelay = preset.MaxReplyDelay
+	bot.after = func(d time.Duration) <-chan time.Time {
+		t.Errorf("instant profile waited %s", d)
+		return time.After(0)
+	}
+
+	deliverText(t, bot, "in-1", "hi")
+
+	// The typing indicator is sent in the background and may land on either
+	// side of the reply.
+	var paths []string
+	for _, path := range evo.Paths() {
+		if path != "/chat/sendPresence/bot" {
+			paths = append(paths, path)
+		}
+	}
+	if !slices.Equal(paths, []string{"/chat/markMessageAsRead/bot", "/message/sendText/bot"}) {
+		t.Errorf("requests %q, want the read receipt and then the reply", paths)
+	}
+}
+
+// humanizedBot returns a bot paced by the natural profile whose waits end at
+// once.
+func humanizedBot(t *testing.T) (*Bot, *fakeEvolution, *fakeOpenAI) {
+	t.Helper()
+
+	evo, oa := newFakeEvolution(t), newFakeOpenAI(t)
+	cfg := testConfig(evo.URL)
+	preset := humanizePresets[HumanizeNatural]
+	cfg.HumanizeProfile = HumanizeNatural
+	cfg.HumanizeReadDelay = preset.ReadDelay
+	cfg.HumanizeTypingDelay = preset.TypingDelay
+	cfg.HumanizeReplyDelayPerChar = preset.ReplyDelayPerChar
+	cfg.HumanizeMaxReplyDelay = preset.MaxReplyDelay
+	bot := newTestBot(cfg, evo, oa)
+	bot.after = func(time.Duration) <-chan time.Time {
+		fired := make(chan time.Time, 1)
+		fired <- time.Now()
+		return fired
+	}
+	return bot, evo, oa
+}
+
+func TestPacingSendsNoReadReceiptWhenGenerationFails(t *testing.T) {
+	bot, evo, oa := humanizedBot(t)
+	// The failure comes after the read delay would have passed.
+	oa.Handle("/v1/chat/completions", func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(50 * time.Millisecond)
+		http.Error(w, `{"error":{"message":"boom","type":"server_error"}}`, http.StatusInternalServerError)
+	})
+
+	msg, key := textMessage("in-1", "hi")
+	if err := bot.handleMessage(context.Background(), bot.Configs.Load(), "", "", msg, key); err == nil {
+		t.Fatal("generation failure was not reported")
+	}
+	if paths := evo.Paths(); len(paths) != 0 {
+		t.Errorf("sent %q for a message that got no reply, want nothing", paths)
+	}
+}
+
+func TestPacingMarksNoticesRead(t *testing.T) {
+	bot, evo, _ := humanizedBot(t)
+	cfg := bot.Configs.Load()
+	cfg.OfficeHours, _ = parseOfficeHours("mon-fri 09:00-18:00")
+	cfg.AfterHoursMessage = "We are closed."
+	bot.now = func() time.Time { return time.Date(2024, 6, 3, 19, 0, 0, 0, time.UTC) }
+
+	deliverText(t, bot, "in-1", "hi")
+
+	if paths := evo.Paths(); !slices.Equal(paths, []string{"/message/sendText/bot", "/chat/markMessageAsRead/bot"}) {
+		t.Errorf("requests %q, want the after-hours notice and then the read receipt", paths)
+	}
+}
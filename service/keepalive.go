@@ -0,0 +1,78 @@
+package service
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+const maxKeepAliveBackoff = 16
+
+// KeepAlive periodically pings Redis and checks the Evolution connection so
+// idle connections do not go stale between messages.
+type KeepAlive struct {
+	redis    *redis.Client
+	bot      *Bot
+	interval time.Duration
+
+	// after is the clock the schedule runs on; tests replace time.After.
+	after func(time.Duration) <-chan time.Time
+}
+
+func NewKeepAlive(client *redis.Client, bot *Bot, interval time.Duration) *KeepAlive {
+	return &KeepAlive{redis: client, bot: bot, interval: interval, after: time.After}
+}
+
+// Run pings until ctx is cancelled. After a failed round the wait doubles,
+// up to maxKeepAliveBackoff times the interval, and resets on success.
+func (k *KeepAlive) Run(ctx context.Context) {
+	backoff := 1
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-k.after(k.interval * time.Duration(backoff)):
+		}
+
+		if k.ping(ctx) {
+			backoff = 1
+		} else if backoff < maxKeepAliveBackoff {
+			backoff *= 2
+		}
+	}
+}
+
+func (k *KeepAlive) ping(ctx context.Context) bool {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	healthy := true
+
+	if k.redis != nil {
+		if err := k.redis.Ping(ctx).Err(); err != nil {
+			log.Printf("keepalive: redis ping failed: %v", err)
+			healthy = false
+		}
+	}
+
+	state, err := k.bot.Evolution.ConnectionState(ctx)
+	switch {
+	case err != nil:
+		log.Printf("keepalive: evolution connection check failed: %v", err)
+		healthy = false
+	case state == "open":
+		k.bot.drainOutbound()
+	case state == "connecting":
+	default:
+		log.Printf("keepalive: evolution instance state is %q, reconnecting", state)
+		if err := k.bot.Evolution.Connect(ctx); err != nil {
+			log.Printf("keepalive: evolution reconnect failed: %v", err)
+		}
+		healthy = false
+	}
+
+	return healthy
+}
@@ -0,0 +1,97 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// fakeTicker drives KeepAlive.Run: every wait Run asks for is reported on
+// waits, and the wait ends when the test sends on fire.
+type fakeTicker struct {
+	waits chan time.Duration
+	fire  chan time.Time
+}
+
+func newFakeTicker() *fakeTicker {
+	return &fakeTicker{waits: make(chan time.Duration), fire: make(chan time.Time)}
+}
+
+func (f *fakeTicker) after(d time.Duration) <-chan time.Time {
+	f.waits <- d
+	return f.fire
+}
+
+func (f *fakeTicker) nextWait(t *testing.T) time.Duration {
+	t.Helper()
+	select {
+	case d := <-f.waits:
+		return d
+	case <-time.After(5 * time.Second):
+		t.Fatal("no wait was scheduled")
+		return 0
+	}
+}
+
+func TestKeepAliveScheduleAndBackoff(t *testing.T) {
+	evo := newFakeEvolution(t)
+	var healthy atomic.Bool
+	evo.Handle("/instance/connectionState", func(w http.ResponseWriter, r *http.Request) {
+		if !healthy.Load() {
+			http.Error(w, "down", http.StatusInternalServerError)
+			return
+		}
+		fmt.Fprint(w, `{"instance":{"state":"open"}}`)
+	})
+
+	bot := newTestBot(testConfig(evo.URL), evo, nil)
+	ticker := newFakeTicker()
+	keepAlive := NewKeepAlive(nil, bot, time.Minute)
+	keepAlive.after = ticker.after
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		keepAlive.Run(ctx)
+		close(done)
+	}()
+
+	// Each round's outcome and the wait scheduled after it.
+	rounds := []struct {
+		healthy bool
+		next    time.Duration
+	}{
+		{true, time.Minute},
+		{false, 2 * time.Minute},
+		{false, 4 * time.Minute},
+		{false, 8 * time.Minute},
+		{false, 16 * time.Minute},
+		{false, 16 * time.Minute},
+		{true, time.Minute},
+	}
+
+	if d := ticker.nextWait(t); d != time.Minute {
+		t.Fatalf("first wait = %s, want the interval", d)
+	}
+	for i, round := range rounds {
+		healthy.Store(round.healthy)
+		ticker.fire <- time.Now()
+		if d := ticker.nextWait(t); d != round.next {
+			t.Errorf("round %d (healthy=%v): next wait = %s, want %s", i, round.healthy, d, round.next)
+		}
+	}
+
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Run did not stop after cancel")
+	}
+
+	if got := len(evo.Requests("/instance/connectionState")); got != len(rounds) {
+		t.Errorf("%d connection checks, want one per round (%d)", got, len(rounds))
+	}
+}
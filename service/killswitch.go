@@ -0,0 +1,64 @@
+package service
+
+import (
+	"context"
+	"log"
+	"sync/atomic"
+
+	"github.com/redis/go-redis/v9"
+)
+
+const killSwitchKey = "bot:paused"
+
+// KillSwitch is a global pause flag. When backed by Redis the flag survives
+// restarts and is shared by every replica; the local value is used whenever
+// Redis cannot be reached.
+type KillSwitch struct {
+	client *redis.Client
+	paused atomic.Bool
+}
+
+func NewKillSwitch(client *redis.Client) *KillSwitch {
+	k := &KillSwitch{client: client}
+	if client != nil {
+		k.Paused(context.Background())
+	}
+	return k
+}
+
+func (k *KillSwitch) Paused(ctx context.Context) bool {
+	if k == nil {
+		return false
+	}
+	if k.client == nil {
+		return k.paused.Load()
+	}
+
+	value, err := k.client.Get(ctx, killSwitchKey).Result()
+	switch {
+	case err == redis.Nil:
+		k.paused.Store(false)
+	case err != nil:
+		log.Printf("kill switch: redis read failed, using local state: %v", err)
+	default:
+		k.paused.Store(value == "1")
+	}
+	return k.paused.Load()
+}
+
+func (k *KillSwitch) SetPaused(ctx context.Context, paused bool) error {
+	if k.client != nil {
+		var err error
+		if paused {
+			err = k.client.Set(ctx, killSwitchKey, "1", 0).Err()
+		} else {
+			err = k.client.Del(ctx, killSwitchKey).Err()
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	k.paused.Store(paused)
+	return nil
+}
@@ -0,0 +1,187 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"strings"
+	"unicode"
+
+	"github.com/redis/go-redis/v9"
+	openai "github.com/sashabaranov/go-openai"
+
+	"hackathon/model"
+)
+
+const (
+	LanguageDetectOff       = "off"
+	LanguageDetectHeuristic = "heuristic"
+	LanguageDetectOpenAI    = "openai"
+)
+
+// minLanguageConfidence is the confidence below which a detection is
+// discarded in favour of DEFAULT_LANGUAGE.
+const minLanguageConfidence = 0.5
+
+// languageStopwords holds frequent short words of each language. Words
+// shared by several listed languages, such as "para" or "con", are left out
+// since they cannot tell them apart.
+var languageStopwords = map[string][]string{
+	"English":    {"the", "and", "is", "are", "you", "what", "how", "with", "this", "have", "can", "my", "please", "thanks"},
+	"Portuguese": {"não", "você", "é", "uma", "com", "obrigado", "obrigada", "olá", "meu", "minha", "tudo", "bem", "também", "muito"},
+	"Spanish":    {"el", "los", "es", "gracias", "hola", "cómo", "qué", "usted", "tengo", "mi", "pero", "muy", "estoy", "bueno"},
+	"French":     {"le", "les", "est", "une", "pour", "avec", "merci", "bonjour", "vous", "je", "mon", "pas", "c'est", "comment"},
+	"German":     {"der", "die", "und", "ist", "nicht", "ich", "sie", "mit", "danke", "hallo", "wie", "mein", "ein", "bitte"},
+	"Italian":    {"il", "che", "è", "per", "grazie", "ciao", "come", "sono", "perché", "mio", "della", "questo", "molto", "anche"},
+}
+
+// LanguageStore remembers the language detected for each user so detection
+// runs once per conversation rather than on every message. It expires with
+// the conversation history.
+type LanguageStore struct {
+	client *redis.Client
+}
+
+func NewLanguageStore(client *redis.Client) *LanguageStore {
+	return &LanguageStore{client: client}
+}
+
+// Get returns the stored language for user, or "" when none is stored.
+func (s *LanguageStore) Get(ctx context.Context, user string) (string, error) {
+	if s == nil {
+		return "", nil
+	}
+
+	language, err := s.client.Get(ctx, s.key(user)).Result()
+	if err != nil {
+		if err == redis.Nil {
+			return "", nil
+		}
+		return "", err
+	}
+	return language, nil
+}
+
+func (s *LanguageStore) Set(ctx context.Context, user, language string) error {
+	if s == nil {
+		return nil
+	}
+	return s.client.Set(ctx, s.key(user), language, defaultConversationTTL).Err()
+}
+
+func (s *LanguageStore) key(user string) string {
+	return fmt.Sprintf("language:%s", user)
+}
+
+// replyLanguage returns the language user should be answered in, detecting it
+// from text on the first message and reusing the stored value afterwards. It
+// returns "" when detection is disabled.
+func (b *Bot) replyLanguage(ctx context.Context, cfg *model.Config, user, text string) string {
+	if cfg.LanguageDetection == LanguageDetectOff {
+		return ""
+	}
+
+	stored, err := b.Languages.Get(ctx, user)
+	if err != nil {
+		log.Printf("language lookup failed for %s: %v", user, err)
+	} else if stored != "" {
+		return stored
+	}
+
+	var language string
+	var confidence float64
+	switch cfg.LanguageDetection {
+	case LanguageDetectOpenAI:
+		language, confidence, err = classifyLanguage(ctx, b.OpenAI, cfg, text)
+		if err != nil {
+			log.Printf("language classification failed for %s: %v", user, err)
+		}
+	default:
+		language, confidence = detectLanguage(text)
+	}
+
+	if language == "" || confidence < minLanguageConfidence {
+		// Low-confidence guesses are not stored so a longer message can settle
+		// the language later.
+		return cfg.DefaultLanguage
+	}
+
+	if err := b.Languages.Set(ctx, user, language); err != nil {
+		log.Printf("language store failed for %s: %v", user, err)
+	}
+	return language
+}
+
+// detectLanguage scores text against languageStopwords. Confidence is the
+// share of matched stopwords that belong to the winning language, scaled down
+// for messages with only a single match.
+func detectLanguage(text string) (string, float64) {
+	words := strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && r != '\''
+	})
+
+	scores := make(map[string]int)
+	total := 0
+	for _, word := range words {
+		for language, stopwords := range languageStopwords {
+			for _, stopword := range stopwords {
+				if word == stopword {
+					scores[language]++
+					total++
+				}
+			}
+		}
+	}
+	if total == 0 {
+		return "", 0
+	}
+
+	best, bestScore := "", 0
+	for language, score := range scores {
+		if score > bestScore || (score == bestScore && language < best) {
+			best, bestScore = language, score
+		}
+	}
+
+	confidence := float64(bestScore) / float64(total)
+	if bestScore < 2 {
+		confidence /= 2
+	}
+	return best, confidence
+}
+
+// classifyLanguage asks the model which language text is written in.
+func classifyLanguage(ctx context.Context, oa *openai.Client, cfg *model.Config, text string) (string, float64, error) {
+	var resp openai.ChatCompletionResponse
+	err := withOpenAIRetries(ctx, cfg, func() error {
+		var err error
+		resp, err = oa.CreateChatCompletion(ctx, openai.ChatCompletionRequest{
+			Model: cfg.OpenAIModel,
+			Messages: []openai.ChatCompletionMessage{
+				{
+					Role:    openai.ChatMessageRoleSystem,
+					Content: `Identify the language of the user's message. Answer with JSON: {"language": "<English name of the language>", "confidence": <number between 0 and 1>}.`,
+				},
+				{Role: openai.ChatMessageRoleUser, Content: text},
+			},
+			ResponseFormat: &openai.ChatCompletionResponseFormat{Type: openai.ChatCompletionResponseFormatTypeJSONObject},
+		})
+		return err
+	})
+	if err != nil {
+		return "", 0, err
+	}
+	if len(resp.Choices) == 0 {
+		return "", 0, nil
+	}
+
+	var result struct {
+		Language   string  `json:"language"`
+		Confidence float64 `json:"confidence"`
+	}
+	if err := json.Unmarshal([]byte(resp.Choices[0].Message.Content), &result); err != nil {
+		return "", 0, fmt.Errorf("decode language classification: %w", err)
+	}
+	return strings.TrimSpace(result.Language), result.Confidence, nil
+}
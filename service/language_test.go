@@ -0,0 +1,70 @@
+package service
+
+import (
+	"context"
+	"testing"
+)
+
+func TestLanguageStopwordsAreDistinct(t *testing.T) {
+	owner := make(map[string]string)
+	for language, stopwords := range languageStopwords {
+		for _, word := range stopwords {
+			if other, ok := owner[word]; ok {
+				t.Errorf("%q is listed for both %s and %s", word, other, language)
+			}
+			owner[word] = language
+		}
+	}
+}
+
+func TestDetectLanguage(t *testing.T) {
+	for _, tc := range []struct {
+		text      string
+		want      string
+		confident bool
+	}{
+		{"Olá, tudo bem? Você pode me ajudar?", "Portuguese", true},
+		{"Hola, ¿cómo está usted? Tengo una pregunta", "Spanish", true},
+		{"Ciao, come stai? Grazie per tutto", "Italian", true},
+		{"Bonjour, est-ce que vous pouvez m'aider?", "French", true},
+		{"Hallo, ich brauche bitte Hilfe", "German", true},
+		{"Hi, can you help me with this please?", "English", true},
+		{"para", "", false},
+		{"ok", "", false},
+	} {
+		language, confidence := detectLanguage(tc.text)
+		if language != tc.want || (confidence >= minLanguageConfidence) != tc.confident {
+			t.Errorf("%q: %s (%.2f), want %s", tc.text, language, confidence, tc.want)
+		}
+	}
+}
+
+func TestReplyLanguageStoresDetection(t *testing.T) {
+	_, client := newTestRedis(t)
+	cfg := testConfig("")
+	cfg.LanguageDetection = LanguageDetectHeuristic
+	cfg.DefaultLanguage = "English"
+	bot := &Bot{Languages: NewLanguageStore(client)}
+	ctx := context.Background()
+
+	// A guess too weak to trust falls back to the default and is not stored.
+	if got := bot.replyLanguage(ctx, cfg, "5511999999999", "ok"); got != "English" {
+		t.Errorf("ambiguous message answered in %s, want the default", got)
+	}
+	if stored, _ := bot.Languages.Get(ctx, "5511999999999"); stored != "" {
+		t.Errorf("stored %q for an ambiguous message", stored)
+	}
+
+	if got := bot.replyLanguage(ctx, cfg, "5511999999999", "Olá, tudo bem com você?"); got != "Portuguese" {
+		t.Fatalf("detected %s, want Portuguese", got)
+	}
+	// Later messages reuse the stored language rather than detecting again.
+	if got := bot.replyLanguage(ctx, cfg, "5511999999999", "Thanks, and how are you?"); got != "Portuguese" {
+		t.Errorf("second message answered in %s, want the stored Portuguese", got)
+	}
+
+	cfg.LanguageDetection = LanguageDetectOff
+	if got := bot.replyLanguage(ctx, cfg, "5511999999999", "Olá"); got != "" {
+		t.Errorf("detection off returned %q", got)
+	}
+}
@@ -0,0 +1,66 @@
+package service
+
+import (
+	"regexp"
+	"strings"
+)
+
+var (
+	markdownLink      = regexp.MustCompile(`\[([^\]]+)\]\(([^)\s]+)\)`)
+	markdownHeading   = regexp.MustCompile(`^\s{0,3}#{1,6}\s+(.*?)\s*#*\s*$`)
+	markdownBullet    = regexp.MustCompile(`^(\s*)[-*+]\s+`)
+	markdownBold      = regexp.MustCompile(`\*\*(.+?)\*\*|__(.+?)__`)
+	markdownTableRule = regexp.MustCompile(`^\s*\|?\s*:?-{3,}:?\s*(\|\s*:?-{3,}:?\s*)*\|?\s*$`)
+)
+
+// sanitizeMarkdown rewrites markdown that WhatsApp does not render into its
+// closest plain or WhatsApp-flavoured equivalent. Code blocks are kept as-is
+// apart from their language tag.
+func sanitizeMarkdown(text string) string {
+	lines := strings.Split(text, "\n")
+	out := make([]string, 0, len(lines))
+	inFence := false
+
+	for _, line := range lines {
+		if strings.HasPrefix(strings.TrimSpace(line), "```") {
+			inFence = !inFence
+			out = append(out, "```")
+			continue
+		}
+		if inFence {
+			out = append(out, line)
+			continue
+		}
+
+		if markdownTableRule.MatchString(line) && strings.Contains(line, "|") {
+			continue
+		}
+
+		if match := markdownHeading.FindStringSubmatch(line); match != nil {
+			line = "*" + match[1] + "*"
+		}
+
+		line = markdownBullet.ReplaceAllString(line, "$1• ")
+
+		if trimmed := strings.TrimSpace(line); strings.HasPrefix(trimmed, "|") && strings.HasSuffix(trimmed, "|") {
+			cells := strings.Split(strings.Trim(trimmed, "|"), "|")
+			for j := range cells {
+				cells[j] = strings.TrimSpace(cells[j])
+			}
+			line = strings.Join(cells, " | ")
+		}
+
+		line = markdownBold.ReplaceAllString(line, "*$1$2*")
+		line = markdownLink.ReplaceAllStringFunc(line, func(link string) string {
+			parts := markdownLink.FindStringSubmatch(link)
+			if parts[1] == parts[2] {
+				return parts[2]
+			}
+			return parts[1] + " (" + parts[2] + ")"
+		})
+
+		out = append(out, line)
+	}
+
+	return strings.TrimSpace(strings.Join(out, "\n"))
+}
@@ -0,0 +1,23 @@
+package service
+
+import "testing"
+
+func TestSanitizeMarkdown(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		in   string
+		want string
+	}{
+		{"link", "See [the docs](https://example.com/docs) now", "See the docs (https://example.com/docs) now"},
+		{"bare link", "[https://example.com](https://example.com)", "https://example.com"},
+		{"heading", "## Opening hours ##", "*Opening hours*"},
+		{"bold heading text", "# Plans\n**Basic** plan", "*Plans*\n*Basic* plan"},
+		{"bullets", "- one\n  * two", "• one\n  • two"},
+		{"code fence", "```go\nfmt.Println(\"[x](y)\")\n# not a heading\n```", "```\nfmt.Println(\"[x](y)\")\n# not a heading\n```"},
+		{"table", "| a | b |\n|---|---|\n| 1 | 2 |", "a | b\n1 | 2"},
+	} {
+		if got := sanitizeMarkdown(tc.in); got != tc.want {
+			t.Errorf("%s: sanitizeMarkdown(%q) = %q, want %q", tc.name, tc.in, got, tc.want)
+		}
+	}
+}
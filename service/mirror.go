@@ -0,0 +1,85 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const mirrorTimeout = 10 * time.Second
+
+type mirrorPayload struct {
+	User    string `json:"user"`
+	Inbound string `json:"inbound"`
+	Reply   string `json:"reply"`
+	// Text lets Slack incoming webhooks render the exchange; other endpoints
+	// can ignore it.
+	Text string `json:"text"`
+}
+
+// MirrorSink copies every bot reply to a monitoring endpoint such as a Slack
+// incoming webhook.
+type MirrorSink struct {
+	url        string
+	httpClient *http.Client
+}
+
+func NewMirrorSink(url string) *MirrorSink {
+	return &MirrorSink{
+		url:        url,
+		httpClient: &http.Client{Timeout: mirrorTimeout},
+	}
+}
+
+// Mirror posts the exchange in the background. It never blocks the caller and
+// failures are only logged, so mirroring cannot affect the reply itself.
+func (m *MirrorSink) Mirror(user, inbound, reply string) {
+	if m == nil {
+		return
+	}
+
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), mirrorTimeout)
+		defer cancel()
+
+		if err := m.post(ctx, mirrorPayload{
+			User:    user,
+			Inbound: inbound,
+			Reply:   reply,
+			Text:    fmt.Sprintf("*%s*: %s\n*bot*: %s", user, inbound, reply),
+		}); err != nil {
+			log.Printf("mirroring reply to %s failed: %v", user, err)
+		}
+	}()
+}
+
+func (m *MirrorSink) post(ctx context.Context, payload mirrorPayload) error {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, m.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := m.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		responseBody, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("mirror webhook error: %s - %s", resp.Status, strings.TrimSpace(string(responseBody)))
+	}
+	return nil
+}
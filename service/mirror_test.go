@@ -0,0 +1,124 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"testing"
+	"time"
+)
+
+// recordMirror starts a mirror webhook that records every payload.
+func recordMirror(t *testing.T) (*httptest.Server, chan mirrorPayload) {
+	t.Helper()
+
+	received := make(chan mirrorPayload, 10)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var payload mirrorPayload
+		if r.Method != http.MethodPost || r.Header.Get("Content-Type") != "application/json" {
+			http.Error(w, "bad request", http.StatusBadRequest)
+			return
+		}
+		if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		received <- payload
+	}))
+	t.Cleanup(server.Close)
+	return server, received
+}
+
+// nextMirrored waits for the next mirrored exchange.
+func nextMirrored(t *testing.T, received chan mirrorPayload) mirrorPayload {
+	t.Helper()
+
+	select {
+	case payload := <-received:
+		return payload
+	case <-time.After(5 * time.Second):
+		t.Fatal("exchange was not mirrored")
+		return mirrorPayload{}
+	}
+}
+
+func TestMirrorPostsExchange(t *testing.T) {
+	server, received := recordMirror(t)
+
+	evo, oa := newFakeEvolution(t), newFakeOpenAI(t)
+	bot := newTestBot(testConfig(evo.URL), evo, oa)
+	bot.Mirror = NewMirrorSink(server.URL)
+
+	deliverText(t, bot, "in-1", "hi")
+
+	want := mirrorPayload{User: "5511999999999", Inbound: "hi", Reply: "Hello!", Text: "*5511999999999*: hi\n*bot*: Hello!"}
+	if payload := nextMirrored(t, received); payload != want {
+		t.Errorf("mirrored %+v, want %+v", payload, want)
+	}
+}
+
+func TestMirrorCoversCachedResendsAndNotices(t *testing.T) {
+	server, received := recordMirror(t)
+
+	evo, oa := newFakeEvolution(t), newFakeOpenAI(t)
+	cfg := testConfig(evo.URL)
+	cfg.DailyMessageQuota = 1
+	cfg.QuotaExceededMessage = "Come back tomorrow."
+	_, client := newTestRedis(t)
+	bot := newTestBot(cfg, evo, oa)
+	bot.Mirror = NewMirrorSink(server.URL)
+	bot.Replies = NewReplyCache(client, time.Hour)
+	bot.Quota = NewDailyQuota(client)
+
+	deliverText(t, bot, "in-1", "hi")
+	deliverText(t, bot, "in-1", "hi")
+	deliverText(t, bot, "in-2", "again")
+
+	// Mirrors are posted in the background, so they may arrive in any order.
+	var got []string
+	for range 3 {
+		payload := nextMirrored(t, received)
+		got = append(got, payload.Inbound+" -> "+payload.Reply)
+	}
+	slices.Sort(got)
+	if want := []string{"again -> Come back tomorrow.", "hi -> Hello!", "hi -> Hello!"}; !slices.Equal(got, want) {
+		t.Errorf("mirrored %q, want %q", got, want)
+	}
+	select {
+	case payload := <-received:
+		t.Errorf("extra mirror %+v, want each reply mirrored once", payload)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestMirrorFailureDoesNotAffectReply(t *testing.T) {
+	failed := make(chan struct{}, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "down", http.StatusInternalServerError)
+		failed <- struct{}{}
+	}))
+	defer server.Close()
+
+	evo, oa := newFakeEvolution(t), newFakeOpenAI(t)
+	bot := newTestBot(testConfig(evo.URL), evo, oa)
+	bot.Mirror = NewMirrorSink(server.URL)
+
+	msg, key := textMessage("in-1", "hi")
+	if err := bot.handleMessage(context.Background(), bot.Configs.Load(), "", "", msg, key); err != nil {
+		t.Fatalf("handleMessage = %v, want the mirror failure swallowed", err)
+	}
+	if texts := evo.Texts(); !slices.Equal(texts, []string{"Hello!"}) {
+		t.Errorf("sent %q, want the reply", texts)
+	}
+
+	select {
+	case <-failed:
+	case <-time.After(5 * time.Second):
+		t.Fatal("mirror was not attempted")
+	}
+	if err := NewMirrorSink(server.URL).post(context.Background(), mirrorPayload{}); err == nil {
+		t.Error("post reported success for a 500")
+	}
+}
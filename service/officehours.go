@@ -0,0 +1,136 @@
+package service
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"hackathon/model"
+)
+
+var weekdayNames = map[string]time.Weekday{
+	"sun": time.Sunday,
+	"mon": time.Monday,
+	"tue": time.Tuesday,
+	"wed": time.Wednesday,
+	"thu": time.Thursday,
+	"fri": time.Friday,
+	"sat": time.Saturday,
+}
+
+// parseOfficeHours parses a schedule such as "mon-fri 09:00-18:00,sat 10:00-14:00".
+func parseOfficeHours(spec string) ([]model.OfficeHoursWindow, error) {
+	var windows []model.OfficeHoursWindow
+
+	for _, entry := range strings.Split(spec, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		fields := strings.Fields(entry)
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("invalid entry %q", entry)
+		}
+
+		days, err := parseWeekdayRange(fields[0])
+		if err != nil {
+			return nil, err
+		}
+
+		start, end, err := parseClockRange(fields[1])
+		if err != nil {
+			return nil, err
+		}
+
+		for _, day := range days {
+			windows = append(windows, model.OfficeHoursWindow{Weekday: day, Start: start, End: end})
+		}
+	}
+
+	if len(windows) == 0 {
+		return nil, fmt.Errorf("empty schedule")
+	}
+
+	return windows, nil
+}
+
+func parseWeekdayRange(value string) ([]time.Weekday, error) {
+	first, last, isRange := strings.Cut(strings.ToLower(value), "-")
+
+	from, ok := weekdayNames[first]
+	if !ok {
+		return nil, fmt.Errorf("invalid weekday %q", first)
+	}
+	if !isRange {
+		return []time.Weekday{from}, nil
+	}
+
+	to, ok := weekdayNames[last]
+	if !ok {
+		return nil, fmt.Errorf("invalid weekday %q", last)
+	}
+
+	days := []time.Weekday{from}
+	for day := from; day != to; {
+		day = (day + 1) % 7
+		days = append(days, day)
+	}
+	return days, nil
+}
+
+func parseClockRange(value string) (time.Duration, time.Duration, error) {
+	startRaw, endRaw, ok := strings.Cut(value, "-")
+	if !ok {
+		return 0, 0, fmt.Errorf("invalid time range %q", value)
+	}
+
+	start, err := parseClock(startRaw)
+	if err != nil {
+		return 0, 0, err
+	}
+	end, err := parseClock(endRaw)
+	if err != nil {
+		return 0, 0, err
+	}
+	if end <= start {
+		return 0, 0, fmt.Errorf("time range %q ends before it starts", value)
+	}
+
+	return start, end, nil
+}
+
+func parseClock(value string) (time.Duration, error) {
+	if value == "24:00" {
+		return 24 * time.Hour, nil
+	}
+
+	t, err := time.Parse("15:04", value)
+	if err != nil {
+		return 0, fmt.Errorf("invalid time %q", value)
+	}
+	return time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute, nil
+}
+
+// withinOfficeHours reports whether now falls inside the configured schedule.
+// An empty schedule means the bot is always available.
+func withinOfficeHours(cfg *model.Config, now time.Time) bool {
+	if len(cfg.OfficeHours) == 0 {
+		return true
+	}
+
+	loc := cfg.OfficeHoursLocation
+	if loc == nil {
+		loc = time.UTC
+	}
+	local := now.In(loc)
+
+	sinceMidnight := time.Duration(local.Hour())*time.Hour + time.Duration(local.Minute())*time.Minute
+	for _, window := range cfg.OfficeHours {
+		if window.Weekday == local.Weekday() && sinceMidnight >= window.Start && sinceMidnight < window.End {
+			return true
+		}
+	}
+
+	return false
+}
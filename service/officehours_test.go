@@ -0,0 +1,119 @@
+package service
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestParseOfficeHoursErrors(t *testing.T) {
+	for _, spec := range []string{
+		"",
+		" , ",
+		"mon-fri",
+		"mon-fri 09:00-18:00 extra",
+		"funday 09:00-18:00",
+		"mon-funday 09:00-18:00",
+		"mon 09:00",
+		"mon 9am-18:00",
+		"mon 09:00-25:00",
+		"mon 18:00-09:00",
+		"mon 09:00-09:00",
+	} {
+		if _, err := parseOfficeHours(spec); err == nil {
+			t.Errorf("parseOfficeHours(%q) succeeded, want error", spec)
+		}
+	}
+}
+
+func TestParseOfficeHoursWrapsWeekdays(t *testing.T) {
+	windows, err := parseOfficeHours("fri-mon 10:00-24:00, wed 08:30-12:00")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []time.Weekday{time.Friday, time.Saturday, time.Sunday, time.Monday, time.Wednesday}
+	if len(windows) != len(want) {
+		t.Fatalf("got %d windows, want %d", len(windows), len(want))
+	}
+	for i, window := range windows {
+		if window.Weekday != want[i] {
+			t.Errorf("window %d weekday = %s, want %s", i, window.Weekday, want[i])
+		}
+	}
+	if windows[0].End != 24*time.Hour {
+		t.Errorf("24:00 parsed as %s", windows[0].End)
+	}
+	if windows[4].Start != 8*time.Hour+30*time.Minute {
+		t.Errorf("08:30 parsed as %s", windows[4].Start)
+	}
+}
+
+func TestWithinOfficeHours(t *testing.T) {
+	cfg := testConfig("")
+	windows, err := parseOfficeHours("mon-fri 09:00-18:00")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg.OfficeHours = windows
+	cfg.OfficeHoursLocation, err = time.LoadLocation("America/Sao_Paulo")
+	if err != nil {
+		t.Skipf("timezone data unavailable: %v", err)
+	}
+
+	for _, tc := range []struct {
+		at   string
+		want bool
+	}{
+		{"2024-06-03T12:00:00Z", true},  // Monday 09:00 in São Paulo
+		{"2024-06-03T11:59:00Z", false}, // Monday 08:59
+		{"2024-06-03T20:59:00Z", true},  // Monday 17:59
+		{"2024-06-03T21:00:00Z", false}, // Monday 18:00, end is exclusive
+		{"2024-06-08T15:00:00Z", false}, // Saturday
+	} {
+		now, _ := time.Parse(time.RFC3339, tc.at)
+		if got := withinOfficeHours(cfg, now); got != tc.want {
+			t.Errorf("withinOfficeHours(%s) = %v, want %v", tc.at, got, tc.want)
+		}
+	}
+
+	cfg.OfficeHours = nil
+	if !withinOfficeHours(cfg, time.Date(2024, 6, 8, 3, 0, 0, 0, time.UTC)) {
+		t.Error("an empty schedule should always be within office hours")
+	}
+}
+
+func TestHandleMessageOfficeHours(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		at   time.Time
+		want string
+	}{
+		{"in hours", time.Date(2024, 6, 3, 10, 0, 0, 0, time.UTC), "Hello!"},
+		{"after hours", time.Date(2024, 6, 3, 19, 0, 0, 0, time.UTC), "We are closed."},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			evo, oa := newFakeEvolution(t), newFakeOpenAI(t)
+			cfg := testConfig(evo.URL)
+			cfg.OfficeHours, _ = parseOfficeHours("mon-fri 09:00-18:00")
+			cfg.OfficeHoursLocation = time.UTC
+			cfg.AfterHoursMessage = "We are closed."
+
+			bot := newTestBot(cfg, evo, oa)
+			bot.now = func() time.Time { return tc.at }
+
+			msg, key := textMessage("in-1", "hi")
+			if err := bot.handleMessage(context.Background(), cfg, "", "", msg, key); err != nil {
+				t.Fatal(err)
+			}
+
+			texts := evo.Texts()
+			if len(texts) != 1 || texts[0] != tc.want {
+				t.Errorf("sent %q, want [%q]", texts, tc.want)
+			}
+			if tc.want == cfg.AfterHoursMessage && len(oa.Requests()) != 0 {
+				t.Error("OpenAI was called after hours")
+			}
+		})
+	}
+}
@@ -0,0 +1,45 @@
+package service
+
+import (
+	"net/http"
+
+	openai "github.com/sashabaranov/go-openai"
+
+	"hackathon/model"
+)
+
+// NewOpenAIClient returns an OpenAI client for cfg, scoped to the configured
+// organization and project when they are set.
+func NewOpenAIClient(cfg *model.Config) *openai.Client {
+	return openai.NewClientWithConfig(openAIClientConfig(cfg))
+}
+
+func openAIClientConfig(cfg *model.Config) openai.ClientConfig {
+	config := openai.DefaultConfig(cfg.OpenAIAPIKey)
+	if cfg.OpenAIOrgID != "" {
+		config.OrgID = cfg.OpenAIOrgID
+	}
+	if cfg.OpenAIProjectID != "" {
+		config.HTTPClient = newOpenAIProjectClient(cfg.OpenAIProjectID)
+	}
+	return config
+}
+
+// projectTransport scopes every OpenAI request to a project. The client
+// library only supports the organization header natively.
+type projectTransport struct {
+	project string
+	base    http.RoundTripper
+}
+
+// newOpenAIProjectClient returns an HTTP client for the OpenAI library that
+// sends the OpenAI-Project header with every request.
+func newOpenAIProjectClient(project string) *http.Client {
+	return &http.Client{Transport: &projectTransport{project: project, base: http.DefaultTransport}}
+}
+
+func (t *projectTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	req = req.Clone(req.Context())
+	req.Header.Set("OpenAI-Project", t.project)
+	return t.base.RoundTrip(req)
+}
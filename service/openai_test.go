@@ -0,0 +1,44 @@
+package service
+
+import (
+	"context"
+	"testing"
+
+	openai "github.com/sashabaranov/go-openai"
+)
+
+func TestOpenAIClientScopeHeaders(t *testing.T) {
+	for _, tc := range []struct {
+		org, project string
+	}{
+		{"", ""},
+		{"org-123", ""},
+		{"", "proj-456"},
+		{"org-123", "proj-456"},
+	} {
+		oa := newFakeOpenAI(t)
+		cfg := testConfig("")
+		cfg.OpenAIOrgID = tc.org
+		cfg.OpenAIProjectID = tc.project
+
+		config := openAIClientConfig(cfg)
+		config.BaseURL = oa.URL + "/v1"
+		_, err := openai.NewClientWithConfig(config).CreateChatCompletion(context.Background(), openai.ChatCompletionRequest{
+			Model:    cfg.OpenAIModel,
+			Messages: []openai.ChatCompletionMessage{{Role: openai.ChatMessageRoleUser, Content: "hi"}},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		oa.mu.Lock()
+		header := oa.headers[0]
+		oa.mu.Unlock()
+		if got := header.Get("OpenAI-Organization"); got != tc.org {
+			t.Errorf("org %q, project %q: OpenAI-Organization %q", tc.org, tc.project, got)
+		}
+		if got := header.Get("OpenAI-Project"); got != tc.project {
+			t.Errorf("org %q, project %q: OpenAI-Project %q", tc.org, tc.project, got)
+		}
+	}
+}
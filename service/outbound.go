@@ -0,0 +1,78 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+
+	"hackathon/model"
+)
+
+var errOutboundQueueFull = errors.New("outbound queue is full")
+
+type queuedMessage struct {
+	To       string    `json:"to"`
+	Text     string    `json:"text"`
+	QueuedAt time.Time `json:"queued_at"`
+}
+
+// OutboundQueue buffers replies in a Redis list while the WhatsApp instance is
+// disconnected and flushes them in order once it reconnects.
+type OutboundQueue struct {
+	queue redisQueue
+}
+
+func NewOutboundQueue(client *redis.Client, cfg *model.Config) *OutboundQueue {
+	return &OutboundQueue{queue: redisQueue{
+		client:  client,
+		key:     fmt.Sprintf("outbound:%s", cfg.EvolutionInstance),
+		maxSize: int64(cfg.OutboundQueueMax),
+		maxAge:  cfg.OutboundQueueMaxAge,
+	}}
+}
+
+func (q *OutboundQueue) Enqueue(ctx context.Context, to, text string) error {
+	pushed, err := q.queue.push(ctx, queuedMessage{To: to, Text: text, QueuedAt: time.Now()})
+	if err != nil {
+		return fmt.Errorf("queue message: %w", err)
+	}
+	if !pushed {
+		return errOutboundQueueFull
+	}
+	return nil
+}
+
+// Drain sends queued messages oldest first. It stops, leaving the remaining
+// entries in place, as soon as the instance reports it is disconnected again
+// or Evolution rate limits the sends. Entries older than the configured max
+// age are discarded. Only one replica drains at a time; Drain returns at once
+// while another holds the queue.
+func (q *OutboundQueue) Drain(ctx context.Context, evo *EvolutionClient) error {
+	sent := 0
+	err := q.queue.drain(ctx, func(ctx context.Context, payload []byte) error {
+		var msg queuedMessage
+		if err := json.Unmarshal(payload, &msg); err != nil {
+			log.Printf("outbound queue: dropping undecodable entry: %v", err)
+		} else if time.Since(msg.QueuedAt) > q.queue.maxAge {
+			log.Printf("outbound queue: dropping stale message for %s queued at %s", msg.To, msg.QueuedAt.Format(time.RFC3339))
+		} else if _, err := evo.SendTextMessage(ctx, msg.To, msg.Text); err != nil {
+			if isDisconnectedError(err) || isRateLimitedError(err) {
+				return err
+			}
+			log.Printf("outbound queue: send to %s failed, dropping: %v", msg.To, err)
+		} else {
+			sent++
+		}
+		return nil
+	})
+
+	if sent > 0 {
+		log.Printf("outbound queue: flushed %d queued messages", sent)
+	}
+	return err
+}
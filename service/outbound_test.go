@@ -0,0 +1,182 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"hackathon/model"
+)
+
+// flakyInstance makes the fake Evolution server reject sends as disconnected
+// until connected is set, recording the texts that were delivered.
+type flakyInstance struct {
+	connected atomic.Bool
+
+	mu        sync.Mutex
+	delivered []string
+}
+
+func (f *flakyInstance) handler(w http.ResponseWriter, r *http.Request) {
+	if !f.connected.Load() {
+		http.Error(w, `{"message":"Connection Closed"}`, http.StatusBadRequest)
+		return
+	}
+
+	var body struct {
+		Text string `json:"text"`
+	}
+	_ = json.NewDecoder(r.Body).Decode(&body)
+	f.mu.Lock()
+	f.delivered = append(f.delivered, body.Text)
+	f.mu.Unlock()
+	w.Write([]byte(`{"key":{"id":"sent"},"status":"PENDING"}`))
+}
+
+func (f *flakyInstance) texts() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]string(nil), f.delivered...)
+}
+
+func TestOutboundQueueDeliversInOrderAfterReconnect(t *testing.T) {
+	evo := newFakeEvolution(t)
+	instance := &flakyInstance{}
+	evo.Handle("/message/sendText", instance.handler)
+
+	cfg := testConfig(evo.URL)
+	cfg.OutboundQueueMax = 10
+	cfg.OutboundQueueMaxAge = time.Hour
+	_, client := newTestRedis(t)
+	bot := newTestBot(cfg, evo, nil)
+	bot.Outbound = NewOutboundQueue(client, cfg)
+
+	ctx := context.Background()
+	want := []string{"one", "two", "three"}
+	for _, text := range want {
+		if err := bot.sendText(ctx, "5511999999999", text); err != nil {
+			t.Fatalf("send while disconnected: %v", err)
+		}
+	}
+	if got := instance.texts(); len(got) != 0 {
+		t.Fatalf("delivered %q while disconnected", got)
+	}
+
+	instance.connected.Store(true)
+	body, _ := json.Marshal(model.WebhookPayload{
+		Event:    "connection.update",
+		Instance: "bot",
+		Data:     json.RawMessage(`{"instance":"bot","state":"open"}`),
+	})
+	if err := bot.handlePayload(ctx, body); err != nil {
+		t.Fatal(err)
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for len(instance.texts()) < len(want) && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if got := instance.texts(); !slices.Equal(got, want) {
+		t.Errorf("delivered %q after reconnect, want %q", got, want)
+	}
+	if n := client.LLen(ctx, "outbound:bot").Val(); n != 0 {
+		t.Errorf("%d messages left in the queue", n)
+	}
+}
+
+func TestOutboundQueueDrainStopsOnDisconnect(t *testing.T) {
+	evo := newFakeEvolution(t)
+	instance := &flakyInstance{}
+	evo.Handle("/message/sendText", instance.handler)
+
+	cfg := testConfig(evo.URL)
+	cfg.OutboundQueueMax = 10
+	cfg.OutboundQueueMaxAge = time.Hour
+	_, client := newTestRedis(t)
+	queue := NewOutboundQueue(client, cfg)
+
+	ctx := context.Background()
+	for _, text := range []string{"one", "two"} {
+		if err := queue.Enqueue(ctx, "5511999999999", text); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := queue.Drain(ctx, NewEvolutionClient(cfg)); !isDisconnectedError(err) {
+		t.Fatalf("Drain error = %v, want the disconnect", err)
+	}
+	if n := client.LLen(ctx, "outbound:bot").Val(); n != 2 {
+		t.Errorf("%d messages left in the queue, want both kept", n)
+	}
+}
+
+func TestOutboundQueueEnqueueRespectsMaxUnderConcurrency(t *testing.T) {
+	cfg := testConfig("")
+	cfg.OutboundQueueMax = 5
+	cfg.OutboundQueueMaxAge = time.Hour
+	_, client := newTestRedis(t)
+	queue := NewOutboundQueue(client, cfg)
+
+	var wg sync.WaitGroup
+	var full atomic.Int32
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			err := queue.Enqueue(context.Background(), "5511999999999", "hi")
+			if errors.Is(err, errOutboundQueueFull) {
+				full.Add(1)
+			} else if err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if n := client.LLen(context.Background(), "outbound:bot").Val(); n != 5 {
+		t.Errorf("queue holds %d messages, want the cap of 5", n)
+	}
+	if got := full.Load(); got != 15 {
+		t.Errorf("%d enqueues rejected as full, want 15", got)
+	}
+}
+
+func TestOutboundQueueDrainSkippedWhileLeased(t *testing.T) {
+	evo := newFakeEvolution(t)
+	cfg := testConfig(evo.URL)
+	cfg.OutboundQueueMax = 10
+	cfg.OutboundQueueMaxAge = time.Hour
+	_, client := newTestRedis(t)
+	queue := NewOutboundQueue(client, cfg)
+
+	ctx := context.Background()
+	if err := queue.Enqueue(ctx, "5511999999999", "hi"); err != nil {
+		t.Fatal(err)
+	}
+
+	// Another replica is draining.
+	client.Set(ctx, "outbound:bot:lease", "other-replica", time.Minute)
+	if err := queue.Drain(ctx, NewEvolutionClient(cfg)); err != nil {
+		t.Fatal(err)
+	}
+	if texts := evo.Texts(); len(texts) != 0 {
+		t.Errorf("sent %q while another replica held the queue", texts)
+	}
+
+	client.Del(ctx, "outbound:bot:lease")
+	if err := queue.Drain(ctx, NewEvolutionClient(cfg)); err != nil {
+		t.Fatal(err)
+	}
+	if texts := evo.Texts(); !slices.Equal(texts, []string{"hi"}) {
+		t.Errorf("sent %q once the lease was free, want [hi]", texts)
+	}
+	if client.Exists(ctx, "outbound:bot:lease").Val() != 0 {
+		t.Error("lease not released after the drain")
+	}
+}
@@ -0,0 +1,121 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/redis/go-redis/v9"
+
+	"hackathon/model"
+)
+
+// conversationOverrides holds per-conversation model parameters that take
+// precedence over the global configuration. Nil fields keep the default.
+type conversationOverrides struct {
+	Temperature *float32
+}
+
+// OverrideStore keeps the parameters users set with chat commands such as
+// /temp. Overrides expire together with the conversation history.
+type OverrideStore struct {
+	client *redis.Client
+}
+
+func NewOverrideStore(client *redis.Client) *OverrideStore {
+	return &OverrideStore{client: client}
+}
+
+func (s *OverrideStore) Get(ctx context.Context, user string) (conversationOverrides, error) {
+	var overrides conversationOverrides
+	if s == nil {
+		return overrides, nil
+	}
+
+	raw, err := s.client.HGet(ctx, s.key(user), "temperature").Result()
+	if err != nil {
+		if err == redis.Nil {
+			return overrides, nil
+		}
+		return overrides, err
+	}
+
+	temperature, err := strconv.ParseFloat(raw, 32)
+	if err != nil {
+		return overrides, fmt.Errorf("decode temperature override %q: %w", raw, err)
+	}
+	overrides.Temperature = new(float32)
+	*overrides.Temperature = float32(temperature)
+	return overrides, nil
+}
+
+// SetTemperature stores temperature for user, or removes the override when
+// temperature is nil.
+func (s *OverrideStore) SetTemperature(ctx context.Context, user string, temperature *float32) error {
+	key := s.key(user)
+	if temperature == nil {
+		return s.client.HDel(ctx, key, "temperature").Err()
+	}
+
+	_, err := s.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.HSet(ctx, key, "temperature", strconv.FormatFloat(float64(*temperature), 'f', -1, 32))
+		pipe.Expire(ctx, key, defaultConversationTTL)
+		return nil
+	})
+	return err
+}
+
+func (s *OverrideStore) key(user string) string {
+	return fmt.Sprintf("overrides:%s", user)
+}
+
+// requestTemperature converts an override into the request field. The client
+// omits a zero temperature, which the API would treat as its default of 1, so
+// zero is sent as the smallest positive value instead.
+func requestTemperature(temperature *float32) float32 {
+	if temperature == nil {
+		return 0
+	}
+	if *temperature == 0 {
+		return math.SmallestNonzeroFloat32
+	}
+	return *temperature
+}
+
+// temperatureCommand shows, sets or, with "default", resets the temperature
+// used for user's conversation.
+func temperatureCommand(ctx context.Context, b *Bot, cfg *model.Config, user, args string) (string, error) {
+	if b.Overrides == nil {
+		return "Per-conversation settings are not available.", nil
+	}
+
+	switch strings.ToLower(args) {
+	case "":
+		overrides, err := b.Overrides.Get(ctx, user)
+		if err != nil {
+			return "", err
+		}
+		if overrides.Temperature == nil {
+			return "Temperature: default.", nil
+		}
+		return fmt.Sprintf("Temperature: %g.", *overrides.Temperature), nil
+	case "default", "reset":
+		if err := b.Overrides.SetTemperature(ctx, user, nil); err != nil {
+			return "", err
+		}
+		return "Temperature reset to the default.", nil
+	}
+
+	parsed, err := strconv.ParseFloat(args, 32)
+	if err != nil || parsed < 0 || parsed > 2 {
+		return "Temperature must be a number between 0 and 2, or \"default\".", nil
+	}
+
+	temperature := float32(parsed)
+	if err := b.Overrides.SetTemperature(ctx, user, &temperature); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("Temperature set to %g.", temperature), nil
+}
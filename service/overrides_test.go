@@ -0,0 +1,72 @@
+package service
+
+import (
+	"context"
+	"slices"
+	"testing"
+)
+
+func TestTemperatureCommand(t *testing.T) {
+	evo, oa := newFakeEvolution(t), newFakeOpenAI(t)
+	cfg := testConfig(evo.URL)
+	_, client := newTestRedis(t)
+	bot := newTestBot(cfg, evo, oa)
+	bot.Overrides = NewOverrideStore(client)
+
+	send := func(id, text string) {
+		t.Helper()
+		msg, key := textMessage(id, text)
+		if err := bot.handleMessage(context.Background(), cfg, "", "", msg, key); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	send("in-1", "/temp 0.2")
+	send("in-2", "/temp")
+	send("in-3", "hi")
+	send("in-4", "/temp 0")
+	send("in-5", "hi")
+	send("in-6", "/temp 3")
+	send("in-7", "/temp default")
+	send("in-8", "hi")
+
+	wantTexts := []string{
+		"Temperature set to 0.2.",
+		"Temperature: 0.2.",
+		"Hello!",
+		"Temperature set to 0.",
+		"Hello!",
+		"Temperature must be a number between 0 and 2, or \"default\".",
+		"Temperature reset to the default.",
+		"Hello!",
+	}
+	if texts := evo.Texts(); !slices.Equal(texts, wantTexts) {
+		t.Errorf("sent %q, want %q", texts, wantTexts)
+	}
+
+	requests := oa.Requests()
+	if len(requests) != 3 {
+		t.Fatalf("%d completions, want one per plain message", len(requests))
+	}
+	// Zero is sent as the smallest positive value so the client does not
+	// drop it, and the reset leaves the field to the API default.
+	if got := requests[0].Temperature; got != 0.2 {
+		t.Errorf("temperature %g after /temp 0.2", got)
+	}
+	if got := requests[1].Temperature; got <= 0 || got > 1e-30 {
+		t.Errorf("temperature %g after /temp 0, want the smallest positive value", got)
+	}
+	if got := requests[2].Temperature; got != 0 {
+		t.Errorf("temperature %g after /temp default, want it omitted", got)
+	}
+
+	// The override belongs to the conversation it was set in.
+	other, key := textMessage("in-9", "/temp")
+	key.RemoteJID = "5511777777777@s.whatsapp.net"
+	if err := bot.handleMessage(context.Background(), cfg, "", "", other, key); err != nil {
+		t.Fatal(err)
+	}
+	if texts := evo.Texts(); texts[len(texts)-1] != "Temperature: default." {
+		t.Errorf("other conversation sees %q", texts[len(texts)-1])
+	}
+}
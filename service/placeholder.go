@@ -0,0 +1,115 @@
+package service
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+
+	"hackathon/model"
+)
+
+// thinkingPlaceholder sends cfg.ThinkingPlaceholder if the reply is not ready
+// within cfg.ThinkingPlaceholderDelay, and later replaces it with the reply,
+// or deletes it when there is no reply to show.
+type thinkingPlaceholder struct {
+	bot   *Bot
+	to    string
+	timer *time.Timer
+
+	mu       sync.Mutex
+	finished bool
+	key      *model.WebhookKey
+}
+
+// startPlaceholder arms the placeholder for to. It returns nil when no
+// placeholder is configured.
+func (b *Bot) startPlaceholder(ctx context.Context, cfg *model.Config, to string) *thinkingPlaceholder {
+	if cfg.ThinkingPlaceholder == "" {
+		return nil
+	}
+
+	p := &thinkingPlaceholder{bot: b, to: to}
+	p.timer = time.AfterFunc(cfg.ThinkingPlaceholderDelay, func() {
+		p.mu.Lock()
+		defer p.mu.Unlock()
+		if p.finished {
+			return
+		}
+
+		sent, err := b.Evolution.SendTextMessage(ctx, to, cfg.ThinkingPlaceholder)
+		if err != nil {
+			log.Printf("thinking placeholder to %s failed: %v", to, err)
+			return
+		}
+		if sent.Key.ID != "" {
+			b.sent.addID(sent.Key.ID, time.Now())
+			p.key = &sent.Key
+		}
+	})
+	return p
+}
+
+// stop prevents a pending placeholder from being sent and returns the key of
+// the placeholder if one already went out.
+func (p *thinkingPlaceholder) stop() *model.WebhookKey {
+	if p == nil {
+		return nil
+	}
+
+	p.timer.Stop()
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.finished = true
+	return p.key
+}
+
+// deliverReply sends reply, editing the placeholder in place when one was sent
+// and the reply includes text. If the edit fails the text is sent as a new
+// message. An audio-only reply has no text to put in the placeholder, so the
+// placeholder is deleted once the audio is sent.
+func (b *Bot) deliverReply(ctx context.Context, cfg *model.Config, to, inbound, reply string, placeholder *model.WebhookKey) error {
+	if placeholder == nil {
+		return b.sendReply(ctx, cfg, to, inbound, reply)
+	}
+	if cfg.ReplyMode == ReplyModeAudio {
+		err := b.sendReply(ctx, cfg, to, inbound, reply)
+		b.removePlaceholder(ctx, placeholder)
+		return err
+	}
+
+	err := b.editPlaceholder(ctx, to, reply, *placeholder)
+	if cfg.ReplyMode == ReplyModeBoth {
+		err = b.sendBoth(to, err, b.sendAudioReply(ctx, cfg, to, reply))
+	}
+	if err == nil {
+		b.Mirror.Mirror(to, inbound, reply)
+	}
+	return err
+}
+
+// removePlaceholder deletes a placeholder that will not be replaced by a
+// reply, so it does not linger in the chat. It runs on its own deadline since
+// it is often called after ctx has expired.
+func (b *Bot) removePlaceholder(ctx context.Context, placeholder *model.WebhookKey) {
+	if placeholder == nil {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), 10*time.Second)
+	defer cancel()
+	if err := b.Evolution.DeleteMessage(ctx, *placeholder); err != nil {
+		log.Printf("deleting placeholder %s failed: %v", placeholder.ID, err)
+	}
+}
+
+func (b *Bot) editPlaceholder(ctx context.Context, to, reply string, placeholder model.WebhookKey) error {
+	if _, err := b.Evolution.EditMessage(ctx, to, placeholder, reply); err != nil {
+		log.Printf("editing placeholder %s failed, sending reply separately: %v", placeholder.ID, err)
+		return b.sendText(ctx, to, reply)
+	}
+
+	b.sent.add(to, reply, time.Now())
+	return nil
+}
@@ -0,0 +1,174 @@
+package service
+
+import (
+	"context"
+	"net/http"
+	"slices"
+	"testing"
+	"time"
+)
+
+// placeholderBot returns a bot that sends a placeholder after 20ms.
+func placeholderBot(t *testing.T) (*Bot, *fakeEvolution, *fakeOpenAI) {
+	t.Helper()
+
+	evo, oa := newFakeEvolution(t), newFakeOpenAI(t)
+	cfg := testConfig(evo.URL)
+	cfg.ThinkingPlaceholder = "thinking..."
+	cfg.ThinkingPlaceholderDelay = 20 * time.Millisecond
+	return newTestBot(cfg, evo, oa), evo, oa
+}
+
+func deliverText(t *testing.T, bot *Bot, id, text string) {
+	t.Helper()
+
+	msg, key := textMessage(id, text)
+	if err := bot.handlePayload(context.Background(), upsertPayload(t, "bot", messageEntry(msg, key))); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestPlaceholderEditedIntoReply(t *testing.T) {
+	bot, evo, oa := placeholderBot(t)
+	oa.Handle("/v1/chat/completions", slowCompletion(100*time.Millisecond, "The answer."))
+
+	deliverText(t, bot, "in-1", "hi")
+
+	if texts := evo.Texts(); !slices.Equal(texts, []string{"thinking..."}) {
+		t.Errorf("sent %q, want only the placeholder", texts)
+	}
+	edits := evo.Requests("/chat/updateMessage")
+	if len(edits) != 1 {
+		t.Fatalf("%d edits, want 1", len(edits))
+	}
+	key, _ := edits[0].Body["key"].(map[string]any)
+	if edits[0].Body["text"] != "The answer." || key["id"] != "sent-1" {
+		t.Errorf("edit %v, want the placeholder sent-1 replaced with the reply", edits[0].Body)
+	}
+}
+
+func TestPlaceholderNotSentForFastReply(t *testing.T) {
+	bot, evo, _ := placeholderBot(t)
+
+	deliverText(t, bot, "in-1", "hi")
+
+	if texts := evo.Texts(); !slices.Equal(texts, []string{"Hello!"}) {
+		t.Errorf("sent %q, want only the reply", texts)
+	}
+	if edits := evo.Requests("/chat/updateMessage"); len(edits) != 0 {
+		t.Errorf("%d edits without a placeholder", len(edits))
+	}
+}
+
+func TestPlaceholderEditFailureSendsReply(t *testing.T) {
+	bot, evo, oa := placeholderBot(t)
+	oa.Handle("/v1/chat/completions", slowCompletion(100*time.Millisecond, "The answer."))
+	evo.Handle("/chat/updateMessage", func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, `{"message":"not found"}`, http.StatusNotFound)
+	})
+
+	deliverText(t, bot, "in-1", "hi")
+
+	if texts := evo.Texts(); !slices.Equal(texts, []string{"thinking...", "The answer."}) {
+		t.Errorf("sent %q, want the placeholder and then the reply", texts)
+	}
+}
+
+func TestPlaceholderReplacedWithLowConfidenceFallback(t *testing.T) {
+	bot, evo, oa := placeholderBot(t)
+	oa.Handle("/v1/chat/completions", slowCompletion(100*time.Millisecond, "A guess."))
+	bot.Configs.Load().ConfidenceThreshold = 0.5
+	bot.Confidence = fixedScore(0.1)
+
+	deliverText(t, bot, "in-1", "hi")
+
+	edits := evo.Requests("/chat/updateMessage")
+	if len(edits) != 1 || edits[0].Body["text"] != "not sure" {
+		t.Errorf("edits %v, want the placeholder replaced with the fallback", edits)
+	}
+}
+
+func TestPlaceholderDeletedOnLowConfidenceHandoff(t *testing.T) {
+	bot, evo, oa := placeholderBot(t)
+	oa.Handle("/v1/chat/completions", slowCompletion(100*time.Millisecond, "A guess."))
+	server, received := recordHandoffs(t)
+	cfg := bot.Configs.Load()
+	cfg.ConfidenceThreshold = 0.5
+	cfg.LowConfidenceAction = LowConfidenceHandoff
+	cfg.HandoffWebhookURL = server.URL
+	cfg.HandoffMessage = "a person will reply"
+	bot.Confidence = fixedScore(0.1)
+	bot.Handoff = NewHandoffNotifier(cfg, nil)
+
+	deliverText(t, bot, "in-1", "hi")
+
+	if len(received) != 1 {
+		t.Fatalf("%d handoff notifications, want 1", len(received))
+	}
+	if texts := evo.Texts(); !slices.Equal(texts, []string{"thinking...", "a person will reply"}) {
+		t.Errorf("sent %q, want the placeholder and the handoff message", texts)
+	}
+	if deletes := evo.Requests("/chat/deleteMessageForEveryone"); len(deletes) != 1 {
+		t.Errorf("%d deletes, want the placeholder deleted", len(deletes))
+	}
+}
+
+func TestPlaceholderDeletedWhenNoReply(t *testing.T) {
+	for name, handler := range map[string]http.HandlerFunc{
+		"generation failure": func(w http.ResponseWriter, r *http.Request) {
+			time.Sleep(100 * time.Millisecond)
+			http.Error(w, `{"error":{"message":"boom","type":"server_error"}}`, http.StatusInternalServerError)
+		},
+		"empty reply": slowCompletion(100*time.Millisecond, "  "),
+	} {
+		bot, evo, oa := placeholderBot(t)
+		oa.Handle("/v1/chat/completions", handler)
+
+		deliverText(t, bot, "in-1", "hi")
+
+		if texts := evo.Texts(); !slices.Equal(texts, []string{"thinking..."}) {
+			t.Errorf("%s: sent %q, want only the placeholder", name, texts)
+		}
+		deletes := evo.Requests("/chat/deleteMessageForEveryone")
+		if len(deletes) != 1 || deletes[0].Method != http.MethodDelete || deletes[0].Body["id"] != "sent-1" {
+			t.Errorf("%s: deletes %v, want the placeholder sent-1 deleted", name, deletes)
+		}
+	}
+}
+
+func TestPlaceholderNotResentOnRetry(t *testing.T) {
+	bot, evo, oa := placeholderBot(t)
+	oa.Handle("/v1/chat/completions", slowCompletion(100*time.Millisecond, "The answer."))
+	_, client := newTestRedis(t)
+	queue := NewGenerationRetryQueue(client, bot.Configs.Load())
+
+	msg, key := textMessage("in-1", "hi")
+	item := retryItem{Recipient: "5511999999999", Text: "hi", Message: msg, Key: key}
+	if err := queue.retry(context.Background(), bot, bot.Configs.Load(), item); err != nil {
+		t.Fatal(err)
+	}
+
+	if texts := evo.Texts(); !slices.Equal(texts, []string{"The answer."}) {
+		t.Errorf("sent %q on retry, want only the reply", texts)
+	}
+}
+
+func TestPlaceholderDeletedAfterAudioReply(t *testing.T) {
+	bot, evo, oa := placeholderBot(t)
+	speakInputs(oa)
+	oa.Handle("/v1/chat/completions", slowCompletion(100*time.Millisecond, "The answer."))
+	bot.Configs.Load().ReplyMode = ReplyModeAudio
+
+	deliverText(t, bot, "in-1", "hi")
+
+	if audio := sentAudio(t, evo); !slices.Equal(audio, []string{"The answer."}) {
+		t.Errorf("sent audio %q, want the reply", audio)
+	}
+	if texts := evo.Texts(); !slices.Equal(texts, []string{"thinking..."}) {
+		t.Errorf("sent %q, want only the placeholder as text", texts)
+	}
+	deletes := evo.Requests("/chat/deleteMessageForEveryone")
+	if len(deletes) != 1 || deletes[0].Body["id"] != "sent-1" {
+		t.Errorf("deletes %v, want the placeholder sent-1 deleted", deletes)
+	}
+}
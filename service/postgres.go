@@ -0,0 +1,250 @@
+package service
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	_ "github.com/lib/pq"
+	openai "github.com/sashabaranov/go-openai"
+
+	"hackathon/model"
+)
+
+const postgresSweepInterval = 10 * time.Minute
+
+const createConversationsTable = `
+CREATE TABLE IF NOT EXISTS conversations (
+	user_id      TEXT PRIMARY KEY,
+	messages     JSONB NOT NULL,
+	updated_at   TIMESTAMPTZ NOT NULL DEFAULT now(),
+	last_used_at TIMESTAMPTZ NOT NULL DEFAULT now()
+)`
+
+// addLastUsedColumn upgrades tables created before reads counted as use.
+const addLastUsedColumn = `
+ALTER TABLE conversations ADD COLUMN IF NOT EXISTS last_used_at TIMESTAMPTZ NOT NULL DEFAULT now()`
+
+type PostgresConversationStore struct {
+	db               *sql.DB
+	ttl              time.Duration
+	maxMessages      int
+	maxConversations int
+
+	stop     chan struct{}
+	stopOnce sync.Once
+	done     chan struct{}
+}
+
+func NewPostgresConversationStore(cfg *model.Config) (*PostgresConversationStore, error) {
+	db, err := sql.Open("postgres", cfg.PostgresDSN)
+	if err != nil {
+		return nil, fmt.Errorf("open postgres: %w", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if err := db.PingContext(ctx); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("connect postgres: %w", err)
+	}
+
+	if _, err := db.ExecContext(ctx, createConversationsTable); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("create conversations table: %w", err)
+	}
+	if _, err := db.ExecContext(ctx, addLastUsedColumn); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("migrate conversations table: %w", err)
+	}
+
+	s := &PostgresConversationStore{
+		db:               db,
+		ttl:              defaultConversationTTL,
+		maxMessages:      defaultMaxMessages,
+		maxConversations: cfg.MaxConversations,
+		stop:             make(chan struct{}),
+		done:             make(chan struct{}),
+	}
+	go s.sweepLoop(postgresSweepInterval)
+
+	return s, nil
+}
+
+func (s *PostgresConversationStore) Close() error {
+	if s == nil || s.db == nil {
+		return nil
+	}
+	s.stopOnce.Do(func() { close(s.stop) })
+	<-s.done
+	return s.db.Close()
+}
+
+func (s *PostgresConversationStore) GetConversation(ctx context.Context, user string) ([]openai.ChatCompletionMessage, error) {
+	// Reading counts as use, as in the Redis store, so a conversation that is
+	// read often but whose save failed is not evicted ahead of idle ones.
+	if s != nil && s.maxConversations > 0 {
+		return s.read(ctx, `UPDATE conversations SET last_used_at = now() WHERE user_id = $1 AND updated_at > $2 RETURNING messages`, user)
+	}
+	return s.PeekConversation(ctx, user)
+}
+
+func (s *PostgresConversationStore) PeekConversation(ctx context.Context, user string) ([]openai.ChatCompletionMessage, error) {
+	return s.read(ctx, `SELECT messages FROM conversations WHERE user_id = $1 AND updated_at > $2`, user)
+}
+
+// read runs query, which selects the messages of user's unexpired
+// conversation.
+func (s *PostgresConversationStore) read(ctx context.Context, query, user string) ([]openai.ChatCompletionMessage, error) {
+	if s == nil {
+		return nil, nil
+	}
+
+	var data []byte
+	err := s.db.QueryRowContext(ctx, query, user, time.Now().Add(-s.ttl)).Scan(&data)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	var messages []openai.ChatCompletionMessage
+	if len(data) == 0 {
+		return messages, nil
+	}
+
+	if err := json.Unmarshal(data, &messages); err != nil {
+		return nil, fmt.Errorf("decode conversation: %w", err)
+	}
+
+	return messages, nil
+}
+
+func (s *PostgresConversationStore) SaveConversation(ctx context.Context, user string, messages []openai.ChatCompletionMessage) error {
+	if s == nil {
+		return nil
+	}
+
+	messages = trimConversation(messages, s.maxMessages)
+
+	payload, err := json.Marshal(messages)
+	if err != nil {
+		return fmt.Errorf("encode conversation: %w", err)
+	}
+
+	_, err = s.db.ExecContext(ctx, `
+		INSERT INTO conversations (user_id, messages, updated_at, last_used_at)
+		VALUES ($1, $2, now(), now())
+		ON CONFLICT (user_id) DO UPDATE SET messages = EXCLUDED.messages, updated_at = EXCLUDED.updated_at, last_used_at = EXCLUDED.last_used_at`,
+		user, payload,
+	)
+	if err != nil {
+		return err
+	}
+
+	return s.evict(ctx)
+}
+
+func (s *PostgresConversationStore) ListConversations(ctx context.Context, cursor string, limit int) ([]string, string, error) {
+	if s == nil {
+		return nil, "", nil
+	}
+
+	rows, err := s.db.QueryContext(ctx,
+		`SELECT user_id FROM conversations WHERE user_id > $1 AND updated_at > $2 ORDER BY user_id LIMIT $3`,
+		cursor, time.Now().Add(-s.ttl), limit,
+	)
+	if err != nil {
+		return nil, "", err
+	}
+	defer rows.Close()
+
+	var users []string
+	for rows.Next() {
+		var user string
+		if err := rows.Scan(&user); err != nil {
+			return nil, "", err
+		}
+		users = append(users, user)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, "", err
+	}
+
+	if len(users) < limit {
+		return users, "", nil
+	}
+	return users, users[len(users)-1], nil
+}
+
+func (s *PostgresConversationStore) ClearConversation(ctx context.Context, user string) error {
+	if s == nil {
+		return nil
+	}
+	_, err := s.db.ExecContext(ctx, `DELETE FROM conversations WHERE user_id = $1`, user)
+	return err
+}
+
+// evict drops the least recently used conversations, by last load or save,
+// once more than maxConversations are stored.
+func (s *PostgresConversationStore) evict(ctx context.Context) error {
+	if s.maxConversations <= 0 {
+		return nil
+	}
+
+	rows, err := s.db.QueryContext(ctx, `
+		DELETE FROM conversations WHERE user_id IN (
+			SELECT user_id FROM conversations ORDER BY last_used_at DESC OFFSET $1
+		) RETURNING user_id`,
+		s.maxConversations,
+	)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var user string
+		if err := rows.Scan(&user); err != nil {
+			return err
+		}
+		conversationEvictions.Add(1)
+		log.Printf("conversation evicted for %s: store limit of %d reached", user, s.maxConversations)
+	}
+
+	return rows.Err()
+}
+
+// sweepLoop periodically deletes conversations older than the TTL, mirroring
+// the key expiry the Redis backend gets for free.
+func (s *PostgresConversationStore) sweepLoop(interval time.Duration) {
+	defer close(s.done)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-s.stop:
+			return
+		case <-ticker.C:
+			if err := s.sweep(context.Background()); err != nil {
+				log.Printf("conversation sweep failed: %v", err)
+			}
+		}
+	}
+}
+
+func (s *PostgresConversationStore) sweep(ctx context.Context) error {
+	ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	defer cancel()
+
+	_, err := s.db.ExecContext(ctx, `DELETE FROM conversations WHERE updated_at <= $1`, time.Now().Add(-s.ttl))
+	return err
+}
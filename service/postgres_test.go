@@ -0,0 +1,186 @@
+package service
+
+import (
+	"context"
+	"database/sql/driver"
+	"encoding/json"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	openai "github.com/sashabaranov/go-openai"
+)
+
+func newMockPostgresStore(t *testing.T, maxConversations int) (*PostgresConversationStore, sqlmock.Sqlmock) {
+	t.Helper()
+
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	return &PostgresConversationStore{
+		db:               db,
+		ttl:              defaultConversationTTL,
+		maxMessages:      defaultMaxMessages,
+		maxConversations: maxConversations,
+	}, mock
+}
+
+// withinTTL matches the cutoff argument of a TTL-bound query.
+type withinTTL struct{}
+
+func (withinTTL) Match(v driver.Value) bool {
+	cutoff, ok := v.(time.Time)
+	return ok && time.Since(cutoff) > defaultConversationTTL-time.Minute && time.Since(cutoff) < defaultConversationTTL+time.Minute
+}
+
+func TestPostgresSaveConversation(t *testing.T) {
+	store, mock := newMockPostgresStore(t, 0)
+
+	messages := []openai.ChatCompletionMessage{{Role: openai.ChatMessageRoleUser, Content: "hi"}}
+	payload, _ := json.Marshal(messages)
+	mock.ExpectExec(regexp.QuoteMeta("INSERT INTO conversations")).
+		WithArgs("5511999999999", payload).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	if err := store.SaveConversation(context.Background(), "5511999999999", messages); err != nil {
+		t.Fatal(err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestPostgresSaveConversationTrimsAndEvicts(t *testing.T) {
+	store, mock := newMockPostgresStore(t, 2)
+
+	var messages []openai.ChatCompletionMessage
+	for i := 0; i < defaultMaxMessages+5; i++ {
+		messages = append(messages, openai.ChatCompletionMessage{Role: openai.ChatMessageRoleUser, Content: "turn"})
+	}
+	trimmed, _ := json.Marshal(messages[5:])
+
+	mock.ExpectExec(regexp.QuoteMeta("INSERT INTO conversations")).
+		WithArgs("user", trimmed).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectQuery(regexp.QuoteMeta("DELETE FROM conversations WHERE user_id IN")).
+		WithArgs(2).
+		WillReturnRows(sqlmock.NewRows([]string{"user_id"}).AddRow("oldest"))
+
+	if err := store.SaveConversation(context.Background(), "user", messages); err != nil {
+		t.Fatal(err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestPostgresGetConversation(t *testing.T) {
+	store, mock := newMockPostgresStore(t, 0)
+
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT messages FROM conversations WHERE user_id = $1 AND updated_at > $2")).
+		WithArgs("user", withinTTL{}).
+		WillReturnRows(sqlmock.NewRows([]string{"messages"}).AddRow(`[{"role":"user","content":"hi"},{"role":"assistant","content":"hello"}]`))
+
+	messages, err := store.GetConversation(context.Background(), "user")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(messages) != 2 || messages[1].Content != "hello" {
+		t.Errorf("got %+v", messages)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestPostgresGetExpiredConversation(t *testing.T) {
+	store, mock := newMockPostgresStore(t, 0)
+
+	// An expired row is filtered out by the updated_at cutoff.
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT messages FROM conversations")).
+		WithArgs("user", withinTTL{}).
+		WillReturnRows(sqlmock.NewRows([]string{"messages"}))
+
+	messages, err := store.GetConversation(context.Background(), "user")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if messages != nil {
+		t.Errorf("expired conversation returned %+v", messages)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestPostgresSweepDeletesExpired(t *testing.T) {
+	store, mock := newMockPostgresStore(t, 0)
+
+	mock.ExpectExec(regexp.QuoteMeta("DELETE FROM conversations WHERE updated_at <= $1")).
+		WithArgs(withinTTL{}).
+		WillReturnResult(sqlmock.NewResult(0, 3))
+
+	if err := store.sweep(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestPostgresGetConversationCountsAsUse(t *testing.T) {
+	store, mock := newMockPostgresStore(t, 2)
+
+	mock.ExpectQuery(regexp.QuoteMeta("UPDATE conversations SET last_used_at = now() WHERE user_id = $1 AND updated_at > $2 RETURNING messages")).
+		WithArgs("user", withinTTL{}).
+		WillReturnRows(sqlmock.NewRows([]string{"messages"}).AddRow(`[{"role":"user","content":"hi"}]`))
+
+	messages, err := store.GetConversation(context.Background(), "user")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(messages) != 1 {
+		t.Errorf("got %+v", messages)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestPostgresEvictsLeastRecentlyUsed(t *testing.T) {
+	store, mock := newMockPostgresStore(t, 2)
+
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT user_id FROM conversations ORDER BY last_used_at DESC OFFSET $1")).
+		WithArgs(2).
+		WillReturnRows(sqlmock.NewRows([]string{"user_id"}))
+
+	if err := store.evict(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestPostgresPeekConversationLeavesUsage(t *testing.T) {
+	store, mock := newMockPostgresStore(t, 2)
+
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT messages FROM conversations WHERE user_id = $1 AND updated_at > $2")).
+		WithArgs("user", withinTTL{}).
+		WillReturnRows(sqlmock.NewRows([]string{"messages"}).AddRow(`[{"role":"user","content":"hi"}]`))
+
+	messages, err := store.PeekConversation(context.Background(), "user")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(messages) != 1 {
+		t.Errorf("got %+v", messages)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
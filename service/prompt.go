@@ -0,0 +1,41 @@
+package service
+
+import (
+	"fmt"
+	"time"
+
+	openai "github.com/sashabaranov/go-openai"
+
+	"hackathon/model"
+)
+
+// promptMessages returns the messages sent to the model for conversation,
+// asking for a reply in language when it is set. Messages added here are
+// request-only context and are never persisted with the conversation.
+func promptMessages(cfg *model.Config, conversation []openai.ChatCompletionMessage, now time.Time, language string) []openai.ChatCompletionMessage {
+	var system []openai.ChatCompletionMessage
+	if cfg.PromptDateTime {
+		system = append(system, openai.ChatCompletionMessage{
+			Role:    openai.ChatMessageRoleSystem,
+			Content: dateTimePrompt(now.In(cfg.PromptLocation)),
+		})
+	}
+	if language != "" {
+		system = append(system, openai.ChatCompletionMessage{
+			Role:    openai.ChatMessageRoleSystem,
+			Content: fmt.Sprintf("Always reply in %s.", language),
+		})
+	}
+	if len(system) == 0 {
+		return conversation
+	}
+
+	return append(system, conversation...)
+}
+
+// dateTimePrompt tells the model the current local date and time, spelling
+// out the weekday and zone so relative terms like "today" resolve correctly.
+func dateTimePrompt(now time.Time) string {
+	return fmt.Sprintf("Current date and time: %s (%s, UTC%s).",
+		now.Format("Monday, 2 January 2006, 15:04"), now.Location(), now.Format("-07:00"))
+}
@@ -0,0 +1,40 @@
+package service
+
+import (
+	"testing"
+	"time"
+
+	openai "github.com/sashabaranov/go-openai"
+)
+
+func TestPromptMessagesDateTime(t *testing.T) {
+	saoPaulo, err := time.LoadLocation("America/Sao_Paulo")
+	if err != nil {
+		t.Skip(err)
+	}
+	now := time.Date(2024, 6, 3, 2, 30, 0, 0, time.UTC)
+	conversation := []openai.ChatCompletionMessage{{Role: openai.ChatMessageRoleUser, Content: "what day is it?"}}
+
+	cfg := testConfig("")
+	cfg.PromptDateTime = true
+	cfg.PromptLocation = saoPaulo
+
+	messages := promptMessages(cfg, conversation, now, "")
+	if len(messages) != 2 || messages[1].Content != conversation[0].Content {
+		t.Fatalf("messages %+v, want the date prompt before the conversation", messages)
+	}
+	want := "Current date and time: Sunday, 2 June 2024, 23:30 (America/Sao_Paulo, UTC-03:00)."
+	if messages[0].Role != openai.ChatMessageRoleSystem || messages[0].Content != want {
+		t.Errorf("date prompt %q, want %q", messages[0].Content, want)
+	}
+
+	cfg.PromptDateTime = false
+	if messages := promptMessages(cfg, conversation, now, ""); len(messages) != 1 {
+		t.Errorf("messages %+v, want no date prompt when disabled", messages)
+	}
+
+	messages = promptMessages(cfg, conversation, now, "Portuguese")
+	if len(messages) != 2 || messages[0].Content != "Always reply in Portuguese." {
+		t.Errorf("messages %+v, want only the language prompt", messages)
+	}
+}
@@ -0,0 +1,38 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// DailyQuota counts messages per user per calendar day in Redis. Counters
+// expire shortly after the local midnight that ends their day.
+type DailyQuota struct {
+	client *redis.Client
+}
+
+func NewDailyQuota(client *redis.Client) *DailyQuota {
+	return &DailyQuota{client: client}
+}
+
+// Consume records one message for user on the day containing now in loc and
+// returns how many messages the user has sent that day, including this one.
+func (q *DailyQuota) Consume(ctx context.Context, user string, loc *time.Location, now time.Time) (int64, error) {
+	local := now.In(loc)
+	day := local.Format("2006-01-02")
+	nextMidnight := time.Date(local.Year(), local.Month(), local.Day()+1, 0, 0, 0, 0, loc)
+
+	key := fmt.Sprintf("quota:%s:%s", user, day)
+
+	pipe := q.client.TxPipeline()
+	count := pipe.Incr(ctx, key)
+	pipe.ExpireAt(ctx, key, nextMidnight.Add(time.Hour))
+	if _, err := pipe.Exec(ctx); err != nil {
+		return 0, err
+	}
+
+	return count.Val(), nil
+}
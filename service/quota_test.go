@@ -0,0 +1,109 @@
+package service
+
+import (
+	"context"
+	"slices"
+	"testing"
+	"time"
+
+	"hackathon/model"
+)
+
+func TestDailyQuotaResetsAtLocalMidnight(t *testing.T) {
+	redisServer, client := newTestRedis(t)
+	quota := NewDailyQuota(client)
+	loc := time.FixedZone("UTC-3", -3*60*60)
+	ctx := context.Background()
+
+	// 23:59 local time, then one minute later on the next local day.
+	beforeMidnight := time.Date(2024, 6, 3, 23, 59, 0, 0, loc)
+	redisServer.SetTime(beforeMidnight)
+	for want := int64(1); want <= 3; want++ {
+		count, err := quota.Consume(ctx, "user", loc, beforeMidnight)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if count != want {
+			t.Fatalf("count = %d, want %d", count, want)
+		}
+	}
+
+	afterMidnight := beforeMidnight.Add(time.Minute)
+	redisServer.SetTime(afterMidnight)
+	count, err := quota.Consume(ctx, "user", loc, afterMidnight)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 1 {
+		t.Errorf("count after local midnight = %d, want a fresh day", count)
+	}
+
+	// The previous day's counter expires an hour after its midnight. TTLs in
+	// miniredis only run down with FastForward, counting from when they were
+	// set at 23:59.
+	redisServer.FastForward(time.Hour + time.Minute)
+	if keys := redisServer.Keys(); !slices.Equal(keys, []string{"quota:user:2024-06-04"}) {
+		t.Errorf("keys after expiry = %q", keys)
+	}
+}
+
+func TestHandleMessageEnforcesDailyQuota(t *testing.T) {
+	evo, oa := newFakeEvolution(t), newFakeOpenAI(t)
+	cfg := testConfig(evo.URL)
+	cfg.DailyMessageQuota = 1
+	cfg.QuotaExceededMessage = "Come back tomorrow."
+
+	redisServer, client := newTestRedis(t)
+	bot := newTestBot(cfg, evo, oa)
+	bot.Quota = NewDailyQuota(client)
+
+	now := time.Date(2024, 6, 3, 23, 0, 0, 0, time.UTC)
+	bot.now = func() time.Time { return now }
+	redisServer.SetTime(now)
+
+	ctx := context.Background()
+	for i, id := range []string{"in-1", "in-2", "in-3"} {
+		if i == 2 {
+			now = now.Add(2 * time.Hour)
+			redisServer.SetTime(now)
+		}
+		msg, key := textMessage(id, "hi")
+		if err := bot.handleMessage(ctx, cfg, "", "", msg, key); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	want := []string{"Hello!", "Come back tomorrow.", "Hello!"}
+	if texts := evo.Texts(); !slices.Equal(texts, want) {
+		t.Errorf("sent %q, want %q", texts, want)
+	}
+}
+
+func TestDailyQuotaPerGroupParticipant(t *testing.T) {
+	evo, oa := newFakeEvolution(t), newFakeOpenAI(t)
+	cfg := testConfig(evo.URL)
+	cfg.DailyMessageQuota = 1
+	cfg.QuotaExceededMessage = "Come back tomorrow."
+
+	_, client := newTestRedis(t)
+	bot := newTestBot(cfg, evo, oa)
+	bot.Quota = NewDailyQuota(client)
+
+	const group = "120363000000000000@g.us"
+	ctx := context.Background()
+	for _, key := range []model.WebhookKey{
+		{RemoteJID: group, Participant: "5511888888888@s.whatsapp.net", ID: "in-1"},
+		{RemoteJID: group, Participant: "5511888888888@s.whatsapp.net", ID: "in-2"},
+		{RemoteJID: group, Participant: "5511777777777@s.whatsapp.net", ID: "in-3"},
+	} {
+		if err := bot.handleMessage(ctx, cfg, "", "", model.WebhookMessage{Conversation: "hi"}, key); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// The second participant still has their own message for the day.
+	want := []string{"Hello!", "Come back tomorrow.", "Hello!"}
+	if texts := evo.Texts(); !slices.Equal(texts, want) {
+		t.Errorf("sent %q, want %q", texts, want)
+	}
+}
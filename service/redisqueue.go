@@ -0,0 +1,148 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"math/rand/v2"
+	"strconv"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// queueLeaseTTL is how long a drain lease lasts without renewal. The holder
+// renews it while it works, so the TTL only matters when a replica dies
+// mid-drain.
+const queueLeaseTTL = 30 * time.Second
+
+// errStopDrain ends a drain without error, leaving the current entry queued.
+var errStopDrain = errors.New("stop drain")
+
+// pushScript appends ARGV[1] to the list unless it already holds ARGV[2]
+// entries, and resets the list's expiry to ARGV[3] milliseconds. Checking the
+// length in the same script keeps concurrent pushes from overshooting the cap.
+var pushScript = redis.NewScript(`
+if redis.call("LLEN", KEYS[1]) >= tonumber(ARGV[2]) then
+	return 0
+end
+redis.call("RPUSH", KEYS[1], ARGV[1])
+redis.call("PEXPIRE", KEYS[1], ARGV[3])
+return 1
+`)
+
+// renewLeaseScript extends the lease in KEYS[1] to ARGV[2] milliseconds if it
+// is still held with token ARGV[1].
+var renewLeaseScript = redis.NewScript(`
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("PEXPIRE", KEYS[1], ARGV[2])
+end
+return 0
+`)
+
+// releaseLeaseScript deletes the lease in KEYS[1] if it is still held with
+// token ARGV[1].
+var releaseLeaseScript = redis.NewScript(`
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("DEL", KEYS[1])
+end
+return 0
+`)
+
+// redisQueue is a bounded FIFO of JSON entries kept in a Redis list. Entries
+// are drained under a lease so only one process, across every replica sharing
+// the Redis server, works through the list at a time.
+type redisQueue struct {
+	client  *redis.Client
+	key     string
+	maxSize int64
+	maxAge  time.Duration
+}
+
+// push appends entry to the queue. It reports false, without error, when the
+// queue is full.
+func (q *redisQueue) push(ctx context.Context, entry any) (bool, error) {
+	payload, err := json.Marshal(entry)
+	if err != nil {
+		return false, err
+	}
+
+	pushed, err := pushScript.Run(ctx, q.client, []string{q.key}, payload, q.maxSize, q.maxAge.Milliseconds()).Int()
+	if err != nil {
+		return false, err
+	}
+	return pushed == 1, nil
+}
+
+// drain calls handle for each entry, oldest first. An entry is only removed
+// once handle returns nil, so a crash mid-drain handles it again rather than
+// losing it. Any other result stops the drain with the entry left at the
+// head; errStopDrain does so without reporting an error. drain returns
+// immediately when another process holds the lease.
+func (q *redisQueue) drain(ctx context.Context, handle func(ctx context.Context, payload []byte) error) error {
+	leaseKey := q.key + ":lease"
+	token := strconv.FormatUint(rand.Uint64(), 36)
+
+	acquired, err := q.client.SetNX(ctx, leaseKey, token, queueLeaseTTL).Result()
+	if err != nil || !acquired {
+		return err
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer func() {
+		cancel()
+		releaseCtx, cancelRelease := context.WithTimeout(context.WithoutCancel(ctx), 5*time.Second)
+		defer cancelRelease()
+		if err := releaseLeaseScript.Run(releaseCtx, q.client, []string{leaseKey}, token).Err(); err != nil {
+			log.Printf("queue %s: lease release failed: %v", q.key, err)
+		}
+	}()
+	go q.keepLease(ctx, cancel, leaseKey, token)
+
+	for {
+		payload, err := q.client.LIndex(ctx, q.key, 0).Bytes()
+		if err != nil {
+			if err == redis.Nil {
+				return nil
+			}
+			return err
+		}
+
+		if err := handle(ctx, payload); err != nil {
+			if errors.Is(err, errStopDrain) {
+				return nil
+			}
+			return err
+		}
+
+		if err := q.client.LPop(ctx, q.key).Err(); err != nil && err != redis.Nil {
+			return err
+		}
+	}
+}
+
+// keepLease renews the lease until ctx is done, cancelling the drain if the
+// lease was lost to another process.
+func (q *redisQueue) keepLease(ctx context.Context, cancel context.CancelFunc, leaseKey, token string) {
+	ticker := time.NewTicker(queueLeaseTTL / 3)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			renewed, err := renewLeaseScript.Run(ctx, q.client, []string{leaseKey}, token, queueLeaseTTL.Milliseconds()).Int()
+			if err != nil {
+				log.Printf("queue %s: lease renewal failed: %v", q.key, err)
+				continue
+			}
+			if renewed == 0 {
+				log.Printf("queue %s: lease lost, stopping drain", q.key)
+				cancel()
+				return
+			}
+		}
+	}
+}
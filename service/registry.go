@@ -0,0 +1,160 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"sync"
+
+	"hackathon/model"
+)
+
+// Handler processes one webhook event.
+type Handler func(ctx context.Context, b *Bot, cfg *model.Config, payload model.WebhookPayload) error
+
+// MessageHandler processes one inbound message of a given message type, such
+// as "conversation" or "reactionMessage".
+type MessageHandler func(ctx context.Context, b *Bot, cfg *model.Config, sender string, entry model.MessagesUpsertEntry) error
+
+// HandlerRegistry maps webhook events and message types to their handlers so
+// custom flows can be plugged in without touching the dispatch code.
+// Messages whose type has no handler go through the default reply pipeline.
+type HandlerRegistry struct {
+	mu       sync.RWMutex
+	events   map[string]Handler
+	messages map[string]MessageHandler
+}
+
+func NewHandlerRegistry() *HandlerRegistry {
+	return &HandlerRegistry{
+		events:   make(map[string]Handler),
+		messages: make(map[string]MessageHandler),
+	}
+}
+
+// RegisterDefaultHandlers installs the built-in event handlers.
+func RegisterDefaultHandlers(r *HandlerRegistry) {
+	r.RegisterEvent("messages.upsert", handleMessagesUpsert)
+	r.RegisterEvent("message_create", handleMessageCreate)
+	r.RegisterEvent("connection.update", handleConnectionUpdate)
+}
+
+// RegisterEvent sets the handler for event, replacing any previous one.
+func (r *HandlerRegistry) RegisterEvent(event string, h Handler) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.events[event] = h
+}
+
+// RegisterMessageType sets the handler for inbound messages of messageType,
+// replacing any previous one.
+func (r *HandlerRegistry) RegisterMessageType(messageType string, h MessageHandler) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.messages[messageType] = h
+}
+
+func (r *HandlerRegistry) event(event string) (Handler, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	h, ok := r.events[event]
+	return h, ok
+}
+
+func (r *HandlerRegistry) message(messageType string) (MessageHandler, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	h, ok := r.messages[messageType]
+	return h, ok
+}
+
+// dispatchMessage routes an inbound message to the handler registered for its
+// type, falling back to the default reply pipeline.
+func (b *Bot) dispatchMessage(ctx context.Context, cfg *model.Config, sender string, entry model.MessagesUpsertEntry) error {
+	entry.Message = entryMessage(entry)
+	if h, ok := b.Handlers.message(entry.MessageType); ok {
+		return h(ctx, b, cfg, sender, entry)
+	}
+	return b.processWebhookMessage(ctx, cfg, sender, entry.PushName, entry.Message, entry.Key)
+}
+
+// entryMessage returns the message of entry, unwrapped from its
+// ephemeralMessage envelope, with the entry's chat metadata attached.
+// Evolution reports metadata such as the disappearing-messages timer next to
+// the message rather than inside it.
+func entryMessage(entry model.MessagesUpsertEntry) model.WebhookMessage {
+	msg := entry.Message
+	if msg.EphemeralMessage != nil && msg.EphemeralMessage.Message != nil {
+		inner := *msg.EphemeralMessage.Message
+		if inner.ContextInfo == nil {
+			inner.ContextInfo = msg.ContextInfo
+		}
+		msg = inner
+	}
+	if msg.ContextInfo == nil {
+		msg.ContextInfo = entry.ContextInfo
+	}
+	return msg
+}
+
+func handleMessagesUpsert(ctx context.Context, b *Bot, cfg *model.Config, payload model.WebhookPayload) error {
+	var container struct {
+		Messages []model.MessagesUpsertEntry `json:"messages"`
+	}
+
+	if err := json.Unmarshal(payload.Data, &container); err == nil && len(container.Messages) > 0 {
+		for _, entry := range container.Messages {
+			if entry.Key.FromMe {
+				b.recordHumanReply(ctx, cfg, payload.Sender, entryMessage(entry), entry.Key)
+				continue
+			}
+			if err := b.dispatchMessage(ctx, cfg, payload.Sender, entry); err != nil {
+				log.Printf("process messages.upsert entry error: %v", err)
+			}
+		}
+		return nil
+	}
+
+	var single model.MessagesUpsertEntry
+	if err := json.Unmarshal(payload.Data, &single); err != nil {
+		return err
+	}
+
+	if single.Key.FromMe {
+		b.recordHumanReply(ctx, cfg, payload.Sender, entryMessage(single), single.Key)
+		return nil
+	}
+
+	return b.dispatchMessage(ctx, cfg, payload.Sender, single)
+}
+
+func handleMessageCreate(ctx context.Context, b *Bot, cfg *model.Config, payload model.WebhookPayload) error {
+	var data model.WebhookData
+	if err := json.Unmarshal(payload.Data, &data); err != nil {
+		return err
+	}
+
+	if data.Key.FromMe {
+		b.recordHumanReply(ctx, cfg, payload.Sender, data.Message, data.Key)
+		return nil
+	}
+
+	return b.dispatchMessage(ctx, cfg, payload.Sender, model.MessagesUpsertEntry{
+		Key:         data.Key,
+		Message:     data.Message,
+		MessageType: data.MessageType,
+	})
+}
+
+func handleConnectionUpdate(ctx context.Context, b *Bot, cfg *model.Config, payload model.WebhookPayload) error {
+	var data model.ConnectionUpdateData
+	if err := json.Unmarshal(payload.Data, &data); err != nil {
+		return err
+	}
+
+	log.Printf("evolution connection state: %s", data.State)
+	if data.State == "open" {
+		b.drainOutbound()
+	}
+	return nil
+}
@@ -0,0 +1,61 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"hackathon/model"
+)
+
+func TestHandlerRegistryCustomEvent(t *testing.T) {
+	evo := newFakeEvolution(t)
+	bot := newTestBot(testConfig(evo.URL), evo, nil)
+
+	var got []model.WebhookPayload
+	bot.Handlers.RegisterEvent("groups.update", func(ctx context.Context, b *Bot, cfg *model.Config, payload model.WebhookPayload) error {
+		got = append(got, payload)
+		return nil
+	})
+
+	body, _ := json.Marshal(model.WebhookPayload{Event: "groups.update", Instance: "bot", Data: json.RawMessage(`{"id":"group"}`)})
+	if err := bot.handlePayload(context.Background(), body); err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 1 || string(got[0].Data) != `{"id":"group"}` {
+		t.Errorf("custom event handler got %v, want the groups.update payload", got)
+	}
+}
+
+func TestHandlerRegistryCustomMessageType(t *testing.T) {
+	evo, oa := newFakeEvolution(t), newFakeOpenAI(t)
+	bot := newTestBot(testConfig(evo.URL), evo, oa)
+
+	var got []model.MessagesUpsertEntry
+	bot.Handlers.RegisterMessageType("locationMessage", func(ctx context.Context, b *Bot, cfg *model.Config, sender string, entry model.MessagesUpsertEntry) error {
+		got = append(got, entry)
+		return nil
+	})
+
+	location, key := textMessage("in-1", "")
+	entry := messageEntry(location, key)
+	entry.MessageType = "locationMessage"
+	if err := bot.handlePayload(context.Background(), upsertPayload(t, "bot", entry)); err != nil {
+		t.Fatal(err)
+	}
+
+	// Types without a handler still go through the reply pipeline.
+	text, key := textMessage("in-2", "hi")
+	entry = messageEntry(text, key)
+	entry.MessageType = "conversation"
+	if err := bot.handlePayload(context.Background(), upsertPayload(t, "bot", entry)); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(got) != 1 || got[0].Key.ID != "in-1" {
+		t.Errorf("custom message handler got %v, want only the location message", got)
+	}
+	if texts := evo.Texts(); len(texts) != 1 || len(oa.Requests()) != 1 {
+		t.Errorf("sent %q, want one reply to the text message", texts)
+	}
+}
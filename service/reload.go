@@ -0,0 +1,130 @@
+package service
+
+import (
+	"fmt"
+	"log"
+	"reflect"
+	"slices"
+	"sync"
+	"sync/atomic"
+
+	"hackathon/model"
+)
+
+// staticConfigFields lists settings that are bound to long-lived clients at
+// startup and therefore cannot change on reload.
+var staticConfigFields = []string{
+	"EvolutionAPIURL",
+	"EvolutionAPIKey",
+	"EvolutionInstance",
+	"EvolutionRateLimitCooldown",
+	"EvolutionMaxRetries",
+	"RetryJitter",
+	"RetryBaseDelay",
+	"RetryMaxDelay",
+	"OpenAIAPIKey",
+	"OpenAIOrgID",
+	"OpenAIProjectID",
+	"ConfidenceGate",
+	"GenerationRetryMax",
+	"GenerationRetryMaxAge",
+	"GenerationRetryInterval",
+	"RedisAddr",
+	"RedisPassword",
+	"RedisDB",
+	"StoreBackend",
+	"PostgresDSN",
+	"MaxConversations",
+	"HandoffWebhookURL",
+	"OutboundQueueMax",
+	"OutboundQueueMaxAge",
+	"ReplyCacheTTL",
+	"KeepAliveInterval",
+	"WebhookHistory",
+	"TranscribeMaxConcurrency",
+	"MediaAllowedHosts",
+	"MirrorWebhookURL",
+	"EvolutionTLSCertFile",
+	"EvolutionTLSKeyFile",
+	"EvolutionTLSCAFile",
+}
+
+// ConfigHolder hands out the active configuration and lets it be swapped at
+// runtime without restarting the server.
+type ConfigHolder struct {
+	current atomic.Pointer[model.Config]
+	mu      sync.Mutex
+}
+
+func NewConfigHolder(cfg *model.Config) *ConfigHolder {
+	h := &ConfigHolder{}
+	h.current.Store(cfg)
+	return h
+}
+
+func (h *ConfigHolder) Load() *model.Config {
+	return h.current.Load()
+}
+
+// Reload re-reads the configuration from the environment and swaps it in.
+// An invalid configuration is rejected and the active one is kept.
+func (h *ConfigHolder) Reload() error {
+	next, err := LoadConfig()
+	if err != nil {
+		return err
+	}
+
+	h.Swap(next)
+	return nil
+}
+
+// SetModel switches the OpenAI model used for replies, rejecting models not in
+// OPENAI_ALLOWED_MODELS. The switch lasts until the next reload, which restores
+// OPENAI_MODEL.
+func (h *ConfigHolder) SetModel(modelID string) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	prev := h.current.Load()
+	if !slices.Contains(prev.OpenAIAllowedModels, modelID) {
+		return fmt.Errorf("model %q is not allowed", modelID)
+	}
+
+	next := *prev
+	next.OpenAIModel = modelID
+	h.current.Store(&next)
+
+	log.Printf("config: OpenAIModel changed from %s to %s", prev.OpenAIModel, modelID)
+	return nil
+}
+
+// Swap replaces the active configuration, keeping static fields at their
+// current values, and logs every setting that changed.
+func (h *ConfigHolder) Swap(next *model.Config) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	prev := h.current.Load()
+	prevValue := reflect.ValueOf(prev).Elem()
+	nextValue := reflect.ValueOf(next).Elem()
+
+	for _, name := range staticConfigFields {
+		field := nextValue.FieldByName(name)
+		if !reflect.DeepEqual(field.Interface(), prevValue.FieldByName(name).Interface()) {
+			log.Printf("config reload: %s cannot be changed at runtime, keeping previous value", name)
+			field.Set(prevValue.FieldByName(name))
+		}
+	}
+	// EvolutionTLS is built from the file paths pinned above. Two builds from
+	// the same files never compare equal, so the startup one is kept as is.
+	next.EvolutionTLS = prev.EvolutionTLS
+
+	configType := nextValue.Type()
+	for i := 0; i < configType.NumField(); i++ {
+		if !reflect.DeepEqual(prevValue.Field(i).Interface(), nextValue.Field(i).Interface()) {
+			log.Printf("config reload: %s changed", configType.Field(i).Name)
+		}
+	}
+
+	h.current.Store(next)
+}
@@ -0,0 +1,121 @@
+package service
+
+import (
+	"bytes"
+	"crypto/x509"
+	"log"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+// setRequiredEnv sets the variables LoadConfig refuses to start without.
+func setRequiredEnv(t *testing.T) {
+	t.Helper()
+
+	t.Setenv("EVOLUTION_API_URL", "http://evolution.test")
+	t.Setenv("EVOLUTION_API_KEY", "evolution-key")
+	t.Setenv("EVOLUTION_INSTANCE", "bot")
+	t.Setenv("OPENAI_API_KEY", "openai-key")
+	t.Setenv("REDIS_ADDR", "localhost:6379")
+}
+
+func TestConfigHolderSwap(t *testing.T) {
+	prev := testConfig("http://evolution.test")
+	prev.RedisAddr = "localhost:6379"
+	prev.TimeoutMessage = "old timeout"
+	holder := NewConfigHolder(prev)
+
+	next := testConfig("http://evolution.test")
+	next.RedisAddr = "other:6379"
+	next.TimeoutMessage = "new timeout"
+	next.RetryBaseDelay = time.Second
+	holder.Swap(next)
+
+	got := holder.Load()
+	if got.TimeoutMessage != "new timeout" {
+		t.Errorf("TimeoutMessage = %q, want the swapped value", got.TimeoutMessage)
+	}
+	if got.RedisAddr != "localhost:6379" {
+		t.Errorf("static RedisAddr = %q, want it kept at the startup value", got.RedisAddr)
+	}
+	if got.RetryBaseDelay != prev.RetryBaseDelay {
+		t.Errorf("RetryBaseDelay = %s, want it kept since the Evolution client captured it at startup", got.RetryBaseDelay)
+	}
+	if prev.TimeoutMessage != "old timeout" {
+		t.Error("Swap modified the previous configuration")
+	}
+}
+
+func TestConfigHolderReload(t *testing.T) {
+	setRequiredEnv(t)
+	t.Setenv("MESSAGE_TIMEOUT", "30s")
+
+	cfg, err := LoadConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	holder := NewConfigHolder(cfg)
+
+	t.Setenv("MESSAGE_TIMEOUT", "45s")
+	t.Setenv("EVOLUTION_INSTANCE", "renamed")
+	if err := holder.Reload(); err != nil {
+		t.Fatal(err)
+	}
+	if got := holder.Load().MessageTimeout; got != 45*time.Second {
+		t.Errorf("MessageTimeout = %s after reload, want 45s", got)
+	}
+	if got := holder.Load().EvolutionInstance; got != "bot" {
+		t.Errorf("static EvolutionInstance = %q after reload, want bot", got)
+	}
+
+	t.Setenv("MESSAGE_TIMEOUT", "soon")
+	if err := holder.Reload(); err == nil {
+		t.Fatal("reload with an invalid MESSAGE_TIMEOUT succeeded")
+	}
+	if got := holder.Load().MessageTimeout; got != 45*time.Second {
+		t.Errorf("MessageTimeout = %s after a rejected reload, want it kept at 45s", got)
+	}
+}
+
+func TestConfigHolderReloadWithUnchangedTLS(t *testing.T) {
+	dir := t.TempDir()
+	ca := issueCert(t, dir, "ca", nil, &x509.Certificate{IsCA: true, BasicConstraintsValid: true, KeyUsage: x509.KeyUsageCertSign})
+	client := issueCert(t, dir, "client", ca, &x509.Certificate{ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth}})
+
+	setRequiredEnv(t)
+	t.Setenv("EVOLUTION_TLS_CERT_FILE", client.certFile)
+	t.Setenv("EVOLUTION_TLS_KEY_FILE", client.keyFile)
+	t.Setenv("EVOLUTION_TLS_CA_FILE", ca.certFile)
+	cfg, err := LoadConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	holder := NewConfigHolder(cfg)
+
+	var logs bytes.Buffer
+	log.SetOutput(&logs)
+	t.Cleanup(func() { log.SetOutput(os.Stderr) })
+
+	if err := holder.Reload(); err != nil {
+		t.Fatal(err)
+	}
+	if logs.Len() != 0 {
+		t.Errorf("reload with nothing changed logged:\n%s", logs.String())
+	}
+	if holder.Load().EvolutionTLS != cfg.EvolutionTLS {
+		t.Error("reload replaced the TLS configuration the Evolution client was built with")
+	}
+
+	t.Setenv("EVOLUTION_TLS_CA_FILE", client.certFile)
+	if err := holder.Reload(); err != nil {
+		t.Fatal(err)
+	}
+	if got := holder.Load().EvolutionTLSCAFile; got != ca.certFile {
+		t.Errorf("static EvolutionTLSCAFile = %q after reload, want it kept", got)
+	}
+	if !strings.Contains(logs.String(), "EvolutionTLSCAFile cannot be changed") {
+		t.Errorf("changed CA file not reported, logs:\n%s", logs.String())
+	}
+}
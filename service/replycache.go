@@ -0,0 +1,48 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// ReplyCache remembers the reply computed for each inbound message ID so a
+// redelivered message is answered with the same text instead of a new
+// completion.
+type ReplyCache struct {
+	client *redis.Client
+	ttl    time.Duration
+}
+
+func NewReplyCache(client *redis.Client, ttl time.Duration) *ReplyCache {
+	return &ReplyCache{client: client, ttl: ttl}
+}
+
+// Get returns the cached reply for messageID, or "" when none is stored.
+func (c *ReplyCache) Get(ctx context.Context, messageID string) (string, error) {
+	if c == nil || messageID == "" {
+		return "", nil
+	}
+
+	reply, err := c.client.Get(ctx, c.key(messageID)).Result()
+	if err != nil {
+		if err == redis.Nil {
+			return "", nil
+		}
+		return "", err
+	}
+	return reply, nil
+}
+
+func (c *ReplyCache) Set(ctx context.Context, messageID, reply string) error {
+	if c == nil || messageID == "" {
+		return nil
+	}
+	return c.client.Set(ctx, c.key(messageID), reply, c.ttl).Err()
+}
+
+func (c *ReplyCache) key(messageID string) string {
+	return fmt.Sprintf("reply:%s", messageID)
+}
@@ -0,0 +1,40 @@
+package service
+
+import (
+	"context"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestHandleMessageResendsCachedReply(t *testing.T) {
+	evo, oa := newFakeEvolution(t), newFakeOpenAI(t)
+	cfg := testConfig(evo.URL)
+	_, client := newTestRedis(t)
+	bot := newTestBot(cfg, evo, oa)
+	bot.Replies = NewReplyCache(client, time.Hour)
+
+	ctx := context.Background()
+	msg, key := textMessage("in-1", "hi")
+	for i := 0; i < 2; i++ {
+		if err := bot.handleMessage(ctx, cfg, "", "", msg, key); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if got := len(oa.Requests()); got != 1 {
+		t.Errorf("OpenAI called %d times, want 1", got)
+	}
+	if texts := evo.Texts(); !slices.Equal(texts, []string{"Hello!", "Hello!"}) {
+		t.Errorf("sent %q, want the reply and its cached resend", texts)
+	}
+
+	// A new message ID is answered afresh.
+	msg, key = textMessage("in-2", "hi again")
+	if err := bot.handleMessage(ctx, cfg, "", "", msg, key); err != nil {
+		t.Fatal(err)
+	}
+	if got := len(oa.Requests()); got != 2 {
+		t.Errorf("OpenAI called %d times after a new message, want 2", got)
+	}
+}
@@ -0,0 +1,126 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+
+	"hackathon/model"
+)
+
+var errRetryQueueFull = errors.New("generation retry queue is full")
+
+// retryItem is an inbound message whose reply could not be generated.
+type retryItem struct {
+	Recipient string               `json:"recipient"`
+	PushName  string               `json:"push_name"`
+	Text      string               `json:"text"`
+	Message   model.WebhookMessage `json:"message"`
+	Key       model.WebhookKey     `json:"key"`
+	QueuedAt  time.Time            `json:"queued_at"`
+}
+
+// GenerationRetryQueue keeps messages whose reply failed because OpenAI was
+// unavailable, even after in-call retries, in a Redis list. A background
+// worker answers them in order once OpenAI recovers.
+type GenerationRetryQueue struct {
+	queue    redisQueue
+	interval time.Duration
+}
+
+func NewGenerationRetryQueue(client *redis.Client, cfg *model.Config) *GenerationRetryQueue {
+	return &GenerationRetryQueue{
+		queue: redisQueue{
+			client:  client,
+			key:     fmt.Sprintf("generation-retry:%s", cfg.EvolutionInstance),
+			maxSize: int64(cfg.GenerationRetryMax),
+			maxAge:  cfg.GenerationRetryMaxAge,
+		},
+		interval: cfg.GenerationRetryInterval,
+	}
+}
+
+func (q *GenerationRetryQueue) Enqueue(ctx context.Context, item retryItem) error {
+	item.QueuedAt = time.Now()
+	pushed, err := q.queue.push(ctx, item)
+	if err != nil {
+		return fmt.Errorf("queue retry item: %w", err)
+	}
+	if !pushed {
+		return errRetryQueueFull
+	}
+	return nil
+}
+
+// Run retries queued messages every interval until ctx is cancelled.
+func (q *GenerationRetryQueue) Run(ctx context.Context, b *Bot) {
+	ticker := time.NewTicker(q.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := q.process(ctx, b); err != nil && ctx.Err() == nil {
+				log.Printf("generation retry queue: %v", err)
+			}
+		}
+	}
+}
+
+// process answers queued messages oldest first. It stops, leaving the
+// remaining entries in place, as soon as generation fails again with a
+// retryable error, since OpenAI has not recovered yet, or while replies are
+// paused. Entries older than the configured max age are discarded. Only one
+// replica works through the queue at a time.
+func (q *GenerationRetryQueue) process(ctx context.Context, b *Bot) error {
+	answered := 0
+	err := q.queue.drain(ctx, func(ctx context.Context, payload []byte) error {
+		if b.KillSwitch.Paused(ctx) {
+			return errStopDrain
+		}
+		cfg := b.Configs.Load()
+
+		var item retryItem
+		if err := json.Unmarshal(payload, &item); err != nil {
+			log.Printf("generation retry queue: dropping undecodable entry: %v", err)
+		} else if time.Since(item.QueuedAt) > q.queue.maxAge {
+			log.Printf("generation retry queue: dropping stale message %s from %s queued at %s", item.Key.ID, item.Recipient, item.QueuedAt.Format(time.RFC3339))
+		} else if b.Handoff.Paused(ctx, item.Recipient, time.Now()) {
+			log.Printf("generation retry queue: dropping message %s, %s was handed off to a human", item.Key.ID, item.Recipient)
+		} else if err := q.retry(ctx, b, cfg, item); err != nil {
+			var generationErr *generationError
+			if errors.As(err, &generationErr) && isRetryableOpenAIError(generationErr) && ctx.Err() == nil {
+				return err
+			}
+			log.Printf("generation retry queue: reply to %s failed, dropping: %v", item.Recipient, err)
+		} else {
+			answered++
+		}
+		return nil
+	})
+
+	if answered > 0 {
+		log.Printf("generation retry queue: answered %d queued messages", answered)
+	}
+	return err
+}
+
+func (q *GenerationRetryQueue) retry(ctx context.Context, b *Bot, cfg *model.Config, item retryItem) error {
+	if cfg.MessageTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, cfg.MessageTimeout)
+		defer cancel()
+	}
+	// The first attempt's placeholder was removed when it failed; a second
+	// one minutes later would only add noise.
+	retryCfg := *cfg
+	retryCfg.ThinkingPlaceholder = ""
+	return b.respond(ctx, &retryCfg, item.Recipient, item.PushName, item.Text, item.Message, item.Key)
+}
@@ -0,0 +1,99 @@
+package service
+
+import (
+	"context"
+	"net/http"
+	"slices"
+	"testing"
+	"time"
+)
+
+// retryBot returns a bot whose failed generations are queued for retry.
+func retryBot(t *testing.T) (*Bot, *fakeEvolution, *fakeOpenAI, *GenerationRetryQueue) {
+	t.Helper()
+
+	evo, oa := newFakeEvolution(t), newFakeOpenAI(t)
+	cfg := testConfig(evo.URL)
+	cfg.GenerationRetryMax = 10
+	cfg.GenerationRetryMaxAge = time.Hour
+	cfg.GenerationRetryInterval = time.Minute
+	_, client := newTestRedis(t)
+	bot := newTestBot(cfg, evo, oa)
+	bot.Retries = NewGenerationRetryQueue(client, cfg)
+	return bot, evo, oa, bot.Retries
+}
+
+func TestGenerationRetryQueueAnswersAfterRecovery(t *testing.T) {
+	bot, evo, oa, queue := retryBot(t)
+	ctx := context.Background()
+	oa.Reply(fakeCompletion{Status: http.StatusServiceUnavailable})
+
+	deliverText(t, bot, "in-1", "first")
+	deliverText(t, bot, "in-2", "second")
+	if n := queue.queue.client.LLen(ctx, "generation-retry:bot").Val(); n != 2 {
+		t.Fatalf("%d messages queued, want both failed messages", n)
+	}
+	if texts := evo.Texts(); len(texts) != 0 {
+		t.Fatalf("sent %q during the outage", texts)
+	}
+
+	// OpenAI is still down: the queue is left as it was.
+	if err := queue.process(ctx, bot); err == nil {
+		t.Error("process reported no error while OpenAI was still failing")
+	}
+	if n := queue.queue.client.LLen(ctx, "generation-retry:bot").Val(); n != 2 {
+		t.Fatalf("%d messages queued after a failed run, want 2", n)
+	}
+
+	oa.Reply(fakeCompletion{Content: "Answer one."}, fakeCompletion{Content: "Answer two."})
+	if err := queue.process(ctx, bot); err != nil {
+		t.Fatal(err)
+	}
+	if texts := evo.Texts(); !slices.Equal(texts, []string{"Answer one.", "Answer two."}) {
+		t.Errorf("sent %q after recovery, want both answers in order", texts)
+	}
+	requests := oa.Requests()
+	if last := requests[len(requests)-2].Messages; last[len(last)-1].Content != "first" {
+		t.Errorf("first retry answered %q, want the oldest message", last[len(last)-1].Content)
+	}
+	if n := queue.queue.client.LLen(ctx, "generation-retry:bot").Val(); n != 0 {
+		t.Errorf("%d messages left in the queue", n)
+	}
+}
+
+func TestGenerationRetryQueueWaitsWhilePausedOrLeased(t *testing.T) {
+	bot, evo, oa, queue := retryBot(t)
+	ctx := context.Background()
+	client := queue.queue.client
+	bot.KillSwitch = NewKillSwitch(client)
+	oa.Reply(fakeCompletion{Status: http.StatusServiceUnavailable})
+	deliverText(t, bot, "in-1", "hi")
+	oa.Reply(fakeCompletion{Content: "Hello!"})
+
+	if err := bot.KillSwitch.SetPaused(ctx, true); err != nil {
+		t.Fatal(err)
+	}
+	if err := queue.process(ctx, bot); err != nil {
+		t.Fatal(err)
+	}
+	if err := bot.KillSwitch.SetPaused(ctx, false); err != nil {
+		t.Fatal(err)
+	}
+
+	// Another replica is working through the queue.
+	client.Set(ctx, "generation-retry:bot:lease", "other-replica", time.Minute)
+	if err := queue.process(ctx, bot); err != nil {
+		t.Fatal(err)
+	}
+	if texts := evo.Texts(); len(texts) != 0 {
+		t.Fatalf("sent %q while paused or leased elsewhere", texts)
+	}
+
+	client.Del(ctx, "generation-retry:bot:lease")
+	if err := queue.process(ctx, bot); err != nil {
+		t.Fatal(err)
+	}
+	if texts := evo.Texts(); !slices.Equal(texts, []string{"Hello!"}) {
+		t.Errorf("sent %q once free, want the queued reply", texts)
+	}
+}
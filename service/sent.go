@@ -0,0 +1,62 @@
+package service
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"sync"
+	"time"
+)
+
+const sentTrackerTTL = 2 * time.Minute
+
+// sentTracker remembers what the bot itself sent recently, by content and by
+// the message ID Evolution returned, so the echo of those messages arriving
+// as fromMe webhooks is not mistaken for a reply typed by a human agent.
+type sentTracker struct {
+	mu      sync.Mutex
+	entries map[string]time.Time
+}
+
+func (t *sentTracker) add(to, text string, now time.Time) {
+	t.put(sentKey(to, text), now)
+}
+
+func (t *sentTracker) addID(messageID string, now time.Time) {
+	t.put("id:"+messageID, now)
+}
+
+func (t *sentTracker) put(key string, now time.Time) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.entries == nil {
+		t.entries = make(map[string]time.Time)
+	}
+	for key, at := range t.entries {
+		if now.Sub(at) > sentTrackerTTL {
+			delete(t.entries, key)
+		}
+	}
+	t.entries[key] = now
+}
+
+func (t *sentTracker) contains(to, text string, now time.Time) bool {
+	return t.has(sentKey(to, text), now)
+}
+
+func (t *sentTracker) containsID(messageID string, now time.Time) bool {
+	return messageID != "" && t.has("id:"+messageID, now)
+}
+
+func (t *sentTracker) has(key string, now time.Time) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	at, ok := t.entries[key]
+	return ok && now.Sub(at) <= sentTrackerTTL
+}
+
+func sentKey(to, text string) string {
+	sum := sha256.Sum256([]byte(normalizeWhatsAppID(to) + "\x00" + text))
+	return hex.EncodeToString(sum[:])
+}
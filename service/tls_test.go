@@ -0,0 +1,155 @@
+package service
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// testCert is a certificate with its key, written to PEM files.
+type testCert struct {
+	cert     *x509.Certificate
+	key      *ecdsa.PrivateKey
+	certFile string
+	keyFile  string
+}
+
+// issueCert creates a certificate signed by parent, or a self-signed CA when
+// parent is nil, and writes it to dir.
+func issueCert(t *testing.T, dir, name string, parent *testCert, template *x509.Certificate) *testCert {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template.SerialNumber = big.NewInt(time.Now().UnixNano())
+	template.Subject = pkix.Name{CommonName: name}
+	template.NotBefore = time.Now().Add(-time.Hour)
+	template.NotAfter = time.Now().Add(time.Hour)
+
+	signer, signerKey := template, key
+	if parent != nil {
+		signer, signerKey = parent.cert, parent.key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, signer, &key.PublicKey, signerKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, _ := x509.ParseCertificate(der)
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	issued := &testCert{cert: cert, key: key, certFile: filepath.Join(dir, name+".pem"), keyFile: filepath.Join(dir, name+"-key.pem")}
+	writePEM(t, issued.certFile, "CERTIFICATE", der)
+	writePEM(t, issued.keyFile, "EC PRIVATE KEY", keyDER)
+	return issued
+}
+
+func writePEM(t *testing.T, path, blockType string, der []byte) {
+	t.Helper()
+	if err := os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: der}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// newMutualTLSServer starts an Evolution stand-in that only accepts clients
+// presenting a certificate signed by ca.
+func newMutualTLSServer(t *testing.T, ca, server *testCert) *httptest.Server {
+	t.Helper()
+
+	serverCert, err := tls.LoadX509KeyPair(server.certFile, server.keyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	clientCAs := x509.NewCertPool()
+	clientCAs.AddCert(ca.cert)
+
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"instance":{"state":"open"}}`))
+	}))
+	srv.TLS = &tls.Config{
+		Certificates: []tls.Certificate{serverCert},
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+		ClientCAs:    clientCAs,
+	}
+	srv.StartTLS()
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestEvolutionClientMutualTLS(t *testing.T) {
+	dir := t.TempDir()
+	ca := issueCert(t, dir, "ca", nil, &x509.Certificate{IsCA: true, BasicConstraintsValid: true, KeyUsage: x509.KeyUsageCertSign})
+	server := issueCert(t, dir, "server", ca, &x509.Certificate{IPAddresses: []net.IP{net.IPv4(127, 0, 0, 1)}, ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth}})
+	client := issueCert(t, dir, "client", ca, &x509.Certificate{ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth}})
+	srv := newMutualTLSServer(t, ca, server)
+
+	for _, tc := range []struct {
+		name              string
+		certFile, keyFile string
+		wantErr           bool
+	}{
+		{"client certificate", client.certFile, client.keyFile, false},
+		{"no client certificate", "", "", true},
+	} {
+		tlsConfig, err := loadEvolutionTLS(tc.certFile, tc.keyFile, ca.certFile)
+		if err != nil {
+			t.Fatalf("%s: %v", tc.name, err)
+		}
+		cfg := testConfig(srv.URL)
+		cfg.EvolutionTLS = tlsConfig
+
+		state, err := NewEvolutionClient(cfg).ConnectionState(context.Background())
+		if tc.wantErr {
+			if err == nil {
+				t.Errorf("%s: server accepted the connection", tc.name)
+			}
+			continue
+		}
+		if err != nil || state != "open" {
+			t.Errorf("%s: state %q, %v, want open", tc.name, state, err)
+		}
+	}
+}
+
+func TestLoadEvolutionTLSErrors(t *testing.T) {
+	dir := t.TempDir()
+	ca := issueCert(t, dir, "ca", nil, &x509.Certificate{IsCA: true, BasicConstraintsValid: true, KeyUsage: x509.KeyUsageCertSign})
+	notPEM := filepath.Join(dir, "not.pem")
+	if err := os.WriteFile(notPEM, []byte("not a certificate"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	missing := filepath.Join(dir, "missing.pem")
+
+	if tlsConfig, err := loadEvolutionTLS("", "", ""); tlsConfig != nil || err != nil {
+		t.Errorf("no files: %v, %v, want the default TLS settings", tlsConfig, err)
+	}
+	for name, files := range map[string][3]string{
+		"cert without key":    {ca.certFile, "", ""},
+		"key without cert":    {"", ca.keyFile, ""},
+		"bad key pair":        {ca.certFile, notPEM, ""},
+		"missing cert":        {missing, ca.keyFile, ""},
+		"missing CA file":     {"", "", missing},
+		"CA file without PEM": {"", "", notPEM},
+	} {
+		if _, err := loadEvolutionTLS(files[0], files[1], files[2]); err == nil {
+			t.Errorf("%s: accepted", name)
+		}
+	}
+}
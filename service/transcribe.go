@@ -0,0 +1,152 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+
+	openai "github.com/sashabaranov/go-openai"
+
+	"hackathon/model"
+)
+
+const (
+	TranscribeBusyQueue = "queue"
+	TranscribeBusySkip  = "skip"
+)
+
+// errTranscriberBusy is returned when every transcription slot is taken and
+// TRANSCRIBE_WHEN_BUSY is "skip".
+var errTranscriberBusy = errors.New("all transcription slots are busy")
+
+// Transcriber turns inbound voice notes into text with Whisper. Transcriptions
+// are bounded by their own semaphore so a burst of audio cannot starve chat
+// completions of connections and CPU.
+type Transcriber struct {
+	openai    *openai.Client
+	evolution *EvolutionClient
+	slots     chan struct{}
+}
+
+func NewTranscriber(oa *openai.Client, evolution *EvolutionClient, maxConcurrency int) *Transcriber {
+	return &Transcriber{
+		openai:    oa,
+		evolution: evolution,
+		slots:     make(chan struct{}, maxConcurrency),
+	}
+}
+
+// Transcribe downloads the audio of msg and returns its transcript. When all
+// slots are busy it either waits for one or, with TRANSCRIBE_WHEN_BUSY=skip,
+// gives up immediately with errTranscriberBusy. The audio is spooled to a
+// temporary file, capped at MEDIA_MAX_BYTES, rather than held in memory.
+func (t *Transcriber) Transcribe(ctx context.Context, cfg *model.Config, msg model.WebhookMessage, key model.WebhookKey) (string, error) {
+	if err := t.acquire(ctx, cfg); err != nil {
+		return "", err
+	}
+	defer t.release()
+
+	file, err := os.CreateTemp("", "voice-*")
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(file.Name())
+	defer file.Close()
+
+	mimetype, err := t.evolution.DownloadMedia(ctx, key, msg.MediaURL, cfg.MediaMaxBytes, file)
+	if err != nil {
+		return "", err
+	}
+
+	var resp openai.AudioResponse
+	err = withOpenAIRetries(ctx, cfg, func() error {
+		if _, err := file.Seek(0, io.SeekStart); err != nil {
+			return err
+		}
+
+		var err error
+		resp, err = t.openai.CreateTranscription(ctx, openai.AudioRequest{
+			Model:    openai.Whisper1,
+			Reader:   file,
+			FilePath: "audio" + audioExtension(mimetype),
+		})
+		return err
+	})
+	if err != nil {
+		return "", err
+	}
+
+	return strings.TrimSpace(resp.Text), nil
+}
+
+func (t *Transcriber) acquire(ctx context.Context, cfg *model.Config) error {
+	select {
+	case t.slots <- struct{}{}:
+		return nil
+	default:
+	}
+
+	if cfg.TranscribeWhenBusy == TranscribeBusySkip {
+		return errTranscriberBusy
+	}
+
+	log.Printf("transcription slots busy (%d in use), waiting", cap(t.slots))
+	select {
+	case t.slots <- struct{}{}:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (t *Transcriber) release() {
+	<-t.slots
+}
+
+// audioExtension maps a WhatsApp audio mimetype to a file extension Whisper
+// recognises. Voice notes are Opus in an Ogg container.
+func audioExtension(mimetype string) string {
+	mimetype = strings.ToLower(mimetype)
+	switch {
+	case strings.Contains(mimetype, "mpeg"), strings.Contains(mimetype, "mp3"):
+		return ".mp3"
+	case strings.Contains(mimetype, "mp4"), strings.Contains(mimetype, "m4a"), strings.Contains(mimetype, "aac"):
+		return ".m4a"
+	case strings.Contains(mimetype, "wav"):
+		return ".wav"
+	case strings.Contains(mimetype, "webm"):
+		return ".webm"
+	default:
+		return ".ogg"
+	}
+}
+
+// isVoiceNote reports whether msg carries audio to transcribe.
+func isVoiceNote(msg model.WebhookMessage) bool {
+	return msg.AudioMessage != nil || msg.Audio != nil
+}
+
+// transcribeVoiceNote returns the transcript of a voice note, which the reply
+// pipeline then handles as if it had been typed. It returns "" without error
+// when the note is skipped because every slot is busy or it has no speech.
+func (b *Bot) transcribeVoiceNote(ctx context.Context, cfg *model.Config, msg model.WebhookMessage, key model.WebhookKey) (string, error) {
+	transcript, err := b.Transcriber.Transcribe(ctx, cfg, msg, key)
+	if errors.Is(err, errTranscriberBusy) {
+		log.Printf("audio message %s skipped: %v", key.ID, err)
+		return "", nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("transcribe: %w", err)
+	}
+	if transcript == "" {
+		log.Printf("audio message %s produced an empty transcript", key.ID)
+		return "", nil
+	}
+
+	log.Printf("transcribed audio message %s: %s", key.ID, transcript)
+	return transcript, nil
+}
@@ -0,0 +1,228 @@
+package service
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	"hackathon/model"
+)
+
+// voiceBot returns a bot that transcribes voice notes with the given number of
+// slots. Media downloads are answered with a few bytes of audio and
+// transcriptions with "what time is it".
+func voiceBot(t *testing.T, slots int) (*Bot, *fakeEvolution, *fakeOpenAI) {
+	t.Helper()
+
+	evo, oa := newFakeEvolution(t), newFakeOpenAI(t)
+	evo.Handle("/chat/getBase64FromMediaMessage", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, `{"base64":%q,"mimetype":"audio/ogg; codecs=opus"}`, base64.StdEncoding.EncodeToString([]byte("OggS")))
+	})
+	oa.Handle("/v1/audio/transcriptions", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"text":" what time is it "}`))
+	})
+
+	bot := newTestBot(testConfig(evo.URL), evo, oa)
+	bot.Transcriber = NewTranscriber(oa.Client(), bot.Evolution, slots)
+	return bot, evo, oa
+}
+
+func voiceMessage(id string) (model.WebhookMessage, model.WebhookKey) {
+	msg, key := textMessage(id, "")
+	msg.AudioMessage = &model.AudioMessage{Mimetype: "audio/ogg; codecs=opus", Seconds: 3, PTT: true}
+	return msg, key
+}
+
+func transcriptions(evo *fakeEvolution) int {
+	return len(evo.Requests("/chat/getBase64FromMediaMessage"))
+}
+
+func TestVoiceNoteTranscribedAndAnswered(t *testing.T) {
+	bot, evo, oa := voiceBot(t, 2)
+
+	msg, key := voiceMessage("in-1")
+	if err := bot.handlePayload(context.Background(), upsertPayload(t, "bot", messageEntry(msg, key))); err != nil {
+		t.Fatal(err)
+	}
+
+	requests := oa.Requests()
+	if len(requests) != 1 {
+		t.Fatalf("%d completions, want 1", len(requests))
+	}
+	if last := requests[0].Messages[len(requests[0].Messages)-1]; last.Content != "what time is it" {
+		t.Errorf("completion input %q, want the transcript", last.Content)
+	}
+	if texts := evo.Texts(); !slices.Equal(texts, []string{"Hello!"}) {
+		t.Errorf("sent %q, want the reply", texts)
+	}
+}
+
+func TestVoiceNoteGatesRunBeforeTranscription(t *testing.T) {
+	for name, setup := range map[string]func(t *testing.T, bot *Bot) model.WebhookKey{
+		"from me": func(t *testing.T, bot *Bot) model.WebhookKey {
+			_, key := voiceMessage("out-1")
+			key.FromMe = true
+			return key
+		},
+		"handed off": func(t *testing.T, bot *Bot) model.WebhookKey {
+			server, _ := recordHandoffs(t)
+			cfg := bot.Configs.Load()
+			cfg.HandoffWebhookURL = server.URL
+			bot.Handoff = NewHandoffNotifier(cfg, nil)
+			if err := bot.Handoff.Trigger(context.Background(), "5511999999999", "keyword", "human", nil, time.Hour); err != nil {
+				t.Fatal(err)
+			}
+			_, key := voiceMessage("in-1")
+			return key
+		},
+		"after hours": func(t *testing.T, bot *Bot) model.WebhookKey {
+			cfg := bot.Configs.Load()
+			cfg.OfficeHours, _ = parseOfficeHours("mon-fri 09:00-18:00")
+			cfg.OfficeHoursLocation = time.UTC
+			cfg.AfterHoursMessage = "We are closed."
+			bot.now = func() time.Time { return time.Date(2024, 6, 3, 19, 0, 0, 0, time.UTC) }
+			_, key := voiceMessage("in-1")
+			return key
+		},
+		"over quota": func(t *testing.T, bot *Bot) model.WebhookKey {
+			_, client := newTestRedis(t)
+			bot.Configs.Load().DailyMessageQuota = 1
+			bot.Quota = NewDailyQuota(client)
+			if _, err := bot.Quota.Consume(context.Background(), "5511999999999", time.UTC, time.Now()); err != nil {
+				t.Fatal(err)
+			}
+			_, key := voiceMessage("in-1")
+			return key
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			bot, evo, oa := voiceBot(t, 1)
+			key := setup(t, bot)
+			msg, _ := voiceMessage(key.ID)
+
+			if err := bot.processWebhookMessage(context.Background(), bot.Configs.Load(), "", "", msg, key); err != nil {
+				t.Fatal(err)
+			}
+			if n := transcriptions(evo); n != 0 {
+				t.Errorf("%d transcriptions, want none", n)
+			}
+			if n := len(oa.Requests()); n != 0 {
+				t.Errorf("%d completions, want none", n)
+			}
+		})
+	}
+}
+
+func TestVoiceNoteTranscriptionRespectsMessageTimeout(t *testing.T) {
+	bot, evo, oa := voiceBot(t, 1)
+	oa.Handle("/v1/audio/transcriptions", func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(300 * time.Millisecond)
+		w.Write([]byte(`{"text":"too late"}`))
+	})
+	cfg := bot.Configs.Load()
+	cfg.MessageTimeout = 50 * time.Millisecond
+
+	msg, key := voiceMessage("in-1")
+	start := time.Now()
+	err := bot.processWebhookMessage(context.Background(), cfg, "", "", msg, key)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("error = %v, want the deadline", err)
+	}
+	if elapsed := time.Since(start); elapsed > 250*time.Millisecond {
+		t.Errorf("returned after %s, want the transcription abandoned at the deadline", elapsed)
+	}
+	if texts := evo.Texts(); !slices.Equal(texts, []string{cfg.TimeoutMessage}) {
+		t.Errorf("sent %q, want only the timeout message", texts)
+	}
+}
+
+func TestTranscriberConcurrencyLimit(t *testing.T) {
+	for _, tc := range []struct {
+		whenBusy    string
+		transcribed int
+	}{
+		{TranscribeBusyQueue, 3},
+		{TranscribeBusySkip, 1},
+	} {
+		t.Run(tc.whenBusy, func(t *testing.T) {
+			bot, evo, oa := voiceBot(t, 1)
+			bot.Configs.Load().TranscribeWhenBusy = tc.whenBusy
+
+			var mu sync.Mutex
+			var inFlight, peak int
+			release := make(chan struct{})
+			started := make(chan struct{}, 3)
+			oa.Handle("/v1/audio/transcriptions", func(w http.ResponseWriter, r *http.Request) {
+				mu.Lock()
+				inFlight++
+				peak = max(peak, inFlight)
+				mu.Unlock()
+				started <- struct{}{}
+
+				<-release
+				mu.Lock()
+				inFlight--
+				mu.Unlock()
+				w.Write([]byte(`{"text":"hello"}`))
+			})
+
+			var wg sync.WaitGroup
+			for i := range 3 {
+				msg, key := voiceMessage(fmt.Sprintf("in-%d", i))
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					if err := bot.processWebhookMessage(context.Background(), bot.Configs.Load(), "", "", msg, key); err != nil {
+						t.Error(err)
+					}
+				}()
+			}
+
+			// Let the first transcription hold the only slot while the others
+			// arrive, then let everything through.
+			<-started
+			time.Sleep(100 * time.Millisecond)
+			close(release)
+			wg.Wait()
+
+			if peak != 1 {
+				t.Errorf("%d transcriptions ran at once, want at most 1", peak)
+			}
+			if got := transcriptions(evo); got != tc.transcribed {
+				t.Errorf("%d voice notes transcribed, want %d", got, tc.transcribed)
+			}
+			if got := len(oa.Requests()); got != tc.transcribed {
+				t.Errorf("%d completions, want %d", got, tc.transcribed)
+			}
+		})
+	}
+}
+
+func TestMessageCreateIgnoresFromMe(t *testing.T) {
+	evo, oa := newFakeEvolution(t), newFakeOpenAI(t)
+	bot := newTestBot(testConfig(evo.URL), evo, oa)
+
+	data, _ := json.Marshal(model.WebhookData{
+		Key:     model.WebhookKey{RemoteJID: "5511999999999@s.whatsapp.net", FromMe: true, ID: "out-1"},
+		Message: model.WebhookMessage{Conversation: "typed by a human"},
+	})
+	body, _ := json.Marshal(model.WebhookPayload{Event: "message_create", Instance: "bot", Data: data})
+	if err := bot.handlePayload(context.Background(), body); err != nil {
+		t.Fatal(err)
+	}
+
+	if n := len(oa.Requests()); n != 0 {
+		t.Errorf("%d completions for the bot's own message, want none", n)
+	}
+	if texts := evo.Texts(); len(texts) != 0 {
+		t.Errorf("sent %q in reply to the bot's own message", texts)
+	}
+}
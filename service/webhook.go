@@ -3,31 +3,33 @@ package service
 import (
 	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"io"
 	"log"
 	"net/http"
 	"strings"
+	"time"
 
 	openai "github.com/sashabaranov/go-openai"
 
 	"hackathon/model"
 )
 
-func WebhookHandler(oa *openai.Client, evo *EvolutionClient, store *ConversationStore, cfg *model.Config) http.HandlerFunc {
-	if evo == nil {
+func WebhookHandler(bot *Bot) http.HandlerFunc {
+	if bot.Evolution == nil {
 		panic("WebhookHandler requires EvolutionClient")
 	}
 
-	if oa == nil {
+	if bot.Handlers == nil {
+		panic("WebhookHandler requires HandlerRegistry")
+	}
+
+	if bot.OpenAI == nil {
 		log.Print("WebhookHandler: openai client is nil, responses will be Echo mode")
 	}
 
 	return func(w http.ResponseWriter, r *http.Request) {
-		if r.Method != http.MethodPost {
-			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
-			return
-		}
-
 		body, err := io.ReadAll(r.Body)
 		if err != nil {
 			log.Printf("webhook read body error: %v", err)
@@ -39,153 +41,480 @@ func WebhookHandler(oa *openai.Client, evo *EvolutionClient, store *Conversation
 		log.Printf("webhook request: method=%s path=%s remote=%s", r.Method, r.URL.Path, r.RemoteAddr)
 		log.Printf("webhook payload raw: %s", string(body))
 
-		var payload model.WebhookPayload
-		if err := json.Unmarshal(body, &payload); err != nil {
+		if !ephemeralPayload(bot.Configs.Load(), body) {
+			bot.History.Add(body, time.Now())
+		}
+
+		if err := bot.handlePayload(r.Context(), body); err != nil {
 			log.Printf("webhook decode error: %v", err)
 			http.Error(w, "invalid payload", http.StatusBadRequest)
 			return
 		}
 
-		ctx := r.Context()
+		w.WriteHeader(http.StatusOK)
+	}
+}
 
-		switch payload.Event {
-		case "messages.upsert":
-			if err := handleMessagesUpsert(ctx, payload, oa, evo, store, cfg); err != nil {
-				log.Printf("handle messages.upsert error: %v", err)
-			}
-		case "message_create":
-			var data model.WebhookData
-			if err := json.Unmarshal(payload.Data, &data); err != nil {
-				log.Printf("webhook message_create decode error: %v", err)
-				break
-			}
+// handlePayload dispatches a raw webhook body. Only a body that cannot be
+// decoded is reported as an error; failures while handling the event are
+// logged, since the webhook itself was received fine.
+func (b *Bot) handlePayload(ctx context.Context, body []byte) error {
+	cfg := b.Configs.Load()
 
-			if err := processWebhookMessage(ctx, oa, evo, store, cfg, payload.Sender, data.Message, data.Key); err != nil {
-				log.Printf("handle message_create error: %v", err)
-			}
-		default:
-			log.Printf("webhook ignoring event: %s", payload.Event)
-		}
+	var payload model.WebhookPayload
+	if err := json.Unmarshal(body, &payload); err != nil {
+		return err
+	}
 
-		w.WriteHeader(http.StatusOK)
+	if !instanceAllowed(cfg, payload.Instance) {
+		log.Printf("webhook ignoring payload for unexpected instance: %s", payload.Instance)
+		return nil
 	}
-}
 
-func handleMessagesUpsert(ctx context.Context, payload model.WebhookPayload, oa *openai.Client, evo *EvolutionClient, store *ConversationStore, cfg *model.Config) error {
-	var container struct {
-		Messages []model.MessagesUpsertEntry `json:"messages"`
+	if b.KillSwitch.Paused(ctx) {
+		log.Printf("webhook ignoring event %s: bot is paused", payload.Event)
+		return nil
 	}
 
-	if err := json.Unmarshal(payload.Data, &container); err == nil && len(container.Messages) > 0 {
-		for _, entry := range container.Messages {
-			if entry.Key.FromMe {
-				continue
-			}
-			if err := processWebhookMessage(ctx, oa, evo, store, cfg, payload.Sender, entry.Message, entry.Key); err != nil {
-				log.Printf("process messages.upsert entry error: %v", err)
-			}
+	if handler, ok := b.Handlers.event(payload.Event); ok {
+		if err := handler(ctx, b, cfg, payload); err != nil {
+			log.Printf("handle %s error: %v", payload.Event, err)
 		}
-		return nil
+	} else {
+		log.Printf("webhook ignoring event: %s", payload.Event)
 	}
 
-	var single model.MessagesUpsertEntry
-	if err := json.Unmarshal(payload.Data, &single); err != nil {
-		return err
+	return nil
+}
+
+// processWebhookMessage handles one inbound message within cfg.MessageTimeout.
+// When the deadline expires the slow path is abandoned, and since every stage
+// shares the expired context it can no longer send its reply; the user gets
+// cfg.TimeoutMessage instead.
+func (b *Bot) processWebhookMessage(ctx context.Context, cfg *model.Config, sender, pushName string, msg model.WebhookMessage, key model.WebhookKey) error {
+	if cfg.MessageTimeout <= 0 || key.FromMe {
+		return b.handleMessage(ctx, cfg, sender, pushName, msg, key)
 	}
 
-	if single.Key.FromMe {
-		return nil
+	deadlineCtx, cancel := context.WithTimeout(ctx, cfg.MessageTimeout)
+	defer cancel()
+
+	err := b.handleMessage(deadlineCtx, cfg, sender, pushName, msg, key)
+	if err == nil || ctx.Err() != nil || !errors.Is(deadlineCtx.Err(), context.DeadlineExceeded) {
+		return err
 	}
 
-	return processWebhookMessage(ctx, oa, evo, store, cfg, payload.Sender, single.Message, single.Key)
+	recipient := resolveRecipient(cfg, key, msg, sender)
+	log.Printf("message %s for %s exceeded the %s processing deadline: %v", key.ID, recipient, cfg.MessageTimeout, err)
+
+	sendCtx, cancelSend := context.WithTimeout(context.WithoutCancel(ctx), 10*time.Second)
+	defer cancelSend()
+
+	if recipient != "" {
+		if sendErr := b.sendNotice(sendCtx, recipient, extractMessageText(msg), cfg.TimeoutMessage); sendErr != nil {
+			log.Printf("timeout reply to %s failed: %v", recipient, sendErr)
+		}
+	}
+	return err
 }
 
-func processWebhookMessage(ctx context.Context, oa *openai.Client, evo *EvolutionClient, store *ConversationStore, cfg *model.Config, sender string, msg model.WebhookMessage, key model.WebhookKey) error {
+func (b *Bot) handleMessage(ctx context.Context, cfg *model.Config, sender, pushName string, msg model.WebhookMessage, key model.WebhookKey) error {
+	if expiration, ok := ephemeralSetting(msg); ok {
+		b.applyEphemeralSetting(ctx, cfg, sender, msg, key, expiration)
+		return nil
+	}
+
 	text := extractMessageText(msg)
 	if text == "" {
+		text = reactionAction(cfg, msg)
+	}
+	// Voice notes are transcribed only once the checks that do not need their
+	// text have passed, since transcription is the expensive step.
+	voiceNote := text == "" && b.Transcriber != nil && isVoiceNote(msg)
+	if text == "" && !voiceNote {
 		return nil
 	}
 
 	if key.FromMe {
+		b.recordHumanReply(ctx, cfg, sender, msg, key)
 		return nil
 	}
 
-	recipient := chooseRecipient(key.RemoteJID, msg.From, sender)
+	recipient := resolveRecipient(cfg, key, msg, sender)
 	if recipient == "" {
 		return nil
 	}
 
-	reply, err := generateAssistantReply(ctx, oa, store, cfg, recipient, text)
+	if !voiceNote {
+		if handled, err := b.runCommand(ctx, cfg, recipient, key, text); handled {
+			return b.markReadIfSent(ctx, cfg, key, err)
+		}
+		if !passesInboundFilter(cfg, key, text) {
+			return nil
+		}
+	}
+
+	if b.Handoff.Paused(ctx, recipient, time.Now()) {
+		log.Printf("conversation paused for human handoff: %s", recipient)
+		return nil
+	}
+
+	if cfg.MarkReadTiming == MarkReadOnReceive {
+		markRead(ctx, b.Evolution, key)
+	}
+
+	if !withinOfficeHours(cfg, b.clock()) {
+		return b.markReadIfSent(ctx, cfg, key, b.sendNotice(ctx, recipient, text, cfg.AfterHoursMessage))
+	}
+
+	cached, err := b.Replies.Get(ctx, key.ID)
 	if err != nil {
-		return err
+		log.Printf("reply cache lookup failed for %s: %v", key.ID, err)
+	} else if cached != "" {
+		log.Printf("message %s already answered, resending cached reply", key.ID)
+		return b.markReadIfSent(ctx, cfg, key, b.sendReply(ctx, cfg, recipient, text, cached))
 	}
 
-	if reply == "" {
+	if voiceNote {
+		// A voice note counts against the quota before it is transcribed,
+		// since the transcription is what the quota saves.
+		if b.overQuota(ctx, cfg, recipient, key, text) {
+			return nil
+		}
+		if text, err = b.transcribeVoiceNote(ctx, cfg, msg, key); text == "" || err != nil {
+			return err
+		}
+		if !passesInboundFilter(cfg, key, text) {
+			return nil
+		}
+	}
+
+	if b.Handoff != nil && wantsHuman(cfg, text) {
+		return b.markReadIfSent(ctx, cfg, key, b.handOff(ctx, cfg, recipient, "keyword", text))
+	}
+
+	if !voiceNote && b.overQuota(ctx, cfg, recipient, key, text) {
 		return nil
 	}
 
-	if err := evo.SendTextMessage(ctx, recipient, reply); err != nil {
-		return err
+	err = b.respond(ctx, cfg, recipient, pushName, text, msg, key)
+	var generationErr *generationError
+	if b.Retries != nil && errors.As(err, &generationErr) && ctx.Err() == nil && isRetryableOpenAIError(generationErr) && !ephemeralChat(cfg, msg) {
+		if queueErr := b.Retries.Enqueue(ctx, retryItem{Recipient: recipient, PushName: pushName, Text: text, Message: msg, Key: key}); queueErr != nil {
+			log.Printf("generation retry queue: enqueue for %s failed: %v", recipient, queueErr)
+			return err
+		}
+		log.Printf("reply to %s failed, queued for retry: %v", recipient, err)
+		return nil
+	}
+	return err
+}
+
+// generationError marks a failure to generate the reply, as opposed to a
+// failure to deliver it.
+type generationError struct {
+	err error
+}
+
+func (e *generationError) Error() string {
+	return "generate reply: " + e.err.Error()
+}
+
+func (e *generationError) Unwrap() error {
+	return e.err
+}
+
+// respond generates the reply to text and delivers it to recipient. It runs
+// after every check on the inbound message has passed, so queued retries can
+// call it directly.
+func (b *Bot) respond(ctx context.Context, cfg *model.Config, recipient, pushName, text string, msg model.WebhookMessage, key model.WebhookKey) error {
+	userInput := text
+	if cfg.GroupSpeakerLabels && isGroupJID(key.RemoteJID) {
+		userInput = labelSpeaker(pushName, key.Participant, text)
+	}
+
+	opts := replyOptions{
+		expiration: messageExpiration(msg),
+		language:   b.replyLanguage(ctx, cfg, normalizeWhatsAppID(recipient), text),
+		scorer:     b.Confidence,
+	}
+	if overrides, err := b.Overrides.Get(ctx, recipient); err != nil {
+		log.Printf("conversation overrides lookup failed for %s: %v", recipient, err)
+	} else {
+		opts.temperature = overrides.Temperature
+	}
+
+	pace := b.startPacing(cfg, recipient, key)
+	defer pace.stop()
+
+	placeholder := b.startPlaceholder(ctx, cfg, recipient)
+	reply, err := generateAssistantReply(ctx, b.OpenAI, b.Store, cfg, recipient, userInput, opts)
+	placeholderKey := placeholder.stop()
+	var lowConfidence *lowConfidenceError
+	if errors.As(err, &lowConfidence) {
+		log.Printf("reply to %s suppressed: %v", recipient, err)
+		if cfg.LowConfidenceAction == LowConfidenceHandoff && b.Handoff != nil {
+			b.removePlaceholder(ctx, placeholderKey)
+			return b.markReadIfSent(ctx, cfg, key, b.handOff(ctx, cfg, recipient, "low_confidence", text))
+		}
+		pace.waitReply(ctx, cfg.LowConfidenceMessage)
+		return b.deliverReply(ctx, cfg, recipient, text, cfg.LowConfidenceMessage, placeholderKey)
+	}
+	if err != nil {
+		b.removePlaceholder(ctx, placeholderKey)
+		return &generationError{err: err}
+	}
+
+	reply, handoffRequested := stripHandoffMarker(cfg, reply)
+	if cfg.SanitizeMarkdown {
+		reply = sanitizeMarkdown(reply)
+	}
+
+	if reply != "" {
+		if !ephemeralChat(cfg, msg) {
+			if err := b.Replies.Set(ctx, key.ID, reply); err != nil {
+				log.Printf("reply cache store failed for %s: %v", key.ID, err)
+			}
+		}
+
+		if cfg.MarkReadTiming == MarkReadAfterReply {
+			markRead(ctx, b.Evolution, key)
+		}
+
+		pace.waitReply(ctx, reply)
+		if err := b.deliverReply(ctx, cfg, recipient, text, reply, placeholderKey); err != nil {
+			return err
+		}
+	} else {
+		b.removePlaceholder(ctx, placeholderKey)
+	}
+
+	if handoffRequested && b.Handoff != nil {
+		return b.handOff(ctx, cfg, recipient, "model", text)
 	}
 
 	return nil
 }
 
-func generateAssistantReply(ctx context.Context, oa *openai.Client, store *ConversationStore, cfg *model.Config, recipient string, userInput string) (string, error) {
-	normalizedID := normalizeWhatsAppID(recipient)
-	if normalizedID == "" {
-		return "", nil
+// passesInboundFilter reports whether text matches INBOUND_FILTER_REGEX, when
+// one is configured.
+func passesInboundFilter(cfg *model.Config, key model.WebhookKey, text string) bool {
+	if cfg.InboundFilter != nil && !cfg.InboundFilter.MatchString(text) {
+		log.Printf("message %s ignored: does not match inbound filter", key.ID)
+		return false
+	}
+	return true
+}
+
+// overQuota is quotaExceeded for the reply pipeline: a failed check is logged
+// and lets the message through.
+func (b *Bot) overQuota(ctx context.Context, cfg *model.Config, recipient string, key model.WebhookKey, text string) bool {
+	exceeded, err := b.quotaExceeded(ctx, cfg, recipient, key, text)
+	if err != nil {
+		log.Printf("daily quota check failed for %s: %v", recipient, err)
+		return false
+	}
+	return exceeded
+}
+
+// quotaExceeded consumes one message from the daily quota of the message's
+// author and reports whether the quota is exhausted. In groups each
+// participant has their own quota. The quota notice, sent to recipient, goes
+// out only for the first message over the limit so users are not spammed
+// with it.
+func (b *Bot) quotaExceeded(ctx context.Context, cfg *model.Config, recipient string, key model.WebhookKey, text string) (bool, error) {
+	if b.Quota == nil || cfg.DailyMessageQuota <= 0 {
+		return false, nil
+	}
+
+	user := normalizeWhatsAppID(messageAuthor(key))
+	if user == "" {
+		user = recipient
+	}
+	count, err := b.Quota.Consume(ctx, user, cfg.QuotaLocation, b.clock())
+	if err != nil {
+		return false, err
+	}
+
+	limit := int64(cfg.DailyMessageQuota)
+	if count <= limit {
+		return false, nil
 	}
 
-	var conversation []openai.ChatCompletionMessage
-	if store != nil {
-		stored, err := store.GetConversation(ctx, normalizedID)
+	if count == limit+1 {
+		log.Printf("daily quota of %d messages reached for %s", limit, user)
+		if err := b.markReadIfSent(ctx, cfg, key, b.sendNotice(ctx, recipient, text, cfg.QuotaExceededMessage)); err != nil {
+			return true, err
+		}
+	}
+	return true, nil
+}
+
+// handOff notifies the handoff webhook with the recent conversation, pauses
+// the bot for recipient and lets the user know a person will follow up.
+func (b *Bot) handOff(ctx context.Context, cfg *model.Config, recipient, reason, text string) error {
+	var history []openai.ChatCompletionMessage
+	if b.Store != nil {
+		stored, err := b.Store.GetConversation(ctx, normalizeWhatsAppID(recipient))
 		if err != nil {
-			log.Printf("conversation load failed for %s: %v", normalizedID, err)
+			log.Printf("conversation load failed for %s: %v", recipient, err)
 		} else {
-			conversation = stored
+			history = stored
 		}
 	}
 
-	conversation = append(conversation, openai.ChatCompletionMessage{
-		Role:    openai.ChatMessageRoleUser,
-		Content: userInput,
-	})
+	if err := b.Handoff.Trigger(ctx, recipient, reason, text, history, cfg.HandoffPauseDuration); err != nil {
+		return fmt.Errorf("handoff: %w", err)
+	}
+	log.Printf("conversation handed off to a human: user=%s reason=%s", recipient, reason)
 
-	modelID := strings.TrimSpace(cfg.OpenAIModel)
-	if modelID == "" {
-		modelID = "gpt-4o-mini"
+	return b.sendNotice(ctx, recipient, text, cfg.HandoffMessage)
+}
+
+// recordHumanReply stores a message typed by a human on the bot's WhatsApp
+// account as an assistant turn, so the model sees it when it resumes the
+// conversation. It never triggers a reply. Echoes of the bot's own sends are
+// skipped.
+func (b *Bot) recordHumanReply(ctx context.Context, cfg *model.Config, sender string, msg model.WebhookMessage, key model.WebhookKey) {
+	if !cfg.RecordFromMe || b.Store == nil {
+		return
 	}
 
-	resp, err := oa.CreateChatCompletion(ctx, openai.ChatCompletionRequest{
-		Model:    modelID,
-		Messages: conversation,
-	})
-	if err != nil {
-		return "", err
+	text := extractMessageText(msg)
+	recipient := resolveRecipient(cfg, key, msg, sender)
+	now := time.Now()
+	if text == "" || recipient == "" || b.sent.containsID(key.ID, now) || b.sent.contains(recipient, text, now) {
+		return
 	}
 
-	if len(resp.Choices) == 0 || resp.Choices[0].Message.Content == "" {
-		return "", nil
+	user := normalizeWhatsAppID(recipient)
+	for attempt := 1; ; attempt++ {
+		conversation, version, err := loadConversation(ctx, b.Store, user)
+		if err != nil {
+			log.Printf("conversation load failed for %s: %v", user, err)
+			return
+		}
+
+		if n := len(conversation); n > 0 && conversation[n-1].Role == openai.ChatMessageRoleAssistant && conversation[n-1].Content == text {
+			return
+		}
+
+		conversation = append(conversation, openai.ChatCompletionMessage{
+			Role:    openai.ChatMessageRoleAssistant,
+			Content: text,
+		})
+
+		err = persistConversation(ctx, b.Store, cfg, user, conversation, version, messageExpiration(msg))
+		if errors.Is(err, ErrConversationConflict) && attempt < maxConversationSaveAttempts {
+			continue
+		}
+		if err != nil {
+			log.Printf("conversation save failed for %s: %v", user, err)
+			return
+		}
+
+		log.Printf("recorded human reply from the business account for %s", recipient)
+		return
+	}
+}
+
+// markRead sends a read receipt for the inbound message. Failures are only
+// logged since the receipt is cosmetic.
+func markRead(ctx context.Context, evo *EvolutionClient, key model.WebhookKey) {
+	if key.ID == "" || key.RemoteJID == "" {
+		return
+	}
+	if err := evo.MarkMessageAsRead(ctx, key); err != nil {
+		log.Printf("mark as read failed for %s: %v", key.ID, err)
+	}
+}
+
+// markReadIfSent marks the message read once a reply other than a generated
+// one went out, i.e. when sendErr is nil, for the timings that would otherwise
+// only mark it read with a generated reply. It returns sendErr.
+func (b *Bot) markReadIfSent(ctx context.Context, cfg *model.Config, key model.WebhookKey, sendErr error) error {
+	if sendErr == nil && (cfg.MarkReadTiming == MarkReadAfterReply || cfg.HumanizeProfile != HumanizeOff) {
+		markRead(ctx, b.Evolution, key)
 	}
+	return sendErr
+}
+
+// replyOptions carries per-message settings for generateAssistantReply.
+type replyOptions struct {
+	// expiration is the chat's disappearing-messages timer, zero if disabled.
+	expiration time.Duration
+	// language is the language to reply in, or "" to leave it to the model.
+	language string
+	// temperature overrides the API default when set.
+	temperature *float32
+	// scorer gates the reply on its confidence when set.
+	scorer ConfidenceScorer
+}
 
-	reply := strings.TrimSpace(resp.Choices[0].Message.Content)
-	if reply == "" {
+func generateAssistantReply(ctx context.Context, oa *openai.Client, store ConversationStore, cfg *model.Config, recipient string, userInput string, opts replyOptions) (string, error) {
+	normalizedID := normalizeWhatsAppID(recipient)
+	if normalizedID == "" {
 		return "", nil
 	}
 
-	conversation = append(conversation, openai.ChatCompletionMessage{
-		Role:    openai.ChatMessageRoleAssistant,
-		Content: reply,
-	})
+	// A conflicting save means another message for this user was answered
+	// while we were generating, so the whole cycle is redone on fresh history.
+	for attempt := 1; ; attempt++ {
+		conversation, version, err := loadConversation(ctx, store, normalizedID)
+		if err != nil {
+			log.Printf("conversation load failed for %s: %v", normalizedID, err)
+		}
+
+		conversation = normalizeTurns(append(conversation, openai.ChatCompletionMessage{
+			Role:    openai.ChatMessageRoleUser,
+			Content: userInput,
+		}))
+
+		content, err := completeReply(ctx, oa, cfg, openai.ChatCompletionRequest{
+			Model:            cfg.OpenAIModel,
+			Messages:         promptMessages(cfg, conversation, time.Now(), opts.language),
+			Stop:             cfg.OpenAIStop,
+			PresencePenalty:  cfg.OpenAIPresencePenalty,
+			FrequencyPenalty: cfg.OpenAIFrequencyPenalty,
+			Seed:             cfg.OpenAISeed,
+			Temperature:      requestTemperature(opts.temperature),
+		})
+		if err != nil {
+			return "", err
+		}
+
+		reply := strings.TrimSpace(content)
+		if reply == "" {
+			return "", nil
+		}
 
-	if store != nil {
-		if err := store.SaveConversation(ctx, normalizedID, conversation); err != nil {
+		// A discarded reply is not stored, so the model does not build on an
+		// answer the user never saw.
+		if opts.scorer != nil {
+			score, err := opts.scorer.Score(ctx, cfg, userInput, reply)
+			if err != nil {
+				log.Printf("confidence scoring failed for %s, sending reply unchecked: %v", normalizedID, err)
+			} else if score < cfg.ConfidenceThreshold {
+				return "", &lowConfidenceError{score: score}
+			}
+		}
+
+		conversation = append(conversation, openai.ChatCompletionMessage{
+			Role:    openai.ChatMessageRoleAssistant,
+			Content: reply,
+		})
+
+		err = persistConversation(ctx, store, cfg, normalizedID, conversation, version, opts.expiration)
+		if errors.Is(err, ErrConversationConflict) && attempt < maxConversationSaveAttempts {
+			log.Printf("conversation for %s changed during generation, retrying (attempt %d)", normalizedID, attempt)
+			continue
+		}
+		if err != nil {
 			log.Printf("conversation save failed for %s: %v", normalizedID, err)
 		}
-	}
 
-	return reply, nil
+		return reply, nil
+	}
 }
 
 func extractMessageText(msg model.WebhookMessage) string {
@@ -217,22 +546,131 @@ func extractMessageText(msg model.WebhookMessage) string {
 		}
 	}
 
+	if msg.TemplateButtonReplyMessage != nil {
+		if trimmed := strings.TrimSpace(msg.TemplateButtonReplyMessage.SelectedDisplayText); trimmed != "" {
+			return trimmed
+		}
+		if trimmed := strings.TrimSpace(msg.TemplateButtonReplyMessage.SelectedID); trimmed != "" {
+			return trimmed
+		}
+	}
+
 	if msg.InteractiveResponseMessage != nil && msg.InteractiveResponseMessage.Body != nil {
 		if trimmed := strings.TrimSpace(msg.InteractiveResponseMessage.Body.Text); trimmed != "" {
 			return trimmed
 		}
 	}
 
+	// Only the caption of media messages is used; the attachment itself is
+	// not analysed, so the model is told what kind of file came with it.
+	if msg.VideoMessage != nil {
+		if trimmed := strings.TrimSpace(msg.VideoMessage.Caption); trimmed != "" {
+			return "[The user attached a video] " + trimmed
+		}
+	}
+
+	if msg.DocumentMessage != nil {
+		if trimmed := strings.TrimSpace(msg.DocumentMessage.Caption); trimmed != "" {
+			if name := strings.TrimSpace(msg.DocumentMessage.FileName); name != "" {
+				return fmt.Sprintf("[The user attached a document: %s] %s", name, trimmed)
+			}
+			return "[The user attached a document] " + trimmed
+		}
+	}
+
 	return ""
 }
 
-func chooseRecipient(values ...string) string {
-	for _, value := range values {
-		if normalized := normalizeWhatsAppID(value); normalized != "" {
-			return normalized
+// instanceAllowed reports whether a webhook for the given instance should be
+// processed. Payloads that do not name an instance are rejected unless
+// EVOLUTION_ALLOW_MISSING_INSTANCE is set.
+func instanceAllowed(cfg *model.Config, instance string) bool {
+	instance = strings.TrimSpace(instance)
+	if instance == "" {
+		return cfg.AllowMissingInstance
+	}
+
+	for _, allowed := range cfg.AllowedInstances {
+		if allowed == instance {
+			return true
 		}
 	}
-	return ""
+	return false
+}
+
+// reactionAction maps an emoji reaction to its configured action, which is
+// then handled as if the user had typed it. Unmapped reactions and reaction
+// removals (empty text) yield "".
+func reactionAction(cfg *model.Config, msg model.WebhookMessage) string {
+	if msg.ReactionMessage == nil {
+		return ""
+	}
+
+	emoji := strings.TrimSpace(msg.ReactionMessage.Text)
+	action, ok := cfg.ReactionActions[emoji]
+	if !ok {
+		return ""
+	}
+
+	log.Printf("reaction %s on message %s mapped to action %q", emoji, msg.ReactionMessage.Key.ID, action)
+	return action
+}
+
+func isGroupJID(jid string) bool {
+	return strings.HasSuffix(strings.TrimSpace(jid), "@g.us")
+}
+
+// labelSpeaker prefixes a group message with who sent it, e.g.
+// "[Maria (5511999999999)]: hi", so the model can tell participants apart.
+func labelSpeaker(pushName, participant, text string) string {
+	name := strings.TrimSpace(pushName)
+	number := normalizeWhatsAppID(participant)
+
+	switch {
+	case name != "" && number != "":
+		return fmt.Sprintf("[%s (%s)]: %s", name, number, text)
+	case name != "":
+		return fmt.Sprintf("[%s]: %s", name, text)
+	case number != "":
+		return fmt.Sprintf("[%s]: %s", number, text)
+	default:
+		return text
+	}
+}
+
+// recipientCandidate is a payload field that may identify the chat to reply
+// to, named after where it came from.
+type recipientCandidate struct {
+	source string
+	value  string
+}
+
+// resolveRecipient picks the chat a reply to msg should go to and logs, at
+// debug level, which payload field it came from.
+func resolveRecipient(cfg *model.Config, key model.WebhookKey, msg model.WebhookMessage, sender string) string {
+	recipient, source := chooseRecipient(
+		recipientCandidate{source: "key.remoteJid", value: key.RemoteJID},
+		recipientCandidate{source: "message.from", value: msg.From},
+		recipientCandidate{source: "sender", value: sender},
+	)
+	debugf(cfg, "message %s: recipient %q resolved from %s", key.ID, recipient, source)
+	return recipient
+}
+
+// chooseRecipient returns the first usable candidate along with its source.
+// Group JIDs are kept whole so replies go to the group rather than to a
+// number that merely looks like one; a participant never wins over the group
+// because the group's remoteJid is always tried first.
+func chooseRecipient(candidates ...recipientCandidate) (string, string) {
+	for _, candidate := range candidates {
+		if isGroupJID(candidate.value) {
+			return strings.TrimSpace(candidate.value), candidate.source
+		}
+		if normalized := normalizeWhatsAppID(candidate.value); normalized != "" {
+			return normalized, candidate.source
+		}
+	}
+	return "", "none"
 }
 
 func normalizeWhatsAppID(id string) string {
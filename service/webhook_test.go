@@ -0,0 +1,422 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"regexp"
+	"slices"
+	"testing"
+	"time"
+
+	openai "github.com/sashabaranov/go-openai"
+
+	"hackathon/model"
+)
+
+// upsertPayload builds a messages.upsert webhook body for instance.
+func upsertPayload(t *testing.T, instance string, entry model.MessagesUpsertEntry) []byte {
+	t.Helper()
+
+	data, err := json.Marshal(entry)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, err := json.Marshal(model.WebhookPayload{Event: "messages.upsert", Instance: instance, Data: data})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return body
+}
+
+func messageEntry(msg model.WebhookMessage, key model.WebhookKey) model.MessagesUpsertEntry {
+	return model.MessagesUpsertEntry{Key: key, Message: msg}
+}
+
+func TestInstanceAllowed(t *testing.T) {
+	cfg := testConfig("")
+	cfg.AllowedInstances = []string{"bot", "backup"}
+
+	for _, tc := range []struct {
+		instance     string
+		allowMissing bool
+		want         bool
+	}{
+		{"bot", false, true},
+		{" backup ", false, true},
+		{"other", false, false},
+		{"Bot", false, false},
+		{"", false, false},
+		{"  ", false, false},
+		{"", true, true},
+		{"other", true, false},
+	} {
+		cfg.AllowMissingInstance = tc.allowMissing
+		if got := instanceAllowed(cfg, tc.instance); got != tc.want {
+			t.Errorf("instanceAllowed(%q, allowMissing=%v) = %v, want %v", tc.instance, tc.allowMissing, got, tc.want)
+		}
+	}
+}
+
+func TestHandlePayloadChecksInstance(t *testing.T) {
+	for _, tc := range []struct {
+		instance string
+		replies  int
+	}{
+		{"bot", 1},
+		{"someone-else", 0},
+		{"", 0},
+	} {
+		evo, oa := newFakeEvolution(t), newFakeOpenAI(t)
+		bot := newTestBot(testConfig(evo.URL), evo, oa)
+
+		msg, key := textMessage("in-1", "hi")
+		body := upsertPayload(t, tc.instance, model.MessagesUpsertEntry{Key: key, Message: msg})
+		if err := bot.handlePayload(context.Background(), body); err != nil {
+			t.Fatal(err)
+		}
+
+		if got := len(evo.Texts()); got != tc.replies {
+			t.Errorf("instance %q: sent %d replies, want %d", tc.instance, got, tc.replies)
+		}
+	}
+}
+
+func TestExtractMessageTextMediaCaptions(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		msg  model.WebhookMessage
+		want string
+	}{
+		{"video", model.WebhookMessage{VideoMessage: &model.VideoMessage{Caption: " look at this "}}, "[The user attached a video] look at this"},
+		{"video without caption", model.WebhookMessage{VideoMessage: &model.VideoMessage{Mimetype: "video/mp4"}}, ""},
+		{"document", model.WebhookMessage{DocumentMessage: &model.DocumentMessage{Caption: "my invoice", FileName: "invoice.pdf"}}, "[The user attached a document: invoice.pdf] my invoice"},
+		{"document without name", model.WebhookMessage{DocumentMessage: &model.DocumentMessage{Caption: "my invoice"}}, "[The user attached a document] my invoice"},
+		{"document without caption", model.WebhookMessage{DocumentMessage: &model.DocumentMessage{FileName: "invoice.pdf"}}, ""},
+		{"text wins over caption", model.WebhookMessage{Conversation: "hi", VideoMessage: &model.VideoMessage{Caption: "clip"}}, "hi"},
+	} {
+		if got := extractMessageText(tc.msg); got != tc.want {
+			t.Errorf("%s: extractMessageText = %q, want %q", tc.name, got, tc.want)
+		}
+	}
+}
+
+func TestExtractMessageTextTemplateButtonReply(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		raw  string
+		want string
+	}{
+		{"display text", `{"templateButtonReplyMessage":{"selectedId":"opt-yes","selectedDisplayText":" Yes, confirm ","selectedIndex":0}}`, "Yes, confirm"},
+		{"id without display text", `{"templateButtonReplyMessage":{"selectedId":"opt-no","selectedIndex":1}}`, "opt-no"},
+		{"empty", `{"templateButtonReplyMessage":{"selectedIndex":2}}`, ""},
+	} {
+		var msg model.WebhookMessage
+		if err := json.Unmarshal([]byte(tc.raw), &msg); err != nil {
+			t.Fatalf("%s: %v", tc.name, err)
+		}
+		if got := extractMessageText(msg); got != tc.want {
+			t.Errorf("%s: extractMessageText = %q, want %q", tc.name, got, tc.want)
+		}
+	}
+}
+
+func TestMarkReadTiming(t *testing.T) {
+	const markRead, sendText = "/chat/markMessageAsRead/bot", "/message/sendText/bot"
+
+	for _, tc := range []struct {
+		timing string
+		fail   bool
+		want   []string
+	}{
+		{MarkReadOnReceive, false, []string{markRead, sendText}},
+		{MarkReadOnReceive, true, []string{markRead}},
+		{MarkReadAfterReply, false, []string{markRead, sendText}},
+		{MarkReadAfterReply, true, nil},
+		{MarkReadOff, false, []string{sendText}},
+	} {
+		evo, oa := newFakeEvolution(t), newFakeOpenAI(t)
+		cfg := testConfig(evo.URL)
+		cfg.MarkReadTiming = tc.timing
+		if tc.fail {
+			oa.Reply(fakeCompletion{Status: http.StatusBadRequest})
+		}
+		bot := newTestBot(cfg, evo, oa)
+
+		msg, key := textMessage("in-1", "hi")
+		err := bot.handleMessage(context.Background(), cfg, "", "", msg, key)
+		if tc.fail != (err != nil) {
+			t.Errorf("%s (fail=%v): handleMessage error = %v", tc.timing, tc.fail, err)
+		}
+
+		if got := evo.Paths(); !slices.Equal(got, tc.want) {
+			t.Errorf("%s (fail=%v): requests = %q, want %q", tc.timing, tc.fail, got, tc.want)
+		}
+		if tc.timing == MarkReadAfterReply && !tc.fail && len(oa.Requests()) != 1 {
+			t.Errorf("%s: the reply was not generated before the receipt", tc.timing)
+		}
+	}
+}
+
+func TestGenerateAssistantReplySendsSamplingSettings(t *testing.T) {
+	oa := newFakeOpenAI(t)
+	cfg := testConfig("")
+	cfg.OpenAIStop = []string{"END", "###"}
+	cfg.OpenAIPresencePenalty = 0.5
+	cfg.OpenAIFrequencyPenalty = -1
+
+	if _, err := generateAssistantReply(context.Background(), oa.Client(), nil, cfg, "5511999999999", "hi", replyOptions{}); err != nil {
+		t.Fatal(err)
+	}
+
+	requests := oa.Requests()
+	if len(requests) != 1 {
+		t.Fatalf("got %d requests, want 1", len(requests))
+	}
+	req := requests[0]
+	if !slices.Equal(req.Stop, cfg.OpenAIStop) {
+		t.Errorf("stop = %q, want %q", req.Stop, cfg.OpenAIStop)
+	}
+	if req.PresencePenalty != 0.5 || req.FrequencyPenalty != -1 {
+		t.Errorf("penalties = %v, %v", req.PresencePenalty, req.FrequencyPenalty)
+	}
+}
+
+func TestReactionActions(t *testing.T) {
+	body := []byte(`{
+		"event": "messages.upsert",
+		"instance": "bot",
+		"data": {
+			"key": {"remoteJid": "5511999999999@s.whatsapp.net", "fromMe": false, "id": "in-%d"},
+			"messageType": "reactionMessage",
+			"message": {"reactionMessage": {"key": {"id": "sent-1"}, "text": "%s", "senderTimestampMs": 1717400000000}}
+		}
+	}`)
+
+	for i, tc := range []struct {
+		emoji   string
+		wantAsk string
+	}{
+		{"👍", "yes, please"},
+		{"❤️", ""},
+		{"", ""},
+	} {
+		evo, oa := newFakeEvolution(t), newFakeOpenAI(t)
+		cfg := testConfig(evo.URL)
+		cfg.ReactionActions = map[string]string{"👍": "yes, please"}
+		bot := newTestBot(cfg, evo, oa)
+
+		if err := bot.handlePayload(context.Background(), []byte(fmt.Sprintf(string(body), i, tc.emoji))); err != nil {
+			t.Fatal(err)
+		}
+
+		requests := oa.Requests()
+		if tc.wantAsk == "" {
+			if len(requests) != 0 || len(evo.Texts()) != 0 {
+				t.Errorf("reaction %q was answered", tc.emoji)
+			}
+			continue
+		}
+		if len(requests) != 1 {
+			t.Fatalf("reaction %q: %d completions, want 1", tc.emoji, len(requests))
+		}
+		last := requests[0].Messages[len(requests[0].Messages)-1]
+		if last.Content != tc.wantAsk {
+			t.Errorf("reaction %q sent %q to the model, want %q", tc.emoji, last.Content, tc.wantAsk)
+		}
+		if texts := evo.Texts(); len(texts) != 1 {
+			t.Errorf("reaction %q: sent %q, want one reply", tc.emoji, texts)
+		}
+	}
+}
+
+func TestFromMeMessageRecordedAsAssistantTurn(t *testing.T) {
+	evo, oa := newFakeEvolution(t), newFakeOpenAI(t)
+	cfg := testConfig(evo.URL)
+	cfg.RecordFromMe = true
+	_, client := newTestRedis(t)
+	bot := newTestBot(cfg, evo, oa)
+	bot.Store = NewRedisConversationStore(client, cfg)
+
+	ctx := context.Background()
+	msg, key := textMessage("out-1", "A colleague will call you today.")
+	key.FromMe = true
+	if err := bot.handlePayload(ctx, upsertPayload(t, "bot", messageEntry(msg, key))); err != nil {
+		t.Fatal(err)
+	}
+
+	// The bot's own sends echo back as fromMe and must not be recorded.
+	if err := bot.sendText(ctx, "5511999999999", "Hello!"); err != nil {
+		t.Fatal(err)
+	}
+	msg, key = textMessage("sent-1", "Hello!")
+	key.FromMe = true
+	if err := bot.handlePayload(ctx, upsertPayload(t, "bot", messageEntry(msg, key))); err != nil {
+		t.Fatal(err)
+	}
+
+	messages, err := bot.Store.GetConversation(ctx, "5511999999999")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(messages) != 1 || messages[0].Role != openai.ChatMessageRoleAssistant || messages[0].Content != "A colleague will call you today." {
+		t.Errorf("stored %+v, want only the human reply as an assistant turn", messages)
+	}
+	if len(oa.Requests()) != 0 {
+		t.Error("OpenAI was called for a fromMe message")
+	}
+	if texts := evo.Texts(); len(texts) != 1 {
+		t.Errorf("sent %q, want only the explicit send", texts)
+	}
+}
+
+func TestGroupSpeakerLabels(t *testing.T) {
+	group := model.WebhookKey{RemoteJID: "120363000000000000@g.us", Participant: "5511888888888@s.whatsapp.net", ID: "in-1"}
+	direct := model.WebhookKey{RemoteJID: "5511888888888@s.whatsapp.net", ID: "in-1"}
+
+	for _, tc := range []struct {
+		name    string
+		labels  bool
+		key     model.WebhookKey
+		wantAsk string
+	}{
+		{"group", true, group, "[Maria (5511888888888)]: hi all"},
+		{"direct chat", true, direct, "hi all"},
+		{"labels off", false, group, "hi all"},
+	} {
+		evo, oa := newFakeEvolution(t), newFakeOpenAI(t)
+		cfg := testConfig(evo.URL)
+		cfg.GroupSpeakerLabels = tc.labels
+		bot := newTestBot(cfg, evo, oa)
+
+		if err := bot.handleMessage(context.Background(), cfg, "", "Maria", model.WebhookMessage{Conversation: "hi all"}, tc.key); err != nil {
+			t.Fatal(err)
+		}
+
+		requests := oa.Requests()
+		if len(requests) != 1 {
+			t.Fatalf("%s: %d completions, want 1", tc.name, len(requests))
+		}
+		if last := requests[0].Messages[len(requests[0].Messages)-1]; last.Content != tc.wantAsk {
+			t.Errorf("%s: model saw %q, want %q", tc.name, last.Content, tc.wantAsk)
+		}
+	}
+}
+
+func TestMessageTimeoutSendsTimeoutReplyOnly(t *testing.T) {
+	evo, oa := newFakeEvolution(t), newFakeOpenAI(t)
+	release := make(chan struct{})
+	defer close(release)
+	oa.Handle("/v1/chat/completions", func(w http.ResponseWriter, r *http.Request) {
+		// A stage slow enough to miss the deadline, finishing afterwards.
+		select {
+		case <-release:
+		case <-time.After(300 * time.Millisecond):
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"choices":[{"message":{"role":"assistant","content":"Too late."},"finish_reason":"stop"}]}`))
+	})
+
+	cfg := testConfig(evo.URL)
+	cfg.MessageTimeout = 50 * time.Millisecond
+	bot := newTestBot(cfg, evo, oa)
+
+	msg, key := textMessage("in-1", "hi")
+	if err := bot.processWebhookMessage(context.Background(), cfg, "", "", msg, key); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("processWebhookMessage error = %v, want the deadline", err)
+	}
+
+	time.Sleep(400 * time.Millisecond)
+	if texts := evo.Texts(); !slices.Equal(texts, []string{cfg.TimeoutMessage}) {
+		t.Errorf("sent %q, want only the timeout message", texts)
+	}
+}
+
+func TestGenerateAssistantReplySeed(t *testing.T) {
+	seed := 42
+	for _, configured := range []*int{&seed, nil} {
+		oa := newFakeOpenAI(t)
+		var raw []byte
+		oa.Handle("/v1/chat/completions", func(w http.ResponseWriter, r *http.Request) {
+			raw, _ = io.ReadAll(r.Body)
+			w.Write([]byte(`{"choices":[{"message":{"role":"assistant","content":"ok"},"finish_reason":"stop"}]}`))
+		})
+		cfg := testConfig("")
+		cfg.OpenAISeed = configured
+
+		if _, err := generateAssistantReply(context.Background(), oa.Client(), nil, cfg, "5511999999999", "hi", replyOptions{}); err != nil {
+			t.Fatal(err)
+		}
+
+		var body map[string]any
+		if err := json.Unmarshal(raw, &body); err != nil {
+			t.Fatal(err)
+		}
+		got, present := body["seed"]
+		switch {
+		case configured != nil && got != float64(42):
+			t.Errorf("seed = %v, want 42", got)
+		case configured == nil && present:
+			t.Errorf("seed %v sent although none is configured", got)
+		}
+	}
+}
+
+func TestInboundFilter(t *testing.T) {
+	evo, oa := newFakeEvolution(t), newFakeOpenAI(t)
+	cfg := testConfig(evo.URL)
+	cfg.InboundFilter = regexp.MustCompile(`(?i)^bot\b`)
+	bot := newTestBot(cfg, evo, oa)
+
+	for _, text := range []string{"bot what time is it", "just chatting"} {
+		msg, key := textMessage("in-"+text, text)
+		if err := bot.handlePayload(context.Background(), upsertPayload(t, "bot", messageEntry(msg, key))); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	requests := oa.Requests()
+	if len(requests) != 1 {
+		t.Fatalf("OpenAI called %d times, want only for the matching message", len(requests))
+	}
+	if last := requests[0].Messages[len(requests[0].Messages)-1]; last.Content != "bot what time is it" {
+		t.Errorf("replied to %q, want the matching message", last.Content)
+	}
+	if got := len(evo.Texts()); got != 1 {
+		t.Errorf("sent %d replies, want 1", got)
+	}
+}
+
+func TestResolveRecipient(t *testing.T) {
+	cfg := testConfig("")
+	for _, tc := range []struct {
+		name   string
+		key    model.WebhookKey
+		from   string
+		sender string
+		want   string
+		source string
+	}{
+		{"remoteJid wins", model.WebhookKey{RemoteJID: "5511999999999@s.whatsapp.net"}, "5511777777777@c.us", "5511666666666", "5511999999999", "key.remoteJid"},
+		{"message.from", model.WebhookKey{}, "+5511777777777@c.us", "5511666666666", "5511777777777", "message.from"},
+		{"sender", model.WebhookKey{RemoteJID: "  "}, "", "5511666666666@s.whatsapp.net", "5511666666666", "sender"},
+		{"group kept whole", model.WebhookKey{RemoteJID: " 120363025@g.us ", Participant: "5511999999999@s.whatsapp.net"}, "", "", "120363025@g.us", "key.remoteJid"},
+		{"group from message.from", model.WebhookKey{}, "120363025@g.us", "5511666666666", "120363025@g.us", "message.from"},
+		{"none", model.WebhookKey{}, "", "", "", "none"},
+	} {
+		if got := resolveRecipient(cfg, tc.key, model.WebhookMessage{From: tc.from}, tc.sender); got != tc.want {
+			t.Errorf("%s: recipient %q, want %q", tc.name, got, tc.want)
+		}
+		_, source := chooseRecipient(
+			recipientCandidate{source: "key.remoteJid", value: tc.key.RemoteJID},
+			recipientCandidate{source: "message.from", value: tc.from},
+			recipientCandidate{source: "sender", value: tc.sender},
+		)
+		if source != tc.source {
+			t.Errorf("%s: resolved from %s, want %s", tc.name, source, tc.source)
+		}
+	}
+}